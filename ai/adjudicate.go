@@ -0,0 +1,85 @@
+package ai
+
+import "github.com/nelhage/taktician/tak"
+
+// AdjudicateConfig configures an Adjudicator. A zero WinPlies or
+// DrawPlies disables the corresponding rule.
+type AdjudicateConfig struct {
+	// The game is adjudicated as a win once one side's
+	// evaluation has exceeded WinMargin for WinPlies
+	// consecutive plies.
+	WinMargin int64
+	WinPlies  int
+
+	// The game is adjudicated as a draw once it has lasted
+	// DrawPlies plies and the evaluation has stayed within
+	// DrawMargin of zero for the last DrawQuietPlies plies.
+	DrawPlies      int
+	DrawMargin     int64
+	DrawQuietPlies int
+}
+
+// An Adjudicator decides games early in match play, when the
+// evaluation makes the outcome clear before the game is over. An
+// Adjudicator tracks the evaluation across plies and should be used
+// for a single game.
+type Adjudicator struct {
+	cfg AdjudicateConfig
+
+	leader tak.Color
+	streak int
+	quiet  int
+}
+
+func NewAdjudicator(cfg AdjudicateConfig) *Adjudicator {
+	return &Adjudicator{cfg: cfg}
+}
+
+// Adjudicate records the evaluation `eval` of `p`, from the
+// perspective of the player to move (as returned by Analyze), after
+// `plies` plies of the game have been played, and reports whether the
+// game should end, and who won. Games that are actually over, or that
+// the search has proven won, are decided immediately.
+func (a *Adjudicator) Adjudicate(p *tak.Position, eval int64, plies int) (over bool, winner tak.Color) {
+	if over, winner := p.GameOver(); over {
+		return over, winner
+	}
+	if p.ToMove() == tak.Black {
+		eval = -eval
+	}
+	if eval > WinThreshold {
+		return true, tak.White
+	}
+	if eval < -WinThreshold {
+		return true, tak.Black
+	}
+
+	leader := tak.NoColor
+	if a.cfg.WinMargin > 0 {
+		if eval > a.cfg.WinMargin {
+			leader = tak.White
+		} else if eval < -a.cfg.WinMargin {
+			leader = tak.Black
+		}
+	}
+	if leader != tak.NoColor && leader == a.leader {
+		a.streak++
+	} else {
+		a.streak = 1
+	}
+	a.leader = leader
+	if leader != tak.NoColor && a.cfg.WinPlies > 0 && a.streak >= a.cfg.WinPlies {
+		return true, leader
+	}
+
+	if eval <= a.cfg.DrawMargin && eval >= -a.cfg.DrawMargin {
+		a.quiet++
+	} else {
+		a.quiet = 0
+	}
+	if a.cfg.DrawPlies > 0 && plies >= a.cfg.DrawPlies &&
+		a.quiet >= a.cfg.DrawQuietPlies {
+		return true, tak.NoColor
+	}
+	return false, tak.NoColor
+}
@@ -0,0 +1,96 @@
+package ai
+
+import (
+	"testing"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+func adjudicatePositions(t *testing.T) (white, black *tak.Position) {
+	p := tak.New(tak.Config{Size: 5})
+	for _, m := range []tak.Move{
+		{X: 0, Y: 0, Type: tak.PlaceFlat},
+		{X: 4, Y: 4, Type: tak.PlaceFlat},
+		{X: 2, Y: 2, Type: tak.PlaceFlat},
+	} {
+		var e error
+		if p, e = p.Move(&m); e != nil {
+			t.Fatal("move:", e)
+		}
+		if p.ToMove() == tak.White {
+			white = p
+		} else {
+			black = p
+		}
+	}
+	return white, black
+}
+
+func TestAdjudicateWin(t *testing.T) {
+	white, black := adjudicatePositions(t)
+	a := NewAdjudicator(AdjudicateConfig{WinMargin: 1000, WinPlies: 4})
+	// evaluations are relative to the player to move, so black
+	// is winning throughout this sequence.
+	steps := []struct {
+		p    *tak.Position
+		eval int64
+	}{
+		{white, -2000},
+		{black, 2000},
+		{white, -500},
+		{black, 2000},
+		{white, -2000},
+		{black, 1500},
+		{white, -3000},
+	}
+	for i, st := range steps {
+		over, winner := a.Adjudicate(st.p, st.eval, 10+i)
+		if i < len(steps)-1 {
+			if over {
+				t.Fatalf("[%d] adjudicated early: %s", i, winner)
+			}
+			continue
+		}
+		if !over || winner != tak.Black {
+			t.Fatalf("[%d] over=%v winner=%s, want black", i, over, winner)
+		}
+	}
+
+	a = NewAdjudicator(AdjudicateConfig{})
+	if over, winner := a.Adjudicate(white, MaxEval-10, 3); !over || winner != tak.White {
+		t.Fatalf("proven win: over=%v winner=%s", over, winner)
+	}
+}
+
+func TestAdjudicateDraw(t *testing.T) {
+	white, black := adjudicatePositions(t)
+	a := NewAdjudicator(AdjudicateConfig{
+		WinMargin:      1000,
+		WinPlies:       4,
+		DrawPlies:      100,
+		DrawMargin:     50,
+		DrawQuietPlies: 3,
+	})
+	for i := 0; i < 100; i++ {
+		p := white
+		if i%2 == 1 {
+			p = black
+		}
+		eval := int64(10)
+		if i == 99 {
+			eval = 400
+		}
+		over, winner := a.Adjudicate(p, eval, i+1)
+		if over {
+			t.Fatalf("[%d] adjudicated early: %s", i, winner)
+		}
+	}
+	for i := 100; i < 102; i++ {
+		if over, _ := a.Adjudicate(white, 0, i+1); over {
+			t.Fatalf("[%d] adjudicated before quiet", i)
+		}
+	}
+	if over, winner := a.Adjudicate(black, 0, 103); !over || winner != tak.NoColor {
+		t.Fatalf("over=%v winner=%s, want draw", over, winner)
+	}
+}
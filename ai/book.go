@@ -0,0 +1,136 @@
+package ai
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+// A BookMove is a move an opening book knows for some position, and
+// its weight: how often it was played, or how well it scored.
+type BookMove struct {
+	Move   tak.Move
+	Weight float64
+}
+
+// A Book maps positions to the moves it knows for them. Positions
+// are looked up by Hash, so a book is specific to one orientation of
+// the board.
+type Book struct {
+	moves map[uint64][]BookMove
+}
+
+func NewBook() *Book {
+	return &Book{moves: make(map[uint64][]BookMove)}
+}
+
+// Add adds `weight` to the weight of `m` in `p`.
+func (b *Book) Add(p *tak.Position, m tak.Move, weight float64) {
+	h := p.Hash()
+	ms := b.moves[h]
+	for i := range ms {
+		if ms[i].Move.Equal(&m) {
+			ms[i].Weight += weight
+			return
+		}
+	}
+	b.moves[h] = append(ms, BookMove{Move: m, Weight: weight})
+}
+
+// Moves returns the moves the book knows for `p`.
+func (b *Book) Moves(p *tak.Position) []BookMove {
+	return b.moves[p.Hash()]
+}
+
+// BookConfig configures a BookPlayer.
+type BookConfig struct {
+	// Temperature shapes how book moves are chosen: each is
+	// played with probability proportional to its weight raised
+	// to 1/Temperature. A zero Temperature is treated as 1,
+	// playing moves in proportion to their weight; lower
+	// temperatures favor the heaviest moves, approaching always
+	// playing the most common one, and higher ones flatten the
+	// choice towards uniform.
+	Temperature float64
+	// Moves weighing less than MinWeight are ignored.
+	MinWeight float64
+	// Seed seeds the choice of move. A zero Seed uses the time.
+	Seed int64
+}
+
+// A BookPlayer plays moves from a Book while it knows the position,
+// and defers to another TakPlayer once it does not.
+type BookPlayer struct {
+	book     *Book
+	fallback TakPlayer
+	cfg      BookConfig
+	r        *rand.Rand
+
+	weights []float64
+}
+
+func NewBookPlayer(book *Book, fallback TakPlayer, cfg BookConfig) *BookPlayer {
+	if cfg.Temperature == 0 {
+		cfg.Temperature = 1
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &BookPlayer{
+		book:     book,
+		fallback: fallback,
+		cfg:      cfg,
+		r:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (b *BookPlayer) GetMove(ctx context.Context, p *tak.Position) tak.Move {
+	if m, ok := b.BookMove(p); ok {
+		return m
+	}
+	return b.fallback.GetMove(ctx, p)
+}
+
+// BookMove chooses a move for `p` from the book, and reports whether
+// the book had any to choose from.
+func (b *BookPlayer) BookMove(p *tak.Position) (tak.Move, bool) {
+	moves := b.book.Moves(p)
+	b.weights = b.weights[:0]
+	var sum float64
+	for _, bm := range moves {
+		w := 0.0
+		if bm.Weight > 0 && bm.Weight >= b.cfg.MinWeight {
+			// Guard against hash collisions, and books
+			// built from a different ruleset.
+			if _, e := p.Move(&bm.Move); e == nil {
+				w = math.Pow(bm.Weight, 1/b.cfg.Temperature)
+			}
+		}
+		b.weights = append(b.weights, w)
+		sum += w
+	}
+	if sum == 0 {
+		return tak.Move{}, false
+	}
+	r := b.r.Float64() * sum
+	last := 0
+	for i, w := range b.weights {
+		if w == 0 {
+			continue
+		}
+		last = i
+		if r -= w; r < 0 {
+			break
+		}
+	}
+	return moves[last].Move, true
+}
+
+func (b *BookPlayer) Name() string {
+	return "book+" + b.fallback.Name()
+}
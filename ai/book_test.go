@@ -0,0 +1,108 @@
+package ai
+
+import (
+	"math"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+// countingPlayer passes, and counts how often it was asked to move.
+type countingPlayer struct {
+	calls int
+}
+
+func (c *countingPlayer) GetMove(ctx context.Context, p *tak.Position) tak.Move {
+	c.calls++
+	return tak.Move{Type: tak.Pass}
+}
+
+func (c *countingPlayer) Name() string {
+	return "counting"
+}
+
+func TestBookPlayer(t *testing.T) {
+	p := tak.New(tak.Config{Size: 5})
+	corners := []tak.Move{
+		{X: 0, Y: 0, Type: tak.PlaceFlat},
+		{X: 4, Y: 0, Type: tak.PlaceFlat},
+		{X: 0, Y: 4, Type: tak.PlaceFlat},
+		{X: 4, Y: 4, Type: tak.PlaceFlat},
+	}
+	book := NewBook()
+	book.Add(p, corners[0], 1)
+	book.Add(p, corners[1], 2)
+	book.Add(p, corners[2], 3)
+	book.Add(p, corners[2], 2)
+	book.Add(p, corners[3], 0.5)
+	// Illegal in the opening, so it must never be played.
+	book.Add(p, tak.Move{X: 2, Y: 2, Type: tak.PlaceCapstone}, 10)
+
+	cases := []struct {
+		temp float64
+		want []float64
+	}{
+		{0, []float64{1, 2, 5}},
+		{0.5, []float64{1, 4, 25}},
+		{100, []float64{1, 1, 1}},
+	}
+	const draws = 20000
+	for _, tc := range cases {
+		fallback := &countingPlayer{}
+		bp := NewBookPlayer(book, fallback, BookConfig{
+			Temperature: tc.temp, MinWeight: 1, Seed: 1,
+		})
+		counts := make([]int, len(corners))
+		for i := 0; i < draws; i++ {
+			m := bp.GetMove(context.Background(), p)
+			found := false
+			for j := range corners {
+				if m.Equal(&corners[j]) {
+					counts[j]++
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("temp=%v: played %#v", tc.temp, m)
+			}
+		}
+		if fallback.calls != 0 {
+			t.Errorf("temp=%v: fell back %d times", tc.temp, fallback.calls)
+		}
+		if counts[3] != 0 {
+			t.Errorf("temp=%v: played a pruned move %d times", tc.temp, counts[3])
+		}
+		var sum float64
+		for _, w := range tc.want {
+			sum += w
+		}
+		for j, w := range tc.want {
+			got := float64(counts[j]) / draws
+			if exp := w / sum; math.Abs(got-exp) > 0.02 {
+				t.Errorf("temp=%v: move %d played %.3f of the time, want %.3f",
+					tc.temp, j, got, exp)
+			}
+		}
+	}
+
+	fallback := &countingPlayer{}
+	bp := NewBookPlayer(book, fallback, BookConfig{Seed: 1})
+	next, _ := p.Move(&corners[0])
+	if m := bp.GetMove(context.Background(), next); m.Type != tak.Pass || fallback.calls != 1 {
+		t.Errorf("out of book: played %#v, fallback called %d times", m, fallback.calls)
+	}
+	if bp.Name() != "book+counting" {
+		t.Errorf("Name()=%q", bp.Name())
+	}
+
+	a := NewBookPlayer(book, fallback, BookConfig{Seed: 7})
+	b := NewBookPlayer(book, fallback, BookConfig{Seed: 7})
+	for i := 0; i < 100; i++ {
+		ma, mb := a.GetMove(context.Background(), p), b.GetMove(context.Background(), p)
+		if !ma.Equal(&mb) {
+			t.Fatalf("draw %d: same seed chose %#v and %#v", i, ma, mb)
+		}
+	}
+}
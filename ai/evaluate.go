@@ -3,6 +3,7 @@ package ai
 import (
 	"fmt"
 	"io"
+	"math"
 	"text/tabwriter"
 
 	"github.com/nelhage/taktician/bitboard"
@@ -27,11 +28,26 @@ type Weights struct {
 	StandingCaptives FlatScores
 	CapstoneCaptives FlatScores
 
+	// Prisoners is the score for each enemy stone buried, at
+	// any depth, in a stack the player controls.
+	Prisoners int
+	// Buried is the score, normally negative, for each of the
+	// player's stones buried below the carry limit in a stack
+	// the opponent controls. Neither player can free such a stone
+	// in a single move.
+	Buried int
+
 	Liberties      int
 	GroupLiberties int
 
 	Groups [8]int
 
+	// LargestGroup is the score for each stone in the player's
+	// largest road group, and Disconnected the score for each
+	// road group (including lone road stones) beyond the first.
+	LargestGroup int
+	Disconnected int
+
 	Potential int
 	Threat    int
 
@@ -117,6 +133,85 @@ var defaultWeights6 = Weights{
 	CenterControl: 10,
 }
 
+var defaultWeights7 = Weights{
+	TopFlat:     400,
+	EndgameFlat: 800,
+	Standing:    200,
+	Capstone:    300,
+
+	FlatCaptives: FlatScores{
+		Hard: 200,
+		Soft: -200,
+	},
+	StandingCaptives: FlatScores{
+		Hard: 300,
+		Soft: -150,
+	},
+	CapstoneCaptives: FlatScores{
+		Hard: 250,
+		Soft: -150,
+	},
+
+	Groups: [8]int{
+		0,   // 0
+		0,   // 1
+		0,   // 2
+		100, // 3
+		300, // 4
+		500, // 5
+		700, // 6
+	},
+
+	Potential: 100,
+	Threat:    300,
+
+	EmptyControl: 20,
+	FlatControl:  50,
+
+	Center:        40,
+	CenterControl: 10,
+}
+
+var defaultWeights8 = Weights{
+	TopFlat:     400,
+	EndgameFlat: 800,
+	Standing:    200,
+	Capstone:    300,
+
+	FlatCaptives: FlatScores{
+		Hard: 200,
+		Soft: -200,
+	},
+	StandingCaptives: FlatScores{
+		Hard: 300,
+		Soft: -150,
+	},
+	CapstoneCaptives: FlatScores{
+		Hard: 250,
+		Soft: -150,
+	},
+
+	Groups: [8]int{
+		0,   // 0
+		0,   // 1
+		0,   // 2
+		100, // 3
+		200, // 4
+		400, // 5
+		600, // 6
+		800, // 7
+	},
+
+	Potential: 100,
+	Threat:    300,
+
+	EmptyControl: 20,
+	FlatControl:  50,
+
+	Center:        40,
+	CenterControl: 10,
+}
+
 var DefaultWeights = []Weights{
 	defaultWeights,  // 0
 	defaultWeights,  // 1
@@ -125,19 +220,102 @@ var DefaultWeights = []Weights{
 	defaultWeights,  // 4
 	defaultWeights,  // 5
 	defaultWeights6, // 6
-	defaultWeights,  // 7
-	defaultWeights,  // 8
+	defaultWeights7, // 7
+	defaultWeights8, // 8
+}
+
+// SetDefaultWeights replaces the default weights used for boards of
+// the given size, by evaluators constructed after the call – in
+// particular, by any MinimaxAI that isn't configured with its own
+// evaluator. It modifies global state, and should be called during
+// program initialization, before any searches start.
+func SetDefaultWeights(size int, w Weights) {
+	DefaultWeights[size] = w
 }
 
 func MakeEvaluator(size int, w *Weights) EvaluationFunc {
+	return MakeCachedEvaluator(size, w, 0)
+}
+
+// MakeCachedEvaluator is like MakeEvaluator, but caches the terms of
+// the evaluation that depend only on the tops of the stacks – groups,
+// liberties, road threats and control – in a table of `entries`
+// entries, which are reused for any position with the same tops. An
+// `entries` of 0 disables the cache. The returned function is not
+// safe for concurrent use.
+func MakeCachedEvaluator(size int, w *Weights, entries int) EvaluationFunc {
 	if w == nil {
-		w = &DefaultWeights[size]
+		dw := DefaultWeights[size]
+		w = &dw
+	}
+	var cache *shapeCache
+	if entries > 0 {
+		cache = &shapeCache{entries: make([]shapeEntry, entries)}
+	}
+	return func(c *bitboard.Constants, p *tak.Position) int64 {
+		return evaluate(c, w, p, cache)
+	}
+}
+
+// GamePhase reports how far `p` has progressed, as the number of
+// pieces both players have placed, out of the `total` they started
+// with.
+func GamePhase(p *tak.Position) (placed, total int) {
+	flats, caps := p.StartingReserves()
+	total = 2 * (flats + caps)
+	placed = total - (p.WhiteStones() + p.BlackStones() + p.WhiteCaps() + p.BlackCaps())
+	if placed < 0 {
+		placed = 0
+	}
+	if placed > total {
+		placed = total
+	}
+	return placed, total
+}
+
+// MakeTaperedEvaluator returns an evaluator that interpolates
+// linearly between the opening and endgame weights of `tw` by the
+// GamePhase of each position, in the manner of a chess engine's
+// tapered evaluation. The blended weights are computed up front, one
+// set per piece of a default game of `size`; a game with other
+// reserves uses the set nearest its phase.
+func MakeTaperedEvaluator(size int, tw *TaperedWeights) EvaluationFunc {
+	cfg := tak.DefaultConfig(size)
+	steps := 2 * (cfg.Pieces + cfg.Capstones)
+	blends := make([]Weights, steps+1)
+	for i := range blends {
+		blends[i] = blendWeights(&tw.Opening, &tw.Endgame, i, steps)
 	}
 	return func(c *bitboard.Constants, p *tak.Position) int64 {
-		return evaluate(c, w, p)
+		placed, total := GamePhase(p)
+		i := (placed*steps + total/2) / total
+		return evaluate(c, &blends[i], p, nil)
 	}
 }
 
+// A shapeCache caches the terms computed by scoreShape, in the
+// manner of a chess engine's pawn hash table.
+type shapeCache struct {
+	entries []shapeEntry
+}
+
+type shapeEntry struct {
+	valid                        bool
+	white, black, standing, caps uint64
+
+	score   int64
+	threats [4]int
+}
+
+func (sc *shapeCache) get(p *tak.Position) *shapeEntry {
+	h := p.White
+	h = h*hashMul ^ p.Black
+	h = h*hashMul ^ p.Standing
+	h = h*hashMul ^ p.Caps
+	h *= hashMul
+	return &sc.entries[h%uint64(len(sc.entries))]
+}
+
 const moveScale = 100
 
 func evaluateTerminal(p *tak.Position, winner tak.Color) int64 {
@@ -157,6 +335,60 @@ func evaluateTerminal(p *tak.Position, winner tak.Color) int64 {
 	}
 }
 
+// evaluateDefense is like evaluateTerminal, but breaks ties between
+// flat wins on the same move by the winner's margin in half-flats,
+// komi included, instead of by the winner's reserves. The margin is
+// capped below moveScale, so a longer game always scores better for
+// the loser.
+func evaluateDefense(p *tak.Position) int64 {
+	d := p.WinDetails()
+	if d.Winner == tak.NoColor || d.Reason != tak.FlatsWin {
+		return evaluateTerminal(p, d.Winner)
+	}
+	margin := int64(2*d.WhiteFlats - (2*d.BlackFlats + p.HalfKomi()))
+	if d.Winner == tak.Black {
+		margin = -margin
+	}
+	if margin >= moveScale {
+		margin = moveScale - 1
+	}
+	v := MaxEval - moveScale*int64(p.MoveNumber()) + margin
+	if d.Winner != p.ToMove() {
+		return -v
+	}
+	return v
+}
+
+// winScale is the evaluation at which WinProbability gives the
+// leader e/(1+e), about 73%. There is no corpus of evaluated games
+// to fit it to, so it was chosen by hand: a lead of about one and a
+// half default-weighted flats.
+const winScale = 600
+
+// WinProbability maps an evaluation to an estimated probability,
+// along a logistic curve, that the player it is from the perspective
+// of wins. It returns exactly 1 or 0 for evaluations beyond
+// WinThreshold, which are proven results, and 0.5 for an evaluation
+// of 0.
+func WinProbability(eval int64) float64 {
+	switch {
+	case eval > WinThreshold:
+		return 1
+	case eval < -WinThreshold:
+		return 0
+	}
+	return 1 / (1 + math.Exp(-float64(eval)/winScale))
+}
+
+// Absolute converts `eval`, an evaluation of `p` from the perspective
+// of the player to move, to one from White's perspective.
+func Absolute(p *tak.Position, eval int64) int64 {
+	if p.ToMove() == tak.Black {
+		return -eval
+	}
+	return eval
+}
+
 func EvaluateWinner(_ *bitboard.Constants, p *tak.Position) int64 {
 	if over, winner := p.GameOver(); over {
 		return evaluateTerminal(p, winner)
@@ -164,15 +396,31 @@ func EvaluateWinner(_ *bitboard.Constants, p *tak.Position) int64 {
 	return 0
 }
 
-func evaluate(c *bitboard.Constants, w *Weights, p *tak.Position) int64 {
+// withoutRoads returns a copy of `w` with the terms that only
+// matter because of road wins set to zero.
+func withoutRoads(w *Weights) Weights {
+	nw := *w
+	nw.Groups = [8]int{}
+	nw.GroupLiberties = 0
+	nw.LargestGroup = 0
+	nw.Disconnected = 0
+	nw.Potential = 0
+	nw.Threat = 0
+	return nw
+}
+
+func evaluate(c *bitboard.Constants, w *Weights, p *tak.Position, cache *shapeCache) int64 {
 	if over, winner := p.GameOver(); over {
 		return evaluateTerminal(p, winner)
 	}
+	if p.NoRoads() {
+		// The cache holds shape terms for the full weights.
+		nw := withoutRoads(w)
+		w, cache = &nw, nil
+	}
 
 	var score int64
 
-	analysis := p.Analysis()
-
 	left := p.WhiteStones()
 	if p.BlackStones() < left {
 		left = p.BlackStones()
@@ -216,6 +464,24 @@ func evaluate(c *bitboard.Constants, w *Weights, p *tak.Position) int64 {
 			sign = -1
 		}
 
+		if w.Prisoners != 0 {
+			enemy := bitboard.Popcount(p.Stacks[i] & ((1 << (h - 1)) - 1))
+			if sign < 0 {
+				enemy = int(h) - 1 - enemy
+			}
+			score += sign * int64(enemy*w.Prisoners)
+		}
+		if reach := p.CarryLimit() - 1; w.Buried != 0 && int(h)-1 > reach {
+			// A slide lifts the top and at most `reach`
+			// stones from under it.
+			deep := p.Stacks[i] &^ ((1 << uint(reach)) - 1) & ((1 << (h - 1)) - 1)
+			buried := bitboard.Popcount(deep)
+			if sign < 0 {
+				buried = int(h) - 1 - reach - buried
+			}
+			score -= sign * int64(buried*w.Buried)
+		}
+
 		switch {
 		case p.Standing&(1<<uint(i)) != 0:
 			score += sign * (int64(hf*w.StandingCaptives.Hard) +
@@ -229,8 +495,39 @@ func evaluate(c *bitboard.Constants, w *Weights, p *tak.Position) int64 {
 		}
 	}
 
-	score += int64(scoreGroups(c, analysis.WhiteGroups, w, p.Black|p.Standing))
-	score -= int64(scoreGroups(c, analysis.BlackGroups, w, p.White|p.Standing))
+	var shape int64
+	var threats [4]int
+	if cache == nil {
+		shape, threats = scoreShape(c, w, p)
+	} else {
+		e := cache.get(p)
+		if !e.valid || e.white != p.White || e.black != p.Black ||
+			e.standing != p.Standing || e.caps != p.Caps {
+			e.score, e.threats = scoreShape(c, w, p)
+			e.valid = true
+			e.white, e.black = p.White, p.Black
+			e.standing, e.caps = p.Standing, p.Caps
+		}
+		shape, threats = e.score, e.threats
+	}
+	score += shape
+	score += scoreThreatCounts(w, p.ToMove(),
+		threats[0], threats[1], threats[2], threats[3])
+
+	if p.ToMove() == tak.White {
+		return score
+	}
+	return -score
+}
+
+// scoreShape computes the terms of the evaluation, from white's
+// perspective, that depend only on the top of each stack, and the
+// counts of road threats from countThreats, if the weights use them.
+func scoreShape(c *bitboard.Constants, w *Weights, p *tak.Position) (int64, [4]int) {
+	analysis := p.Analysis()
+	var score int64
+	score += int64(scoreGroups(c, analysis.WhiteGroups, w, p.White&^p.Standing, p.Black|p.Standing))
+	score -= int64(scoreGroups(c, analysis.BlackGroups, w, p.Black&^p.Standing, p.White|p.Standing))
 
 	if w.Liberties != 0 {
 		wr := p.White &^ p.Standing
@@ -241,16 +538,16 @@ func evaluate(c *bitboard.Constants, w *Weights, p *tak.Position) int64 {
 		score -= int64(w.Liberties * bl)
 	}
 
-	score += scoreThreats(c, w, p)
 	score += scoreControl(c, w, p)
 
-	if p.ToMove() == tak.White {
-		return score
+	var threats [4]int
+	if w.Potential != 0 || w.Threat != 0 {
+		threats[0], threats[1], threats[2], threats[3] = countThreats(c, p)
 	}
-	return -score
+	return score, threats
 }
 
-func scoreGroups(c *bitboard.Constants, gs []uint64, ws *Weights, other uint64) int {
+func scoreGroups(c *bitboard.Constants, gs []uint64, ws *Weights, road, other uint64) int {
 	sc := 0
 	var allg uint64
 	for _, g := range gs {
@@ -260,6 +557,13 @@ func scoreGroups(c *bitboard.Constants, gs []uint64, ws *Weights, other uint64)
 		sc += ws.Groups[h]
 		allg |= g
 	}
+	if ws.LargestGroup != 0 || ws.Disconnected != 0 {
+		largest, n := groupShape(gs, road)
+		sc += largest * ws.LargestGroup
+		if n > 1 {
+			sc += (n - 1) * ws.Disconnected
+		}
+	}
 	if ws.GroupLiberties != 0 {
 		libs := bitboard.Popcount(bitboard.Grow(c, ^other, allg) &^ allg)
 		sc += libs * ws.GroupLiberties
@@ -344,11 +648,18 @@ func scoreThreats(c *bitboard.Constants, ws *Weights, p *tak.Position) int64 {
 	}
 
 	wp, wt, bp, bt := countThreats(c, p)
+	return scoreThreatCounts(ws, p.ToMove(), wp, wt, bp, bt)
+}
 
-	if wp+wt > 0 && p.ToMove() == tak.White {
+func scoreThreatCounts(ws *Weights, toMove tak.Color, wp, wt, bp, bt int) int64 {
+	if ws.Potential == 0 && ws.Threat == 0 {
+		return 0
+	}
+
+	if wp+wt > 0 && toMove == tak.White {
 		return 1 << 20
 	}
-	if bp+bt > 0 && p.ToMove() == tak.Black {
+	if bp+bt > 0 && toMove == tak.Black {
 		return -(1 << 20)
 	}
 
@@ -415,7 +726,13 @@ func scoreControl(c *bitboard.Constants, ws *Weights, p *tak.Position) int64 {
 	return s
 }
 
+// ExplainScore writes a breakdown of `m`'s evaluation of `p` to
+// `out`.
 func ExplainScore(m *MinimaxAI, out io.Writer, p *tak.Position) {
+	m.evaluate.Explain(&m.c, out, p)
+}
+
+func explainFeatures(c *bitboard.Constants, out io.Writer, p *tak.Position) {
 	tw := tabwriter.NewWriter(out, 4, 8, 1, '\t', 0)
 	fmt.Fprintf(tw, "\twhite\tblack\n")
 	var scores [2]struct {
@@ -423,8 +740,10 @@ func ExplainScore(m *MinimaxAI, out io.Writer, p *tak.Position) {
 		standing int
 		caps     int
 
-		stones   int
-		captured int
+		stones    int
+		captured  int
+		prisoners int
+		buried    int
 	}
 
 	scores[0].flats = bitboard.Popcount(p.White &^ (p.Caps | p.Standing))
@@ -448,10 +767,20 @@ func ExplainScore(m *MinimaxAI, out io.Writer, p *tak.Position) {
 		if captured > p.Size()-1 {
 			captured = p.Size() - 1
 		}
+		reach := p.CarryLimit() - 1
+		deep, black := 0, 0
+		if int(h)-1 > reach {
+			deep = int(h) - 1 - reach
+			black = bitboard.Popcount(s &^ ((1 << uint(reach)) - 1))
+		}
 		if p.White&(1<<uint(i)) != 0 {
 			scores[0].captured += captured
+			scores[0].prisoners += bf
+			scores[1].buried += black
 		} else {
 			scores[1].captured += captured
+			scores[1].prisoners += wf
+			scores[0].buried += deep - black
 		}
 	}
 
@@ -460,34 +789,59 @@ func ExplainScore(m *MinimaxAI, out io.Writer, p *tak.Position) {
 	fmt.Fprintf(tw, "caps\t%d\t%d\n", scores[0].caps, scores[1].caps)
 	fmt.Fprintf(tw, "captured\t%d\t%d\n", scores[0].captured, scores[1].captured)
 	fmt.Fprintf(tw, "stones\t%d\t%d\n", scores[0].stones, scores[1].stones)
+	fmt.Fprintf(tw, "prisoners\t%d\t%d\n", scores[0].prisoners, scores[1].prisoners)
+	fmt.Fprintf(tw, "buried\t%d\t%d\n", scores[0].buried, scores[1].buried)
 
 	analysis := p.Analysis()
 
 	wr := p.White &^ p.Standing
 	br := p.Black &^ p.Standing
-	wl := bitboard.Popcount(bitboard.Grow(&m.c, ^p.Black, wr) &^ p.White)
-	bl := bitboard.Popcount(bitboard.Grow(&m.c, ^p.White, br) &^ p.Black)
+	wl := bitboard.Popcount(bitboard.Grow(c, ^p.Black, wr) &^ p.White)
+	bl := bitboard.Popcount(bitboard.Grow(c, ^p.White, br) &^ p.Black)
 
 	fmt.Fprintf(tw, "liberties\t%d\t%d\n", wl, bl)
 
-	wp, wt, bp, bt := countThreats(&m.c, p)
+	wp, wt, bp, bt := countThreats(c, p)
 	fmt.Fprintf(tw, "potential\t%d\t%d\n", wp, bp)
 	fmt.Fprintf(tw, "threat\t%d\t%d\n", wt, bt)
 
+	wlg, wn := groupShape(analysis.WhiteGroups, wr)
+	blg, bn := groupShape(analysis.BlackGroups, br)
+	fmt.Fprintf(tw, "largest\t%d\t%d\n", wlg, blg)
+	fmt.Fprintf(tw, "groups\t%d\t%d\n", wn, bn)
+
 	var allg uint64
 	for i, g := range analysis.WhiteGroups {
-		w, h := bitboard.Dimensions(&m.c, g)
+		w, h := bitboard.Dimensions(c, g)
 		fmt.Fprintf(tw, "g%d\t%dx%x\n", i, w, h)
 		allg |= g
 	}
-	wgl := bitboard.Popcount(bitboard.Grow(&m.c, m.c.Mask&^(p.Black|p.Standing), allg) &^ allg)
+	wgl := bitboard.Popcount(bitboard.Grow(c, c.Mask&^(p.Black|p.Standing), allg) &^ allg)
 	allg = 0
 	for i, g := range analysis.BlackGroups {
-		w, h := bitboard.Dimensions(&m.c, g)
+		w, h := bitboard.Dimensions(c, g)
 		fmt.Fprintf(tw, "g%d\t\t%dx%x\n", i, w, h)
 		allg |= g
 	}
-	bgl := bitboard.Popcount(bitboard.Grow(&m.c, m.c.Mask&^(p.White|p.Standing), allg) &^ allg)
+	bgl := bitboard.Popcount(bitboard.Grow(c, c.Mask&^(p.White|p.Standing), allg) &^ allg)
 	fmt.Fprintf(tw, "gl\t%d\t%d\n", wgl, bgl)
 	tw.Flush()
 }
+
+// groupShape returns the size of the largest road group among `gs`
+// and the number of separate road groups, counting the stones of
+// `road` that aren't in any group as groups of their own.
+func groupShape(gs []uint64, road uint64) (largest, n int) {
+	var allg uint64
+	for _, g := range gs {
+		allg |= g
+		if s := bitboard.Popcount(g); s > largest {
+			largest = s
+		}
+	}
+	singles := bitboard.Popcount(road &^ allg)
+	if largest == 0 && singles > 0 {
+		largest = 1
+	}
+	return largest, len(gs) + singles
+}
@@ -3,9 +3,15 @@ package ai
 import (
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/net/context"
 
 	"github.com/nelhage/taktician/bitboard"
 	"github.com/nelhage/taktician/ptn"
@@ -314,3 +320,420 @@ W . . . B`, 99},
 		}
 	}
 }
+
+func TestEvaluatorAdapter(t *testing.T) {
+	positions := []string{
+		`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`,
+		`x3,2,x/x4,12/1,1,x,1,21C/x,1,x,12111112C,2/2,x,22121,x,2 2 20`,
+		`2,x4/x2,2,x2/x,2,2,x2/x2,12,2,1/1,1,21,2,1 1 9`,
+	}
+	fn := MakeEvaluator(5, nil)
+	var ev Evaluator = fn
+	c := bitboard.Precompute(5)
+	for i, tps := range positions {
+		p, e := ptn.ParseTPS(tps)
+		if e != nil {
+			t.Fatalf("%d: tps: %v", i, e)
+		}
+		if want, got := fn(&c, p), ev.Evaluate(&c, p); want != got {
+			t.Errorf("%d: Evaluate()=%d, want %d", i, got, want)
+		}
+
+		byFunc := NewMinimax(MinimaxConfig{Size: 5, Depth: 3, Seed: 1, Evaluate: fn})
+		byIface := NewMinimax(MinimaxConfig{Size: 5, Depth: 3, Seed: 1, Evaluator: ev})
+		pv1, v1, _ := byFunc.Analyze(context.Background(), p)
+		pv2, v2, _ := byIface.Analyze(context.Background(), p)
+		if v1 != v2 || !pv1[0].Equal(&pv2[0]) {
+			t.Errorf("%d: Evaluator search: %s=%d, want %s=%d", i,
+				ptn.FormatMove(&pv2[0]), v2, ptn.FormatMove(&pv1[0]), v1)
+		}
+	}
+}
+
+func TestSetDefaultWeights(t *testing.T) {
+	p, e := ptn.ParseTPS(`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	old := DefaultWeights[5]
+	defer SetDefaultWeights(5, old)
+
+	before := NewMinimax(MinimaxConfig{Size: 5}).Evaluate(p)
+	w := Weights{TopFlat: 1000, Capstone: 1}
+	SetDefaultWeights(5, w)
+	got := NewMinimax(MinimaxConfig{Size: 5}).Evaluate(p)
+
+	c := bitboard.Precompute(5)
+	if want := MakeEvaluator(5, &w)(&c, p); got != want {
+		t.Errorf("Evaluate()=%d with overridden defaults, want %d", got, want)
+	}
+	if got == before {
+		t.Errorf("override had no effect: %d", got)
+	}
+}
+
+func TestPrisoners(t *testing.T) {
+	cases := []struct {
+		tps  string
+		want int64
+	}{
+		{`x5/x5/x2,1212121,x2/x5/x5 1 10`, 3 * 100},
+		{`x5/x5/x2,1212121,x2/x5/x5 2 10`, -3 * 100},
+		{`x5/x5/x2,2221112,x2/x5/x5 1 10`, -3 * 100},
+		{`x5/x5/x2,11111,x2/x5/x5 1 10`, 0},
+	}
+	c := bitboard.Precompute(5)
+	for _, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatalf("tps %q: %v", tc.tps, e)
+		}
+		w := DefaultWeights[5]
+		base := MakeEvaluator(5, &w)(&c, p)
+		w.Prisoners = 100
+		if got := MakeEvaluator(5, &w)(&c, p) - base; got != tc.want {
+			t.Errorf("%s: prisoners scored %d, want %d", tc.tps, got, tc.want)
+		}
+	}
+}
+
+func TestBuried(t *testing.T) {
+	cases := []struct {
+		cfg  tak.Config
+		tps  string
+		want int64
+	}{
+		// Only the bottom two stones lie beyond the carry
+		// limit: a white stone and a black one, under a white
+		// top.
+		{tak.Config{Size: 5}, `x5/x5/x2,1212121,x2/x5/x5 1 10`, 100},
+		{tak.Config{Size: 5}, `x5/x5/x2,1212121,x2/x5/x5 2 10`, -100},
+		{tak.Config{Size: 5}, `x5/x5/x2,1122222,x2/x5/x5 1 10`, -200},
+		{tak.Config{Size: 5}, `x5/x5/x2,2221112,x2/x5/x5 1 10`, 0},
+		{tak.Config{Size: 5}, `x5/x5/x2,21212,x2/x5/x5 1 10`, 0},
+		{tak.Config{Size: 5, CarryLimit: 3}, `x5/x5/x2,1212121,x2/x5/x5 1 10`, 200},
+	}
+	c := bitboard.Precompute(5)
+	for _, tc := range cases {
+		p, e := ptn.ParseTPSConfig(tc.cfg, tc.tps)
+		if e != nil {
+			t.Fatalf("tps %q: %v", tc.tps, e)
+		}
+		w := DefaultWeights[5]
+		base := MakeEvaluator(5, &w)(&c, p)
+		w.Buried = -100
+		if got := MakeEvaluator(5, &w)(&c, p) - base; got != tc.want {
+			t.Errorf("%s carry=%d: buried scored %d, want %d",
+				tc.tps, p.CarryLimit(), got, tc.want)
+		}
+	}
+
+	p, e := ptn.ParseTPS(`x5/x5/x2,1212121,x2/x5/x5 1 10`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	var out strings.Builder
+	ExplainScore(NewMinimax(MinimaxConfig{Size: 5}), &out, p)
+	if !regexp.MustCompile(`(?m)^buried\s+0\s+1$`).MatchString(out.String()) {
+		t.Errorf("ExplainScore does not report the buried stone:\n%s", out.String())
+	}
+}
+
+func TestGroupShape(t *testing.T) {
+	cases := []struct {
+		tps                   string
+		largest, disconnected int64
+	}{
+		// white: one group of 3; black: nothing
+		{`x5/x5/1,1,1,x2/x5/x5 1 4`, 3, 0},
+		// white: a group of 2 and two lone flats; the wall
+		// doesn't count
+		{`1,x4/x5/1,1,x,1S,1/x5/x5 1 4`, 2, 2},
+		// white: 2 lone flats; black: a group of 3
+		{`1,x,1,x2/x5/x5/x5/2,2,2,x2 1 4`, 1 - 3, 1},
+	}
+	c := bitboard.Precompute(5)
+	for _, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatalf("tps %q: %v", tc.tps, e)
+		}
+		w := DefaultWeights[5]
+		w.LargestGroup = 0
+		w.Disconnected = 0
+		base := MakeEvaluator(5, &w)(&c, p)
+		w.LargestGroup = 100
+		if got := MakeEvaluator(5, &w)(&c, p) - base; got != 100*tc.largest {
+			t.Errorf("%s: largest group scored %d, want %d", tc.tps, got, 100*tc.largest)
+		}
+		w.LargestGroup = 0
+		w.Disconnected = -10
+		if got := MakeEvaluator(5, &w)(&c, p) - base; got != -10*tc.disconnected {
+			t.Errorf("%s: disconnected scored %d, want %d", tc.tps, got, -10*tc.disconnected)
+		}
+	}
+}
+
+func TestCachedEvaluator(t *testing.T) {
+	c := bitboard.Precompute(5)
+	plain := MakeEvaluator(5, nil)
+	// A tiny table, so that entries are overwritten often.
+	cached := MakeCachedEvaluator(5, nil, 7)
+	r := rand.New(rand.NewSource(1))
+	for game := 0; game < 20; game++ {
+		p := tak.New(tak.Config{Size: 5})
+		for ply := 0; ply < 100; ply++ {
+			if over, _ := p.GameOver(); over {
+				break
+			}
+			for _, m := range p.AllMoves(nil) {
+				child, e := p.Move(&m)
+				if e != nil {
+					continue
+				}
+				// Evaluate twice, so the second lookup can
+				// hit the cache.
+				for i := 0; i < 2; i++ {
+					if got, want := cached(&c, child), plain(&c, child); got != want {
+						t.Fatalf("%s: cached=%d, want %d",
+							ptn.FormatTPS(child), got, want)
+					}
+				}
+			}
+			moves := p.AllMoves(nil)
+			for {
+				m := moves[r.Intn(len(moves))]
+				if next, e := p.Move(&m); e == nil {
+					p = next
+					break
+				}
+			}
+		}
+	}
+}
+
+func benchmarkEvalCache(b *testing.B, entries int) {
+	var evaluated uint64
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		for _, tps := range orderingPositions {
+			p, e := ptn.ParseTPS(tps)
+			if e != nil {
+				b.Fatal("tps:", e)
+			}
+			ai := NewMinimax(MinimaxConfig{
+				Size: p.Size(), Depth: 5, Seed: 1, EvalCache: entries,
+			})
+			_, _, st := ai.Analyze(context.Background(), p)
+			evaluated += st.Evaluated
+		}
+	}
+	b.ReportMetric(float64(evaluated)/time.Since(start).Seconds(), "evals/s")
+}
+
+func BenchmarkEvalCacheOff(b *testing.B) {
+	benchmarkEvalCache(b, 0)
+}
+
+func BenchmarkEvalCache(b *testing.B) {
+	benchmarkEvalCache(b, 1<<16)
+}
+
+func TestTaperedEvaluator(t *testing.T) {
+	open := DefaultWeights[5]
+	end := open
+	end.TopFlat *= 2
+	end.Groups = [8]int{}
+	end.Center = 0
+	tw := TaperedWeights{Opening: open, Endgame: end}
+	c := bitboard.Precompute(5)
+	tapered := MakeTaperedEvaluator(5, &tw)
+	evalOpen := MakeEvaluator(5, &open)
+	evalEnd := MakeEvaluator(5, &end)
+
+	start := tak.New(tak.Config{Size: 5})
+	if placed, _ := GamePhase(start); placed != 0 {
+		t.Errorf("new game: placed=%d", placed)
+	}
+	if got, want := tapered(&c, start), evalOpen(&c, start); got != want {
+		t.Errorf("new game: tapered=%d, opening=%d", got, want)
+	}
+
+	same := TaperedWeights{Opening: open, Endgame: open}
+	flat := MakeTaperedEvaluator(5, &same)
+
+	// The tapered evaluation moves from the opening value toward
+	// the endgame value as pieces are placed.
+	var prev float64
+	for i, tps := range []string{
+		`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`,
+		`x3,2,x/x4,12/1,1,x,1,21C/x,1,x,12111112C,2/2,x,22121,x,2 2 20`,
+		`21,12,21,12,x/12,21,12,21,12/21,12,x,12,21/12,21,12,21,x/x,12,21,12,21 1 22`,
+	} {
+		p, e := ptn.ParseTPS(tps)
+		if e != nil {
+			t.Fatalf("%d: tps: %v", i, e)
+		}
+		if got, want := flat(&c, p), evalOpen(&c, p); got != want {
+			t.Errorf("%d: equal weights: tapered=%d, plain=%d", i, got, want)
+		}
+		placed, total := GamePhase(p)
+		vo, ve, vt := evalOpen(&c, p), evalEnd(&c, p), tapered(&c, p)
+		if vo == ve {
+			t.Fatalf("%d: weights don't distinguish the phases", i)
+		}
+		frac := float64(vt-vo) / float64(ve-vo)
+		want := float64(placed) / float64(total)
+		t.Logf("%d: placed=%d/%d open=%d end=%d tapered=%d (%.2f)",
+			i, placed, total, vo, ve, vt, frac)
+		if frac < want-0.05 || frac > want+0.05 {
+			t.Errorf("%d: tapered eval is %.2f of the way to the endgame, phase is %.2f",
+				i, frac, want)
+		}
+		if frac <= prev {
+			t.Errorf("%d: tapered eval did not move toward the endgame", i)
+		}
+		prev = frac
+	}
+}
+
+func TestGamePhaseCustomReserves(t *testing.T) {
+	for _, cfg := range []tak.Config{
+		{Size: 5, Pieces: 30, Capstones: 1},
+		{Size: 6, Pieces: 20, Capstones: 2},
+	} {
+		p := tak.New(cfg)
+		if placed, total := GamePhase(p); placed != 0 || total != 2*(cfg.Pieces+cfg.Capstones) {
+			t.Errorf("%+v: new game: phase=%d/%d", cfg, placed, total)
+		}
+		for _, mv := range []string{"a1", "b1", "Cc1"} {
+			m, e := ptn.ParseMove(mv)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if p, e = p.Move(&m); e != nil {
+				t.Fatalf("%+v: %s: %v", cfg, mv, e)
+			}
+		}
+		if placed, _ := GamePhase(p); placed != 3 {
+			t.Errorf("%+v: placed=%d, want 3", cfg, placed)
+		}
+	}
+}
+
+func TestTaperedWeightsConfig(t *testing.T) {
+	open := DefaultWeights[5]
+	end := open
+	end.TopFlat *= 2
+	tw := TaperedWeights{Opening: open, Endgame: end}
+	c := bitboard.Precompute(5)
+	tapered := MakeTaperedEvaluator(5, &tw)
+	ai := NewMinimax(MinimaxConfig{Size: 5, Depth: 1, TaperedWeights: &tw})
+	for i, tps := range orderingPositions {
+		p, e := ptn.ParseTPS(tps)
+		if e != nil {
+			t.Fatalf("%d: tps: %v", i, e)
+		}
+		if got, want := ai.Evaluate(p), tapered(&c, p); got != want {
+			t.Errorf("%d: Evaluate=%d, tapered=%d", i, got, want)
+		}
+	}
+}
+
+func TestWinProbability(t *testing.T) {
+	if p := WinProbability(0); p != 0.5 {
+		t.Errorf("WinProbability(0)=%f", p)
+	}
+	prev := -1.0
+	for _, v := range []int64{MinEval, -WinThreshold, -5000, -600, -1, 0, 1, 600, 5000, WinThreshold, MaxEval} {
+		p := WinProbability(v)
+		if p < prev || p < 0 || p > 1 {
+			t.Errorf("WinProbability(%d)=%f, after %f", v, p, prev)
+		}
+		if q := WinProbability(-v); math.Abs(p+q-1) > 1e-9 {
+			t.Errorf("WinProbability(%d)+WinProbability(%d)=%f", v, -v, p+q)
+		}
+		prev = p
+	}
+	if p := WinProbability(WinThreshold + 1); p != 1 {
+		t.Errorf("proven win: %f", p)
+	}
+	if p := WinProbability(-WinThreshold - 1); p != 0 {
+		t.Errorf("proven loss: %f", p)
+	}
+}
+
+func TestEvaluateNoRoads(t *testing.T) {
+	const tps = `x,2,x,2,1/x,2,2S,x,1/1,1,1C,x,2/x,2,x,1,x/1,x,x,x,x 1 8`
+	p, e := ptn.ParseTPS(tps)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	cfg := tak.DefaultConfig(5)
+	cfg.NoRoads = true
+	flats, e := ptn.ParseTPSConfig(cfg, tps)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+
+	c := bitboard.Precompute(5)
+	w := DefaultWeights[5]
+	nw := withoutRoads(&w)
+	want := MakeEvaluator(5, &nw)(&c, p)
+	for _, entries := range []int{0, 64} {
+		eval := MakeCachedEvaluator(5, nil, entries)
+		// Fill the cache with the full weights' terms first.
+		eval(&c, p)
+		if got := eval(&c, flats); got != want {
+			t.Errorf("entries=%d: NoRoads eval=%d, want %d", entries, got, want)
+		}
+	}
+	if v := MakeEvaluator(5, nil)(&c, p); v == want {
+		t.Errorf("road terms do not affect the evaluation: %d", v)
+	}
+}
+
+// swapColors returns `p` with the color of every piece, and the
+// player to move, swapped.
+func swapColors(t *testing.T, p *tak.Position) *tak.Position {
+	board := make([][]tak.Square, p.Size())
+	for y := range board {
+		board[y] = make([]tak.Square, p.Size())
+		for x := range board[y] {
+			for _, pc := range p.At(x, y) {
+				board[y][x] = append(board[y][x], tak.MakePiece(pc.Color().Flip(), pc.Kind()))
+			}
+		}
+	}
+	out, e := tak.FromSquares(tak.DefaultConfig(p.Size()), board, p.MoveNumber()+1)
+	if e != nil {
+		t.Fatal("FromSquares:", e)
+	}
+	return out
+}
+
+func TestEvaluateAbsolute(t *testing.T) {
+	for _, tps := range []string{
+		`x5/x5/x2,1,x2/x5/2,x4 1 2`,
+		`x3,2,x/x,2,1,1,x/x,1C,2S,x2/x,2,12,x2/x5 2 6`,
+		`2,x,1,x3/x,2,1,2,x2/x,1,221C,1,x2/x2,2S,12C,x2/x6/x6 1 9`,
+	} {
+		p, e := ptn.ParseTPS(tps)
+		if e != nil {
+			t.Fatalf("%s: %v", tps, e)
+		}
+		mirror := swapColors(t, p)
+		ai := NewMinimax(MinimaxConfig{Size: p.Size()})
+		v, mv := ai.EvaluateAbsolute(p), ai.EvaluateAbsolute(mirror)
+		if v != -mv {
+			t.Errorf("%s: EvaluateAbsolute=%d, of the mirror=%d", tps, v, mv)
+		}
+		want := ai.Evaluate(p)
+		if p.ToMove() == tak.Black {
+			want = -want
+		}
+		if v != want {
+			t.Errorf("%s: EvaluateAbsolute=%d, want %d", tps, v, want)
+		}
+	}
+}
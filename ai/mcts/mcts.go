@@ -4,6 +4,7 @@ import (
 	"log"
 	"math"
 	"math/rand"
+	"sort"
 	"strings"
 	"time"
 
@@ -23,9 +24,28 @@ type MCTSConfig struct {
 
 	Size int
 
+	// MaxRolloutDepth bounds the length of each playout, in
+	// plies; playouts that reach it are scored by the static
+	// evaluation. The default is maxMoves.
+	MaxRolloutDepth int
+
+	Widening Widening
+
+	// MaxSimulations, if nonzero, caps the number of
+	// simulations Search runs.
+	MaxSimulations int
+
 	Policy PolicyFunc
 }
 
+// Widening configures progressive widening: a node that has been
+// visited n times only considers its ceil(C * n^Alpha) most promising
+// children, as ranked by the static evaluation. A zero C disables
+// widening.
+type Widening struct {
+	C, Alpha float64
+}
+
 type PolicyFunc func(ctx context.Context,
 	m *MonteCarloAI,
 	p *tak.Position,
@@ -46,6 +66,10 @@ type tree struct {
 	move        tak.Move
 	simulations int
 
+	// value is the sum of the results of simulations through
+	// this node, from the perspective of the player to move in
+	// `position`, or ±(WinThreshold+1) once the result is
+	// proven.
 	value int64
 
 	parent   *tree
@@ -56,36 +80,79 @@ func proven(v int64) bool {
 	return v > ai.WinThreshold || v < -ai.WinThreshold
 }
 
+// GetMove searches `p` until `ctx` is done or the configured Limit
+// has passed, and returns the best move.
 func (ai *MonteCarloAI) GetMove(ctx context.Context, p *tak.Position) tak.Move {
+	ctx, cancel := context.WithTimeout(ctx, ai.cfg.Limit)
+	defer cancel()
+	return ai.Search(ctx, p)
+}
+
+func (ai *MonteCarloAI) Name() string {
+	return "mcts"
+}
+
+// Search runs simulations from `p` until `ctx` is done or
+// MaxSimulations have run, and returns the most-visited move.
+// Unlike GetMove, it ignores Limit.
+func (ai *MonteCarloAI) Search(ctx context.Context, p *tak.Position) tak.Move {
+	m, _ := ai.SearchStats(ctx, p)
+	return m
+}
+
+// MCTSStats describes a completed search.
+type MCTSStats struct {
+	Simulations int
+	// MaxDepth is the depth of the deepest node in the tree,
+	// in plies below the root.
+	MaxDepth int
+	Elapsed  time.Duration
+
+	// Moves holds one entry per child of the root, in the
+	// order they were generated.
+	Moves []MoveStats
+}
+
+// MoveStats describes the search of one root move.
+type MoveStats struct {
+	Move        tak.Move
+	Simulations int
+	// Value is the mean result of the simulations through
+	// this move, from 1 (a win for the player to move at the
+	// root) to -1 (a loss). Proven moves have a value of ±1.
+	Value  float64
+	Proven bool
+}
+
+// SearchStats is like Search, but also returns the statistics from
+// the search. If the game is already over, it returns the zero Move
+// and empty statistics.
+func (ai *MonteCarloAI) SearchStats(ctx context.Context, p *tak.Position) (tak.Move, MCTSStats) {
+	start := time.Now()
 	tree := &tree{
 		position: p,
 	}
 	ai.populate(ctx, tree)
-	start := time.Now()
-	deadline, limited := ctx.Deadline()
-	if !limited || deadline.Sub(start) > ai.cfg.Limit {
-		deadline = time.Now().Add(ai.cfg.Limit)
+	if len(tree.children) == 0 {
+		// populate proved the result without
+		// expanding the root; ask the minimax search
+		// for the move that does it.
+		pv, _, _ := ai.mm.Analyze(context.Background(), p)
+		st := MCTSStats{Elapsed: time.Now().Sub(start)}
+		if len(pv) == 0 {
+			// The game is already over.
+			return tak.Move{}, st
+		}
+		return pv[0], st
 	}
 	ctx = WithRand(ctx, ai.r)
 
 	next := start.Add(10 * time.Second)
-	for time.Now().Before(deadline) {
-		node := ai.descend(tree)
-		if ai.cfg.Debug > 4 {
-			var s []string
-			t := node
-			for t.parent != nil {
-				s = append(s, ptn.FormatMove(&t.move))
-				t = t.parent
-			}
-			log.Printf("evaluate: [%s]", strings.Join(s, "<-"))
-		}
-		ai.populate(ctx, node)
-		var val int64
-		if !proven(node.value) {
-			val = ai.evaluate(ctx, node)
+	for sims := 0; ai.cfg.MaxSimulations == 0 || sims < ai.cfg.MaxSimulations; sims++ {
+		if ctx.Err() != nil {
+			break
 		}
-		ai.update(node, val)
+		ai.iterate(ctx, tree)
 		if time.Now().After(next) && ai.cfg.Debug > 0 {
 			ai.printpv(tree)
 			next = time.Now().Add(10 * time.Second)
@@ -111,7 +178,62 @@ func (ai *MonteCarloAI) GetMove(ctx context.Context, p *tak.Position) tak.Move {
 	if ai.cfg.Debug > 1 {
 		log.Printf("[mcts] evaluated simulations=%d value=%d", tree.simulations, tree.value)
 	}
-	return best.move
+	return best.move, searchStats(tree, time.Now().Sub(start))
+}
+
+func searchStats(t *tree, elapsed time.Duration) MCTSStats {
+	st := MCTSStats{
+		Simulations: t.simulations,
+		MaxDepth:    t.maxDepth(),
+		Elapsed:     elapsed,
+	}
+	for _, c := range t.children {
+		ms := MoveStats{Move: c.move, Simulations: c.simulations}
+		switch {
+		case c.value > ai.WinThreshold:
+			ms.Value, ms.Proven = -1, true
+		case c.value < -ai.WinThreshold:
+			ms.Value, ms.Proven = 1, true
+		case c.simulations > 0:
+			ms.Value = -float64(c.value) / float64(c.simulations)
+		}
+		st.Moves = append(st.Moves, ms)
+	}
+	return st
+}
+
+func (t *tree) maxDepth() int {
+	d := 0
+	for _, c := range t.children {
+		if c.simulations == 0 {
+			continue
+		}
+		if cd := c.maxDepth() + 1; cd > d {
+			d = cd
+		}
+	}
+	return d
+}
+
+// iterate runs one simulation from the root `t`: it selects a leaf,
+// expands it, plays out a game from it, and backs up the result.
+func (mc *MonteCarloAI) iterate(ctx context.Context, t *tree) {
+	node := mc.descend(t)
+	if mc.cfg.Debug > 4 {
+		var s []string
+		t := node
+		for t.parent != nil {
+			s = append(s, ptn.FormatMove(&t.move))
+			t = t.parent
+		}
+		log.Printf("evaluate: [%s]", strings.Join(s, "<-"))
+	}
+	mc.populate(ctx, node)
+	var val int64
+	if !proven(node.value) {
+		val = mc.evaluate(ctx, node)
+	}
+	mc.update(node, val)
 }
 
 func (mc *MonteCarloAI) printpv(t *tree) {
@@ -150,6 +272,10 @@ func (mc *MonteCarloAI) populate(ctx context.Context, t *tree) {
 		t.value = v
 		return
 	}
+	if over, _ := t.position.GameOver(); over {
+		// A drawn game; it has no moves to expand.
+		return
+	}
 
 	moves := t.position.AllMoves(nil)
 	t.children = make([]*tree, 0, len(moves))
@@ -164,6 +290,43 @@ func (mc *MonteCarloAI) populate(ctx context.Context, t *tree) {
 			parent:   t,
 		})
 	}
+	if mc.cfg.Widening.C != 0 {
+		byPrior := byPrior{t.children, make([]int64, len(t.children))}
+		for i, c := range t.children {
+			byPrior.vs[i] = -mc.eval(&mc.c, c.position)
+		}
+		sort.Stable(byPrior)
+	}
+}
+
+// byPrior sorts children by descending static evaluation.
+type byPrior struct {
+	ts []*tree
+	vs []int64
+}
+
+func (b byPrior) Len() int           { return len(b.ts) }
+func (b byPrior) Less(i, j int) bool { return b.vs[i] > b.vs[j] }
+func (b byPrior) Swap(i, j int) {
+	b.ts[i], b.ts[j] = b.ts[j], b.ts[i]
+	b.vs[i], b.vs[j] = b.vs[j], b.vs[i]
+}
+
+// expanded returns the children of `t` that the search may currently
+// visit, which are all of them unless widening is enabled.
+func (mc *MonteCarloAI) expanded(t *tree) []*tree {
+	w := mc.cfg.Widening
+	if w.C == 0 {
+		return t.children
+	}
+	n := int(math.Ceil(w.C * math.Pow(float64(t.simulations), w.Alpha)))
+	if n < 1 {
+		n = 1
+	}
+	if n > len(t.children) {
+		n = len(t.children)
+	}
+	return t.children[:n]
 }
 
 const visitThreshold = 10
@@ -172,8 +335,8 @@ func (mc *MonteCarloAI) descendPolicy(t *tree) *tree {
 	var best *tree
 	val := ai.MinEval
 	i := 0
-	for _, c := range t.children {
-		v := mc.eval(&mc.c, c.position)
+	for _, c := range mc.expanded(t) {
+		v := -mc.eval(&mc.c, c.position)
 		if v > val {
 			best = c
 			val = v
@@ -198,12 +361,12 @@ func (ai *MonteCarloAI) descend(t *tree) *tree {
 	var best *tree
 	var val float64
 	i := 0
-	for _, c := range t.children {
+	for _, c := range ai.expanded(t) {
 		var s float64
 		if c.simulations == 0 {
 			s = 10
 		} else {
-			s = float64(c.value)/float64(c.simulations) +
+			s = -float64(c.value)/float64(c.simulations) +
 				ai.cfg.C*math.Sqrt(math.Log(float64(t.simulations))/float64(c.simulations))
 		}
 		if s > val {
@@ -226,16 +389,22 @@ func (ai *MonteCarloAI) descend(t *tree) *tree {
 const maxMoves = 50
 const evalThreshold = 500
 
+// evaluate plays out a game from `t` and returns 1, 0, or -1 for a
+// win, draw, or loss for the player to move at `t`. Playouts that
+// haven't ended after MaxRolloutDepth plies are scored by the static
+// evaluation.
 func (ai *MonteCarloAI) evaluate(ctx context.Context, t *tree) int64 {
 	p := t.position
 	alloc := tak.Alloc(p.Size())
+	us := t.position.ToMove()
 
-	for i := 0; i < maxMoves; i++ {
-		if ok, c := p.GameOver(); ok {
-			switch c {
+	for i := 0; i < ai.cfg.MaxRolloutDepth; i++ {
+		if d := p.WinDetails(); d.Over {
+			// Road and flat wins count the same.
+			switch d.Winner {
 			case tak.NoColor:
 				return 0
-			case t.position.ToMove():
+			case us:
 				return 1
 			default:
 				return -1
@@ -248,6 +417,9 @@ func (ai *MonteCarloAI) evaluate(ctx context.Context, t *tree) int64 {
 		p, alloc = next, p
 	}
 	v := ai.eval(&ai.c, p)
+	if p.ToMove() != us {
+		v = -v
+	}
 	if v > evalThreshold {
 		return 1
 	} else if v < -evalThreshold {
@@ -256,10 +428,14 @@ func (ai *MonteCarloAI) evaluate(ctx context.Context, t *tree) int64 {
 	return 0
 }
 
+// update backs up `value`, the result of a simulation from `t` for
+// the player to move at `t`, to `t` and its ancestors, negating it
+// at each ply. It also marks nodes proven won or lost as their
+// children are proven.
 func (mc *MonteCarloAI) update(t *tree, value int64) {
 	for t != nil {
 		foundWin := false
-		foundLose := true
+		foundLose := len(t.children) > 0
 		for _, c := range t.children {
 			if c.value < -ai.WinThreshold {
 				foundWin = true
@@ -269,16 +445,18 @@ func (mc *MonteCarloAI) update(t *tree, value int64) {
 				foundLose = false
 			}
 		}
-		if foundWin {
-			t.value = ai.WinThreshold
-		} else if foundLose {
-			t.value = -ai.WinThreshold
-		} else {
+		switch {
+		case foundWin:
+			t.value = ai.WinThreshold + 1
+		case foundLose:
+			t.value = -ai.WinThreshold - 1
+		case !proven(t.value):
 			t.value += value
 		}
 
 		t.simulations++
 		t = t.parent
+		value = -value
 	}
 }
 
@@ -290,6 +468,9 @@ func NewMonteCarlo(cfg MCTSConfig) *MonteCarloAI {
 	if mc.cfg.C == 0 {
 		mc.cfg.C = 0.7
 	}
+	if mc.cfg.MaxRolloutDepth == 0 {
+		mc.cfg.MaxRolloutDepth = maxMoves
+	}
 	if mc.cfg.Seed == 0 {
 		mc.cfg.Seed = time.Now().Unix()
 	}
@@ -0,0 +1,242 @@
+package mcts
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+func TestEvaluateTerminal(t *testing.T) {
+	cases := []struct {
+		tps  string
+		want int64
+	}{
+		// white has just completed a road
+		{"x5/x5/1,1,1,1,1/x5/2,2,2,2,x 2 6", -1},
+		{"x5/x5/1,1,1,1,1/x5/2,2,2,2,x 1 6", 1},
+		// a full board, won on flats by black
+		{"1,2,1,2,1/2,1,2,1,2/1,2,1,2,1/2,1,2,1,2/2,2,1,2,2 1 13", -1},
+		// a full board, drawn on flats
+		{"1,2,1,2,1/2,1,2,1,2/1,2,1,2,1/2,1,2,1,2/1,2,1,2,2S 2 13", 0},
+	}
+	mc := NewMonteCarlo(MCTSConfig{Size: 5, Seed: 1})
+	for _, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatalf("tps %q: %v", tc.tps, e)
+		}
+		if over, _ := p.GameOver(); !over {
+			t.Fatalf("%q: not over", tc.tps)
+		}
+		if got := mc.evaluate(context.Background(), &tree{position: p}); got != tc.want {
+			t.Errorf("evaluate(%q)=%d, want %d", tc.tps, got, tc.want)
+		}
+	}
+}
+
+func TestForcedWin(t *testing.T) {
+	// Black to move, but white threatens both a5 and c3, and will
+	// win next turn.
+	p, e := ptn.ParseTPS("x,2,2,x,2/1,x4/1,1,x,1,1/1,x4/1,x,2,2,2 2 7")
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	mc := NewMonteCarlo(MCTSConfig{Size: 5, Seed: 1})
+	ctx := context.Background()
+	root := &tree{position: p}
+	mc.populate(ctx, root)
+	for i := 0; i < 2000 && !proven(root.value); i++ {
+		mc.iterate(ctx, root)
+	}
+	if root.value >= -ai.WinThreshold {
+		t.Errorf("root value=%d after %d simulations, want a proven loss",
+			root.value, root.simulations)
+	}
+}
+
+func TestMaxRolloutDepth(t *testing.T) {
+	for _, depth := range []int{1, 10, 0} {
+		plies := 0
+		cfg := MCTSConfig{
+			Size: 5, Seed: 1, MaxRolloutDepth: depth,
+			Policy: func(ctx context.Context, m *MonteCarloAI,
+				p *tak.Position, next *tak.Position) *tak.Position {
+				plies++
+				return UniformRandomPolicy(ctx, m, p, next)
+			},
+		}
+		mc := NewMonteCarlo(cfg)
+		want := depth
+		if want == 0 {
+			want = maxMoves
+		}
+		for i := 0; i < 20; i++ {
+			plies = 0
+			mc.evaluate(context.Background(), &tree{position: tak.New(tak.Config{Size: 5})})
+			if plies > want {
+				t.Fatalf("MaxRolloutDepth=%d: rollout ran %d plies", depth, plies)
+			}
+		}
+	}
+}
+
+func TestWidening(t *testing.T) {
+	explored := func(w Widening) int {
+		mc := NewMonteCarlo(MCTSConfig{Size: 5, Seed: 1, Widening: w})
+		ctx := context.Background()
+		p, e := ptn.ParseTPS("x5/x5/x2,1,x2/x5/2,x4 2 2")
+		if e != nil {
+			t.Fatal("tps:", e)
+		}
+		root := &tree{position: p}
+		mc.populate(ctx, root)
+		for i := 0; i < 50; i++ {
+			mc.iterate(ctx, root)
+		}
+		n := 0
+		for _, c := range root.children {
+			if c.simulations > 0 {
+				n++
+			}
+		}
+		return n
+	}
+	all := explored(Widening{})
+	widened := explored(Widening{C: 1, Alpha: 0.5})
+	if widened > 8 || widened*3 > all {
+		t.Errorf("explored %d children with widening, %d without", widened, all)
+	}
+}
+
+func TestSearchCancel(t *testing.T) {
+	p, e := ptn.ParseTPS("x5/x5/x2,1,x2/x5/2,x4 2 2")
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	mc := NewMonteCarlo(MCTSConfig{Size: 5, Seed: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	done := make(chan tak.Move)
+	go func() { done <- mc.Search(ctx, p) }()
+	select {
+	case m := <-done:
+		if _, e := p.Move(&m); e != nil {
+			t.Fatalf("illegal move %s: %v", ptn.FormatMove(&m), e)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Search did not stop when canceled")
+	}
+}
+
+func TestSearchMaxSimulations(t *testing.T) {
+	p, e := ptn.ParseTPS("x5/x5/x2,1,x2/x5/2,x4 2 2")
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	plies := 0
+	mc := NewMonteCarlo(MCTSConfig{
+		Size: 5, Seed: 1, MaxSimulations: 5, MaxRolloutDepth: 1,
+		Policy: func(ctx context.Context, m *MonteCarloAI,
+			p *tak.Position, next *tak.Position) *tak.Position {
+			plies++
+			return UniformRandomPolicy(ctx, m, p, next)
+		},
+	})
+	m := mc.Search(context.Background(), p)
+	if _, e := p.Move(&m); e != nil {
+		t.Fatalf("illegal move %s: %v", ptn.FormatMove(&m), e)
+	}
+	if plies != 5 {
+		t.Errorf("ran %d simulations, want 5", plies)
+	}
+}
+
+func TestSearchStats(t *testing.T) {
+	p, e := ptn.ParseTPS("x5/x5/x2,1,x2/x5/2,x4 2 2")
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	const sims = 200
+	mc := NewMonteCarlo(MCTSConfig{Size: 5, Seed: 1, MaxSimulations: sims})
+	m, st := mc.SearchStats(context.Background(), p)
+	if st.Simulations != sims {
+		t.Errorf("Simulations=%d, want %d", st.Simulations, sims)
+	}
+	if want := len(p.AllMoves(nil)); len(st.Moves) != want {
+		t.Errorf("len(Moves)=%d, want %d", len(st.Moves), want)
+	}
+	if st.MaxDepth < 2 {
+		t.Errorf("MaxDepth=%d, want at least 2", st.MaxDepth)
+	}
+	total, most := 0, 0
+	var best tak.Move
+	for _, ms := range st.Moves {
+		total += ms.Simulations
+		if ms.Simulations > most {
+			most, best = ms.Simulations, ms.Move
+		}
+		if ms.Value < -1 || ms.Value > 1 {
+			t.Errorf("%s: Value=%f", ptn.FormatMove(&ms.Move), ms.Value)
+		}
+	}
+	if total != sims {
+		t.Errorf("child simulations=%d, want %d", total, sims)
+	}
+	if !m.Equal(&best) {
+		var want int
+		for _, ms := range st.Moves {
+			if ms.Move.Equal(&m) {
+				want = ms.Simulations
+			}
+		}
+		if want != most {
+			t.Errorf("chose %s (n=%d), most visited was %s (n=%d)",
+				ptn.FormatMove(&m), want, ptn.FormatMove(&best), most)
+		}
+	}
+}
+
+func TestSearchGameOver(t *testing.T) {
+	// White has already completed a road.
+	p, e := ptn.ParseTPS("1,1,1,1,1/x5/x5/2,2,2,2,x/x5 2 5")
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	if over, _ := p.GameOver(); !over {
+		t.Fatal("game is not over")
+	}
+	mc := NewMonteCarlo(MCTSConfig{Size: 5, Seed: 1, MaxSimulations: 10, Limit: time.Minute})
+	m, st := mc.SearchStats(context.Background(), p)
+	if m.Type != 0 || st.Simulations != 0 || len(st.Moves) != 0 {
+		t.Errorf("SearchStats(finished)=%#v, %+v", m, st)
+	}
+	if m := mc.GetMove(context.Background(), p); m.Type != 0 {
+		t.Errorf("GetMove(finished)=%#v", m)
+	}
+}
+
+func TestTakPlayer(t *testing.T) {
+	players := []ai.TakPlayer{
+		ai.NewMinimax(ai.MinimaxConfig{Size: 5, Depth: 2, Seed: 1}),
+		NewMonteCarlo(MCTSConfig{Size: 5, Seed: 1, MaxSimulations: 50, Limit: time.Minute}),
+		ai.NewRandom(1),
+	}
+	p, e := ptn.ParseTPS("x5/x5/x2,1,x2/x5/2,x4 1 3")
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	for _, pl := range players {
+		m := pl.GetMove(context.Background(), p)
+		if _, e := p.Move(&m); e != nil {
+			t.Errorf("%s: illegal move %s: %v", pl.Name(), ptn.FormatMove(&m), e)
+		}
+	}
+}
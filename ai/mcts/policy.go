@@ -1,26 +1,26 @@
 package mcts
 
 import (
+	"math"
+
 	"golang.org/x/net/context"
 
 	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/bitboard"
 	"github.com/nelhage/taktician/tak"
 )
 
 func UniformRandomPolicy(ctx context.Context,
 	m *MonteCarloAI,
 	p *tak.Position, alloc *tak.Position) *tak.Position {
-	moves := p.AllMoves(nil)
-	var next *tak.Position
-	for {
-		r := m.r.Int31n(int32(len(moves)))
-		m := moves[r]
-		var e error
-		if next, e = p.MovePreallocated(&m, alloc); e == nil {
-			break
-		}
-		moves[0], moves[r] = moves[r], moves[0]
-		moves = moves[1:]
+	var buf [500]tak.Move
+	moves := p.AllMoves(buf[:0])
+	if len(moves) == 0 {
+		return nil
+	}
+	next, e := p.MovePreallocated(&moves[m.r.Intn(len(moves))], alloc)
+	if e != nil {
+		return nil
 	}
 	return next
 }
@@ -41,11 +41,65 @@ func NewMinimaxPolicy(cfg *MCTSConfig, depth int) PolicyFunc {
 	}
 }
 
+// NewEvalPolicy returns a policy that samples each legal move with
+// probability proportional to exp(v/temperature), where v is
+// `evaluator`'s score of the resulting position for the player
+// making the move. Low temperatures approach always playing the
+// statically best move, and high ones approach uniform random play.
+// A move that wins immediately is always played. `temperature` must
+// be positive.
+func NewEvalPolicy(cfg *MCTSConfig, evaluator ai.EvaluationFunc, temperature float64) PolicyFunc {
+	c := bitboard.Precompute(uint(cfg.Size))
+	var moves []tak.Move
+	var weights []float64
+	return func(ctx context.Context,
+		m *MonteCarloAI,
+		p *tak.Position, next *tak.Position) *tak.Position {
+		moves = p.AllMoves(moves[:0])
+		legal := moves[:0]
+		weights = weights[:0]
+		max := math.Inf(-1)
+		for _, mv := range moves {
+			child, e := p.MovePreallocated(&mv, next)
+			if e != nil {
+				continue
+			}
+			v := -evaluator(&c, child)
+			if v > ai.WinThreshold {
+				return child
+			}
+			w := float64(v) / temperature
+			if w > max {
+				max = w
+			}
+			legal = append(legal, mv)
+			weights = append(weights, w)
+		}
+		if len(legal) == 0 {
+			return nil
+		}
+		var sum float64
+		for i, w := range weights {
+			weights[i] = math.Exp(w - max)
+			sum += weights[i]
+		}
+		r := m.r.Float64() * sum
+		i := 0
+		for ; i < len(legal)-1; i++ {
+			if r -= weights[i]; r < 0 {
+				break
+			}
+		}
+		next, _ = p.MovePreallocated(&legal[i], next)
+		return next
+	}
+}
+
 func EvalWeightedPolicy(ctx context.Context,
 	mc *MonteCarloAI,
 	p *tak.Position, alloc *tak.Position) *tak.Position {
 	var buf [500]tak.Move
-	moves := p.AllMoves(buf[:])
+	moves := p.AllMoves(buf[:0])
 	var best tak.Move
 	var sum int64
 	for _, m := range moves {
@@ -0,0 +1,107 @@
+package mcts
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/tak"
+)
+
+// playout plays a game from an empty 5x5 board, with `white` and
+// `black` choosing the moves, and returns the winner.
+func playout(mc *MonteCarloAI, white, black PolicyFunc) tak.Color {
+	p := tak.New(tak.Config{Size: 5})
+	alloc := tak.Alloc(5)
+	for ply := 0; ply < 200; ply++ {
+		if over, winner := p.GameOver(); over {
+			return winner
+		}
+		policy := white
+		if p.ToMove() == tak.Black {
+			policy = black
+		}
+		next := policy(context.Background(), mc, p, alloc)
+		if next == nil {
+			break
+		}
+		p, alloc = next, p
+	}
+	return tak.NoColor
+}
+
+func TestEvalPolicyBeatsRandom(t *testing.T) {
+	cfg := MCTSConfig{Size: 5, Seed: 3}
+	mc := NewMonteCarlo(cfg)
+	eval := NewEvalPolicy(&cfg, ai.MakeEvaluator(5, nil), 100)
+	wins, losses := 0, 0
+	for i := 0; i < 20; i++ {
+		var winner tak.Color
+		us := tak.White
+		if i%2 == 0 {
+			winner = playout(mc, eval, UniformRandomPolicy)
+		} else {
+			us = tak.Black
+			winner = playout(mc, UniformRandomPolicy, eval)
+		}
+		switch winner {
+		case us:
+			wins++
+		case us.Flip():
+			losses++
+		}
+	}
+	if wins < 18 {
+		t.Errorf("eval policy won %d and lost %d of 20 games against random", wins, losses)
+	}
+}
+
+// benchmarkRollout plays `policy` as white against random play, and
+// reports how often it wins.
+func benchmarkRollout(b *testing.B, policy func(cfg *MCTSConfig) PolicyFunc) {
+	cfg := MCTSConfig{Size: 5, Seed: 1}
+	mc := NewMonteCarlo(cfg)
+	pol := policy(&cfg)
+	wins := 0
+	for i := 0; i < b.N; i++ {
+		if playout(mc, pol, UniformRandomPolicy) == tak.White {
+			wins++
+		}
+	}
+	b.ReportMetric(float64(wins)/float64(b.N), "winrate")
+}
+
+func BenchmarkRolloutRandom(b *testing.B) {
+	benchmarkRollout(b, func(*MCTSConfig) PolicyFunc { return UniformRandomPolicy })
+}
+
+func BenchmarkRolloutEvalWeighted(b *testing.B) {
+	benchmarkRollout(b, func(*MCTSConfig) PolicyFunc { return EvalWeightedPolicy })
+}
+
+func BenchmarkRolloutEval(b *testing.B) {
+	benchmarkRollout(b, func(cfg *MCTSConfig) PolicyFunc {
+		return NewEvalPolicy(cfg, ai.MakeEvaluator(cfg.Size, nil), 100)
+	})
+}
+
+func TestMinimaxPolicy(t *testing.T) {
+	cfg := MCTSConfig{Size: 5, Seed: 5}
+	mc := NewMonteCarlo(cfg)
+	policy := NewMinimaxPolicy(&cfg, 1)
+	for i := 0; i < 3; i++ {
+		p := tak.New(tak.Config{Size: 5})
+		for ply := 0; ply < 10; ply++ {
+			next := policy(context.Background(), mc, p, nil)
+			if next == nil {
+				t.Fatalf("game %d ply %d: no move", i, ply)
+			}
+			if next.MoveNumber() != p.MoveNumber()+1 {
+				t.Fatalf("game %d ply %d: move number %d after %d",
+					i, ply, next.MoveNumber(), p.MoveNumber())
+			}
+			p = next
+		}
+	}
+}
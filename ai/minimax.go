@@ -2,8 +2,11 @@ package ai
 
 import (
 	"bytes"
+	"io"
 	"log"
 	"math/rand"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -21,18 +24,80 @@ const (
 
 	tableSize uint64 = (1 << 20)
 
+	// maxDepth is the default depth limit, used if
+	// MinimaxConfig.Depth is zero.
 	maxDepth = 15
+	// maxMoves is the initial size of each ply's move buffers,
+	// which grow as needed for positions with more moves.
 	maxMoves = 500
 
-	multiCutSearch    = 6
+	// The defaults for MinimaxConfig's MultiCut settings.
+	multiCutWidth     = 6
 	multiCutThreshold = 3
+	multiCutDepth     = 4
+
+	flatRaceHorizon   = 4
+	flatRaceExtension = 4
+
+	// singleReplyExtension bounds how far past the nominal depth
+	// single-reply extensions may carry the search.
+	singleReplyExtension = 4
 )
 
 type EvaluationFunc func(c *bitboard.Constants, p *tak.Position) int64
 
+// An Evaluator scores positions for the search. Implementations may
+// carry state, such as precomputed tables; a MinimaxAI calls its
+// Evaluator from a single goroutine.
+type Evaluator interface {
+	// Evaluate returns the score of `p` from the perspective of
+	// the player to move.
+	Evaluate(c *bitboard.Constants, p *tak.Position) int64
+	// Explain writes a human-readable breakdown of the
+	// evaluation of `p` to `out`.
+	Explain(c *bitboard.Constants, out io.Writer, p *tak.Position)
+}
+
+// MoveHooks may be implemented by an Evaluator that maintains
+// incremental state, such as a network accumulator. The search calls
+// OnMove with the parent position before searching the child reached
+// by playing `m`, and OnUndo with the same arguments once it returns
+// to the parent. Null-move searches are reported as a tak.Pass move.
+type MoveHooks interface {
+	OnMove(p *tak.Position, m *tak.Move)
+	OnUndo(p *tak.Position, m *tak.Move)
+}
+
+// LastMoveEvaluator may be implemented by an Evaluator that wants to
+// know the move that led to the position it is scoring, for instance
+// to tell quiet moves from forcing ones. The search calls
+// EvaluateAfter in place of Evaluate whenever the position was
+// reached by a move it made; `m` is a tak.Pass after a null move.
+type LastMoveEvaluator interface {
+	EvaluateAfter(c *bitboard.Constants, p *tak.Position, m *tak.Move) int64
+}
+
+func (f EvaluationFunc) Evaluate(c *bitboard.Constants, p *tak.Position) int64 {
+	return f(c, p)
+}
+
+func (f EvaluationFunc) Explain(c *bitboard.Constants, out io.Writer, p *tak.Position) {
+	explainFeatures(c, out, p)
+}
+
+// A MinimaxAI may analyze any number of positions of its configured
+// Size, one at a time. Reusing one across the positions of a game
+// keeps its transposition table and move-ordering statistics warm;
+// nothing it retains affects the correctness of a later search, only
+// its speed and, through move ordering, which of several equal moves
+// it reports. Reset returns it to the state NewMinimax left it in.
 type MinimaxAI struct {
-	cfg  MinimaxConfig
+	cfg    MinimaxConfig
+	logger *log.Logger
+	// rand is seeded once, in NewMinimax, and shared by every
+	// search, so that a given Seed reproduces a whole game.
 	rand *rand.Rand
+	seed int64
 
 	st Stats
 	c  bitboard.Constants
@@ -40,21 +105,48 @@ type MinimaxAI struct {
 	history  map[uint64]int
 	response map[uint64]tak.Move
 
-	evaluate EvaluationFunc
+	evaluate Evaluator
+	hooks    MoveHooks
+	scorer   MoveScorer
+	after    LastMoveEvaluator
 
 	table []tableEntry
+	// checks holds the verification hash of each table entry,
+	// if VerifyTable is set.
+	checks []uint64
+
 	depth int
-	stack [maxDepth]struct {
-		p  *tak.Position
-		mg moveGenerator
-		pv [maxDepth]tak.Move
-		m  tak.Move
+	// seldepth is the deepest ply the current analysis has
+	// reached, including extensions.
+	seldepth int
+	// stack holds per-ply search state. It is sized at
+	// construction to cover the configured depth plus any
+	// extensions.
+	stack []searchFrame
 
-		moves [maxMoves]tak.Move
-		vals  [maxMoves]int
+	cancel *int32
+	// tieBreak makes the root search prefer, of equally valued
+	// moves, the one whose FormatMove sorts first; see GetMove.
+	tieBreak bool
+
+	// best is the result of the deepest completed iteration of
+	// the current or most recent Analyze, for CurrentBest.
+	best struct {
+		sync.Mutex
+		pv    []tak.Move
+		value int64
+		depth int
 	}
+}
 
-	cancel *int32
+type searchFrame struct {
+	p  *tak.Position
+	mg moveGenerator
+	pv []tak.Move
+	m  tak.Move
+
+	moves []tak.Move
+	vals  []int
 }
 
 type tableEntry struct {
@@ -62,7 +154,13 @@ type tableEntry struct {
 	depth int
 	value int64
 	bound boundType
-	m     tak.Move
+	// m is the best move, as packed by tak.EncodeMove, so that
+	// entries stay small and hold no pointers.
+	m uint32
+}
+
+func (te *tableEntry) move() tak.Move {
+	return tak.DecodeMove(te.m)
 }
 
 type boundType byte
@@ -95,16 +193,42 @@ type Stats struct {
 
 	AllNodes uint64
 
-	TTHits     uint64
-	TTShortcut uint64
+	TTHits       uint64
+	TTShortcut   uint64
+	TTCollisions uint64
 
 	Extensions    uint64
+	SingleReplies uint64
 	ReducedSlides uint64
 
 	MCSearch uint64
 	MCCut    uint64
 }
 
+// A Bound describes how a SearchInfo's Score relates to the true
+// value of the position at the searched depth.
+type Bound int
+
+const (
+	BoundExact Bound = iota
+	BoundLower
+	BoundUpper
+)
+
+// SearchInfo describes a completed iteration of a search, in the
+// terms a GUI or engine protocol reports progress. Nodes, NPS and
+// Time are cumulative over the whole analysis.
+type SearchInfo struct {
+	Depth    int
+	SelDepth int
+	Nodes    uint64
+	NPS      uint64
+	Time     time.Duration
+	Score    int64
+	PV       []tak.Move
+	Bound    Bound
+}
+
 func (s Stats) Merge(other Stats) Stats {
 	s.Generated += other.Generated
 	s.Evaluated += other.Evaluated
@@ -121,7 +245,9 @@ func (s Stats) Merge(other Stats) Stats {
 	s.AllNodes += other.AllNodes
 	s.TTHits += other.TTHits
 	s.TTShortcut += other.TTShortcut
+	s.TTCollisions += other.TTCollisions
 	s.Extensions += other.Extensions
+	s.SingleReplies += other.SingleReplies
 	s.ReducedSlides += other.ReducedSlides
 	s.MCSearch += other.MCSearch
 	s.MCCut += other.MCCut
@@ -137,6 +263,13 @@ type MinimaxConfig struct {
 	RandomizeWindow int64
 	RandomizeScale  int64
 
+	// RandomEpsilon, if nonzero, makes GetMove choose uniformly
+	// among the root moves whose value is within RandomEpsilon
+	// of the best, so a bot can vary its play without ever
+	// giving up more than that much. It takes precedence over
+	// RandomizeWindow.
+	RandomEpsilon int64
+
 	NoSort         bool
 	NoTable        bool
 	NoNullMove     bool
@@ -144,8 +277,79 @@ type MinimaxConfig struct {
 
 	NoReduceSlides bool
 	NoMultiCut     bool
-
-	Evaluate EvaluationFunc
+	NoCounterMove  bool
+
+	// Multi-cut pruning is tried at expected cut nodes with at
+	// least MultiCutDepth plies left to search. It searches the
+	// first MultiCutWidth moves at reduced depth, and prunes the
+	// node if MultiCutThreshold of them fail high. Zero values
+	// select the defaults, of 6, 3 and 4; a threshold above the
+	// width never prunes.
+	MultiCutWidth     int
+	MultiCutThreshold int
+	MultiCutDepth     int
+
+	// NoExtendFlatRace disables extending the search, at the
+	// horizon, in positions where the game will soon end on
+	// a flat count.
+	NoExtendFlatRace bool
+
+	// NoExtendSingleReply disables searching one ply deeper
+	// below positions where the player to move has exactly one
+	// legal move.
+	NoExtendSingleReply bool
+
+	// BestDefense scores games that end on a flat count by the
+	// margin of the win, so that a player who cannot avoid
+	// losing prefers the line that loses by the fewest flats.
+	// Later losses are always preferred to earlier ones.
+	BestDefense bool
+
+	// ScoreMoves adds a static estimate of each move's value to
+	// the history heuristic when ordering moves. It uses the
+	// Evaluator's ScoreMove if it implements MoveScorer, and
+	// the package ScoreMove otherwise.
+	ScoreMoves bool
+
+	// MoveOrder, if set, replaces the default ordering of the
+	// generated moves – a shuffle at the root, and the history
+	// heuristic elsewhere – by reordering `moves` in place. `te`
+	// is the transposition-table move for `p`, or nil. The TT,
+	// principal-variation and counter-move hints are still tried
+	// before `moves`, and skipped when they come up again.
+	MoveOrder func(p *tak.Position, moves []tak.Move, te *tak.Move)
+
+	// Evaluate and Evaluator configure the evaluation
+	// function. Evaluator takes precedence if both are set; if
+	// neither is, the default weights for Size are used.
+	Evaluate  EvaluationFunc
+	Evaluator Evaluator
+
+	// TaperedWeights, if set, selects MakeTaperedEvaluator with
+	// these weights in place of the default weights for Size. It
+	// is ignored if Evaluate or Evaluator is set.
+	TaperedWeights *TaperedWeights
+
+	// EvalCache, if nonzero, is the number of entries in the
+	// default evaluator's cache of board-shape terms; see
+	// MakeCachedEvaluator. It is ignored if Evaluate,
+	// Evaluator or TaperedWeights is set.
+	EvalCache int
+
+	// VerifyTable stores a second, independent hash of each
+	// position in the transposition table, and ignores entries
+	// whose hash matches but whose check does not, counting them
+	// in Stats.TTCollisions. It is meant for debugging, and
+	// costs an extra word per table entry.
+	VerifyTable bool
+
+	// Logger receives the debug output enabled by Debug. If it
+	// is nil, the standard logger is used.
+	Logger *log.Logger
+
+	// Info, if set, is called with a SearchInfo as each
+	// iteration of Analyze completes.
+	Info func(SearchInfo)
 }
 
 // MakePrecise modifies a MinimaxConfig to produce a MinimaxAI that
@@ -161,6 +365,8 @@ func (cfg *MinimaxConfig) MakePrecise() {
 	cfg.NoExtendForces = true
 	cfg.NoReduceSlides = true
 	cfg.NoMultiCut = true
+	cfg.NoExtendFlatRace = true
+	cfg.NoExtendSingleReply = true
 }
 
 func NewMinimax(cfg MinimaxConfig) *MinimaxAI {
@@ -171,37 +377,83 @@ func NewMinimax(cfg MinimaxConfig) *MinimaxAI {
 	if m.cfg.RandomizeScale == 0 {
 		m.cfg.RandomizeScale = 1
 	}
+	if m.cfg.MultiCutWidth == 0 {
+		m.cfg.MultiCutWidth = multiCutWidth
+	}
+	if m.cfg.MultiCutThreshold == 0 {
+		m.cfg.MultiCutThreshold = multiCutThreshold
+	}
+	if m.cfg.MultiCutDepth == 0 {
+		m.cfg.MultiCutDepth = multiCutDepth
+	}
+	m.seed = m.cfg.Seed
+	if m.seed == 0 {
+		m.seed = time.Now().UnixNano()
+	}
+	m.rand = rand.New(rand.NewSource(m.seed))
+	m.logger = cfg.Logger
+	if m.logger == nil {
+		m.logger = log.Default()
+	}
 	m.precompute()
-	m.evaluate = cfg.Evaluate
-	if m.evaluate == nil {
-		m.evaluate = MakeEvaluator(cfg.Size, nil)
+	switch {
+	case cfg.Evaluator != nil:
+		m.evaluate = cfg.Evaluator
+	case cfg.Evaluate != nil:
+		m.evaluate = cfg.Evaluate
+	case cfg.TaperedWeights != nil:
+		m.evaluate = MakeTaperedEvaluator(cfg.Size, cfg.TaperedWeights)
+	default:
+		m.evaluate = MakeCachedEvaluator(cfg.Size, nil, cfg.EvalCache)
+	}
+	m.hooks, _ = m.evaluate.(MoveHooks)
+	m.after, _ = m.evaluate.(LastMoveEvaluator)
+	if cfg.ScoreMoves {
+		var ok bool
+		if m.scorer, ok = m.evaluate.(MoveScorer); !ok {
+			m.scorer = moveScorerFunc(ScoreMove)
+		}
 	}
 	m.history = make(map[uint64]int, m.cfg.Size*m.cfg.Size*m.cfg.Size)
 	m.response = make(map[uint64]tak.Move, m.cfg.Size*m.cfg.Size*m.cfg.Size)
 	if !cfg.NoTable {
 		m.table = make([]tableEntry, tableSize)
+		if cfg.VerifyTable {
+			m.checks = make([]uint64, tableSize)
+		}
 	}
+	m.stack = make([]searchFrame, m.cfg.Depth+flatRaceExtension+singleReplyExtension+1)
 	for i := range m.stack {
 		m.stack[i].p = tak.Alloc(m.cfg.Size)
+		m.stack[i].pv = make([]tak.Move, len(m.stack))
+		m.stack[i].moves = make([]tak.Move, 0, maxMoves)
+		m.stack[i].vals = make([]int, maxMoves)
 	}
 	return m
 }
 
 const hashMul = 0x61C8864680B583EB
 
-func (m *MinimaxAI) ttGet(h uint64) *tableEntry {
+func (m *MinimaxAI) ttGet(p *tak.Position) *tableEntry {
 	if m.cfg.NoTable {
 		return nil
 	}
+	h := p.Hash()
 	i1 := h % tableSize
 	i2 := (h * hashMul) % tableSize
-	te := &m.table[i1]
-	if te.hash == h {
-		return te
-	}
-	te = &m.table[i2]
-	if te.hash == h {
-		return te
+	for _, i := range [2]uint64{i1, i2} {
+		if te := &m.table[i]; te.hash == h {
+			if m.checks != nil && m.checks[i] != verifyHash(p) {
+				m.st.TTCollisions++
+				if m.cfg.Debug > 3 {
+					mv := te.move()
+					m.logger.Printf("tt collision hash=%x move=%s",
+						h, ptn.FormatMove(&mv))
+				}
+				return nil
+			}
+			return te
+		}
 	}
 	return nil
 }
@@ -217,10 +469,38 @@ func (m *MinimaxAI) ttPut(h uint64) *tableEntry {
 	i2 := (h * hashMul) % tableSize
 	if m.table[i1].hash != 0 {
 		m.table[i2] = m.table[i1]
+		if m.checks != nil {
+			m.checks[i2] = m.checks[i1]
+		}
 	}
 	return &m.table[i1]
 }
 
+// ttVerify records the verification hash of `p`, after its entry
+// has been written by ttPut.
+func (m *MinimaxAI) ttVerify(p *tak.Position) {
+	if m.checks != nil {
+		m.checks[p.Hash()%tableSize] = verifyHash(p)
+	}
+}
+
+// verifyHash hashes `p` independently of Position.Hash, to detect
+// collisions in the table.
+func verifyHash(p *tak.Position) uint64 {
+	h := uint64(p.ToMove()) + 1
+	for _, w := range [4]uint64{p.White, p.Black, p.Standing, p.Caps} {
+		h = (h ^ w) * hashMul
+		h ^= h >> 29
+	}
+	for i, ht := range p.Height {
+		if ht > 1 {
+			h = (h ^ uint64(i)<<56 ^ uint64(ht)<<48 ^ p.Stacks[i]) * hashMul
+			h ^= h >> 29
+		}
+	}
+	return h
+}
+
 func (m *MinimaxAI) precompute() {
 	s := uint(m.cfg.Size)
 	m.c = bitboard.Precompute(s)
@@ -239,13 +519,37 @@ func formatpv(ms []tak.Move) string {
 	return out.String()
 }
 
+// GetMove searches `p` and returns the move to play. Unless the
+// configuration randomizes the choice, or `ctx` has a deadline, ties
+// among the best root moves are broken deterministically: wins are
+// valued higher the sooner they happen, and of equally valued moves,
+// the one whose FormatMove sorts first is played. Under a deadline,
+// the depth the search reaches already depends on timing, so the
+// move is simply the first best move the search finds.
 func (ai *MinimaxAI) GetMove(ctx context.Context, p *tak.Position) tak.Move {
+	m, _ := ai.GetMoveStats(ctx, p)
+	return m
+}
+
+func (ai *MinimaxAI) Name() string {
+	return "minimax"
+}
+
+// GetMoveStats is like GetMove, but also returns the statistics from
+// the search.
+func (ai *MinimaxAI) GetMoveStats(ctx context.Context, p *tak.Position) (tak.Move, Stats) {
+	_, limited := ctx.Deadline()
+	ai.tieBreak = ai.cfg.RandomEpsilon == 0 && ai.cfg.RandomizeWindow == 0 && !limited
 	pv, v, st := ai.Analyze(ctx, p)
+	ai.tieBreak = false
+	if ai.cfg.RandomEpsilon != 0 {
+		return ai.randomNear(p, pv, v, st.Depth), st
+	}
 	if ai.cfg.RandomizeWindow == 0 {
-		return pv[0]
+		return pv[0], st
 	}
 	if v > WinThreshold || v < -WinThreshold {
-		return pv[0]
+		return pv[0], st
 	}
 	rv := pv[0]
 	base := v - ai.cfg.RandomizeWindow
@@ -261,8 +565,10 @@ func (ai *MinimaxAI) GetMove(ctx context.Context, p *tak.Position) tak.Move {
 
 	for m, child := mg.Next(); child != nil; m, child = mg.Next() {
 		ai.stack[0].m = m
+		ai.onMove(p, &m)
 		_, cv := ai.pvSearch(child, 1, st.Depth-1, pv[1:],
 			-v-1, -base)
+		ai.onUndo(p, &m)
 		cv = -cv
 		if cv <= base {
 			continue
@@ -270,7 +576,7 @@ func (ai *MinimaxAI) GetMove(ctx context.Context, p *tak.Position) tak.Move {
 		pts := (cv - base) / ai.cfg.RandomizeScale
 		i += pts
 		if ai.cfg.Debug > 2 {
-			log.Printf("rand m=%s v=%d cv=%d pts=%d i=%d",
+			ai.logger.Printf("rand m=%s v=%d cv=%d pts=%d i=%d",
 				ptn.FormatMove(&m), v, cv, pts, i)
 		}
 		if ai.rand.Int63n(i) <= pts {
@@ -278,11 +584,58 @@ func (ai *MinimaxAI) GetMove(ctx context.Context, p *tak.Position) tak.Move {
 		}
 	}
 
+	return rv, st
+}
+
+// randomNear returns a root move chosen uniformly from those whose
+// value is within RandomEpsilon of `v`, the value of the principal
+// variation. Proven results are never randomized, and if the search
+// is canceled, it returns the PV move.
+func (ai *MinimaxAI) randomNear(p *tak.Position, pv []tak.Move, v int64, depth int) tak.Move {
+	if v > WinThreshold || v < -WinThreshold {
+		return pv[0]
+	}
+	base := v - ai.cfg.RandomEpsilon - 1
+	rv := pv[0]
+	var n int64
+	mg := &ai.stack[0].mg
+	*mg = moveGenerator{
+		ai:    ai,
+		ply:   0,
+		depth: depth,
+		p:     p,
+		pv:    pv,
+	}
+	for m, child := mg.Next(); child != nil; m, child = mg.Next() {
+		ai.stack[0].m = m
+		ai.onMove(p, &m)
+		_, cv := ai.pvSearch(child, 1, depth-1, pv[1:], -v-1, -base)
+		ai.onUndo(p, &m)
+		if atomic.LoadInt32(ai.cancel) != 0 {
+			return pv[0]
+		}
+		if -cv <= base {
+			continue
+		}
+		n++
+		if ai.cfg.Debug > 2 {
+			ai.logger.Printf("epsilon m=%s v=%d cv=%d n=%d",
+				ptn.FormatMove(&m), v, -cv, n)
+		}
+		if ai.rand.Int63n(n) == 0 {
+			rv = m
+		}
+	}
 	return rv
 }
 
 func (ai *MinimaxAI) AnalyzeAll(ctx context.Context, p *tak.Position) ([][]tak.Move, int64, Stats) {
 	pv, v, st := ai.Analyze(ctx, p)
+	if len(pv) == 0 {
+		// The game is over, or the search was canceled
+		// before it found a move.
+		return [][]tak.Move{pv}, v, st
+	}
 	mg := &ai.stack[0].mg
 	*mg = moveGenerator{
 		ai:    ai,
@@ -292,7 +645,7 @@ func (ai *MinimaxAI) AnalyzeAll(ctx context.Context, p *tak.Position) ([][]tak.M
 		pv:    pv,
 	}
 	if ai.cfg.Debug > 1 {
-		log.Printf("[all-search] begin search depth=%d pv=%s v=%d",
+		ai.logger.Printf("[all-search] begin search depth=%d pv=%s v=%d",
 			st.Depth, formatpv(pv), v)
 	}
 	out := [][]tak.Move{pv}
@@ -301,10 +654,12 @@ func (ai *MinimaxAI) AnalyzeAll(ctx context.Context, p *tak.Position) ([][]tak.M
 		// we want to find moves in (v-1, v+1) (i.e. == v). We
 		// invert and negate that to find the α-β window for
 		// the child search: (-v-1, -v+1)
+		ai.onMove(p, &m)
 		ms, cv := ai.pvSearch(child, 1, st.Depth-1, pv[1:], -v-1, -v+1)
+		ai.onUndo(p, &m)
 		cv = -cv
 		if ai.cfg.Debug > 2 {
-			log.Printf("[all-search] m=%s v=%d pv=%s",
+			ai.logger.Printf("[all-search] m=%s v=%d pv=%s",
 				ptn.FormatMove(&m), cv, formatpv(ms))
 		}
 		if cv != v {
@@ -319,7 +674,101 @@ func (ai *MinimaxAI) AnalyzeAll(ctx context.Context, p *tak.Position) ([][]tak.M
 	return out, v, st
 }
 
+// A RootLine is the result of searching a single root move: its
+// value, from the perspective of the player to move at the root, and
+// the principal variation beginning with it.
+type RootLine struct {
+	Move  tak.Move
+	Value int64
+	PV    []tak.Move
+}
+
+type byValue []RootLine
+
+func (s byValue) Len() int           { return len(s) }
+func (s byValue) Less(i, j int) bool { return s[i].Value > s[j].Value }
+func (s byValue) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// RootMoveLines analyzes `p`, and then searches every legal root move
+// with a full window to the depth the analysis reached, returning a
+// line for each, best first. If `ctx` is canceled, it returns the
+// lines that completed, and sets Canceled in the returned Stats.
+func (ai *MinimaxAI) RootMoveLines(ctx context.Context, p *tak.Position) ([]RootLine, Stats) {
+	pv, v, st := ai.Analyze(ctx, p)
+	if len(pv) == 0 || st.Depth == 0 {
+		return nil, st
+	}
+	mg := &ai.stack[0].mg
+	*mg = moveGenerator{
+		ai:    ai,
+		ply:   0,
+		depth: st.Depth,
+		p:     p,
+		pv:    pv,
+	}
+	if ai.cfg.Debug > 1 {
+		ai.logger.Printf("[lines] begin search depth=%d pv=%s v=%d",
+			st.Depth, formatpv(pv), v)
+	}
+	start := time.Now()
+	ai.st = Stats{Depth: st.Depth}
+	ai.depth = st.Depth
+	var out []RootLine
+	for m, child := mg.Next(); child != nil; m, child = mg.Next() {
+		var hint []tak.Move
+		if m.Equal(&pv[0]) {
+			hint = pv[1:]
+		}
+		ai.stack[0].m = m
+		ai.onMove(p, &m)
+		ms, cv := ai.pvSearch(child, 1, st.Depth-1, hint, MinEval-1, MaxEval+1)
+		ai.onUndo(p, &m)
+		if atomic.LoadInt32(ai.cancel) != 0 {
+			st.Canceled = true
+			break
+		}
+		if ai.cfg.Debug > 2 {
+			ai.logger.Printf("[lines] m=%s v=%d pv=%s",
+				ptn.FormatMove(&m), -cv, formatpv(ms))
+		}
+		line := append([]tak.Move{m}, ms...)
+		out = append(out, RootLine{Move: m, Value: -cv, PV: line})
+	}
+	sort.Stable(byValue(out))
+	st = st.Merge(ai.st)
+	st.Elapsed += time.Since(start)
+	return out, st
+}
+
+// CurrentBest returns the principal variation, value, and depth of
+// the deepest iteration the current Analyze has completed, or of the
+// last Analyze if none is running. It may be called from any
+// goroutine, including while Analyze is searching. It returns a nil
+// PV before the first iteration completes.
+func (m *MinimaxAI) CurrentBest() ([]tak.Move, int64, int) {
+	m.best.Lock()
+	defer m.best.Unlock()
+	return append([]tak.Move(nil), m.best.pv...), m.best.value, m.best.depth
+}
+
+func (m *MinimaxAI) setBest(pv []tak.Move, v int64, depth int) {
+	m.best.Lock()
+	defer m.best.Unlock()
+	m.best.pv = append(m.best.pv[:0], pv...)
+	m.best.value = v
+	m.best.depth = depth
+}
+
 func (m *MinimaxAI) Analyze(ctx context.Context, p *tak.Position) ([]tak.Move, int64, Stats) {
+	return m.AnalyzeFrom(ctx, p, nil, 0, 0)
+}
+
+// AnalyzeFrom is like Analyze, but resumes an analysis of `p` that
+// has already completed `depth` plies with principal variation `pv`
+// and value `v` – for instance, as returned by an earlier
+// CurrentBest. Iterative deepening starts at depth+1.
+func (m *MinimaxAI) AnalyzeFrom(ctx context.Context, p *tak.Position,
+	pv []tak.Move, v int64, depth int) ([]tak.Move, int64, Stats) {
 	if m.cfg.Size != p.Size() {
 		panic("Analyze: wrong size")
 	}
@@ -333,28 +782,59 @@ func (m *MinimaxAI) Analyze(ctx context.Context, p *tak.Position) ([]tak.Move, i
 		atomic.StoreInt32(&cancel, 1)
 	}()
 
-	var seed = m.cfg.Seed
-	if seed == 0 {
-		seed = time.Now().Unix()
-	}
-	m.rand = rand.New(rand.NewSource(seed))
 	if m.cfg.Debug > 0 {
-		log.Printf("start search ply=%d color=%s seed=%d",
-			p.MoveNumber(), p.ToMove(), seed)
+		m.logger.Printf("start search ply=%d color=%s seed=%d",
+			p.MoveNumber(), p.ToMove(), m.seed)
 	}
 	deadline, limited := ctx.Deadline()
+	m.setBest(nil, 0, 0)
+	m.seldepth = 0
+
+	if win, child, ok := m.immediateWin(p); ok {
+		// Play a winning move without searching, so we
+		// never prefer a slower win with an equal score.
+		_, winner := child.GameOver()
+		st := Stats{Depth: 1, Evaluated: 1, Terminal: 1}
+		v := -evaluateTerminal(child, winner)
+		m.setBest([]tak.Move{win}, v, 1)
+		m.reportInfo(st, v, []tak.Move{win})
+		return []tak.Move{win}, v, st
+	}
+
+	if only, ok := m.onlyMove(p); ok {
+		// There's no decision to make, so don't spend our
+		// time budget; a one-ply search confirms the move
+		// and provides an evaluation.
+		m.st = Stats{Depth: 1}
+		top := time.Now()
+		m.depth = 1
+		pv, v := m.pvSearch(p, 0, 1, []tak.Move{only}, MinEval-1, MaxEval+1)
+		if len(pv) == 0 {
+			pv = []tak.Move{only}
+		}
+		st := m.st
+		st.Elapsed = time.Since(top)
+		m.setBest(pv, v, 1)
+		m.reportInfo(st, v, pv)
+		return append([]tak.Move(nil), pv...), v, st
+	}
 
 	var next []tak.Move
-	ms := make([]tak.Move, 0, maxDepth)
-	var v, nv int64
+	ms := make([]tak.Move, 0, m.cfg.Depth)
+	var nv int64
 	top := time.Now()
 	var prevEval uint64
 	var branchSum uint64
 	base := 0
-	te := m.ttGet(p.Hash())
-	if te != nil && te.bound == exactBound {
+	if depth > 0 && len(pv) > 0 {
+		base = depth
+		ms = append(ms[:0], pv...)
+		m.setBest(ms, v, depth)
+	}
+	te := m.ttGet(p)
+	if te != nil && te.bound == exactBound && te.depth > base {
 		base = te.depth
-		ms = append(ms[:0], te.m)
+		ms = append(ms[:0], te.move())
 	}
 
 	var st Stats
@@ -370,10 +850,13 @@ func (m *MinimaxAI) Analyze(ctx context.Context, p *tak.Position) ([]tak.Move, i
 		v = nv
 		st = m.st.Merge(st)
 		ms = append(ms[:0], next...)
+		m.setBest(ms, v, i+base)
 		timeUsed := time.Since(top)
+		st.Elapsed = timeUsed
+		m.reportInfo(st, v, ms)
 		timeMove := time.Since(start)
 		if m.cfg.Debug > 0 {
-			log.Printf("[minimax] deepen: depth=%d val=%d pv=%s time=%s total=%s evaluated=%d tt=%d/%d branch=%d",
+			m.logger.Printf("[minimax] deepen: depth=%d val=%d pv=%s time=%s total=%s evaluated=%d tt=%d/%d branch=%d",
 				base+i, v, formatpv(ms),
 				timeMove,
 				timeUsed,
@@ -384,7 +867,7 @@ func (m *MinimaxAI) Analyze(ctx context.Context, p *tak.Position) ([]tak.Move, i
 			)
 		}
 		if m.cfg.Debug > 1 {
-			log.Printf("[minimax]  stats: visited=%d m/ms=%f cut=%d all=%d cut0=%d(%2.2f) cut1=%d(%2.2f) m/cut=%2.2f",
+			m.logger.Printf("[minimax]  stats: visited=%d m/ms=%f cut=%d all=%d cut0=%d(%2.2f) cut1=%d(%2.2f) m/cut=%2.2f",
 				m.st.Visited,
 				float64(m.st.Visited+m.st.Evaluated)/float64(timeMove.Seconds()*1000),
 				m.st.CutNodes,
@@ -395,7 +878,7 @@ func (m *MinimaxAI) Analyze(ctx context.Context, p *tak.Position) ([]tak.Move, i
 				float64(m.st.Cut0+m.st.Cut1)/float64(m.st.CutNodes+1),
 				float64(m.st.CutSearch)/float64(m.st.CutNodes-m.st.Cut0-m.st.Cut1+1),
 			)
-			log.Printf("[minimax]         scout=%d null=%d/%d mc=%d/%d research=%d extend=%d rslide=%d",
+			m.logger.Printf("[minimax]         scout=%d null=%d/%d mc=%d/%d research=%d extend=%d single=%d rslide=%d",
 				m.st.Scout,
 				m.st.NullCut,
 				m.st.NullSearch,
@@ -403,6 +886,7 @@ func (m *MinimaxAI) Analyze(ctx context.Context, p *tak.Position) ([]tak.Move, i
 				m.st.MCSearch,
 				m.st.ReSearch,
 				m.st.Extensions,
+				m.st.SingleReplies,
 				m.st.ReducedSlides,
 			)
 		}
@@ -430,7 +914,7 @@ func (m *MinimaxAI) Analyze(ctx context.Context, p *tak.Position) ([]tak.Move, i
 			estimate := time.Now().Add(time.Since(start) * time.Duration(branch))
 			if estimate.After(deadline) {
 				if m.cfg.Debug > 0 {
-					log.Printf("[minimax] time cutoff: depth=%d used=%s estimate=%s",
+					m.logger.Printf("[minimax] time cutoff: depth=%d used=%s estimate=%s",
 						base+i, timeUsed, estimate.Sub(top))
 				}
 				break
@@ -441,8 +925,111 @@ func (m *MinimaxAI) Analyze(ctx context.Context, p *tak.Position) ([]tak.Move, i
 	return ms, v, st
 }
 
+// reportInfo passes the result of an iteration to the Info callback,
+// if there is one. `st` holds the totals of the analysis so far.
+func (m *MinimaxAI) reportInfo(st Stats, v int64, pv []tak.Move) {
+	if m.cfg.Info == nil {
+		return
+	}
+	info := SearchInfo{
+		Depth:    st.Depth,
+		SelDepth: m.seldepth,
+		Nodes:    st.Visited + st.Evaluated,
+		Time:     st.Elapsed,
+		Score:    v,
+		PV:       append([]tak.Move(nil), pv...),
+		Bound:    BoundExact,
+	}
+	if secs := st.Elapsed.Seconds(); secs > 0 {
+		info.NPS = uint64(float64(info.Nodes) / secs)
+	}
+	m.cfg.Info(info)
+}
+
+// immediateWin returns a move that wins the game on the spot for the
+// player to move in `p`, if there is one, along with the resulting
+// position.
+func (m *MinimaxAI) immediateWin(p *tak.Position) (tak.Move, *tak.Position, bool) {
+	if over, _ := p.GameOver(); over {
+		return tak.Move{}, nil, false
+	}
+	us := p.ToMove()
+	// A placement can only win by completing a road, or by
+	// ending the game on a flat count.
+	stones := p.WhiteStones()
+	if us == tak.Black {
+		stones = p.BlackStones()
+	}
+	empty := bitboard.Popcount(m.c.Mask &^ (p.White | p.Black))
+	places := p.HasImmediateRoadWin(us) || empty == 1 || stones <= 1
+	for _, mv := range p.AllMoves(m.stack[0].moves[:0]) {
+		if !mv.IsSlide() && !places {
+			continue
+		}
+		child, _ := p.MovePreallocated(&mv, m.stack[0].p)
+		if over, winner := child.GameOver(); over && winner == us {
+			return mv, child, true
+		}
+	}
+	return tak.Move{}, nil, false
+}
+
+// onlyMove returns the single legal move in `p`, if there is exactly
+// one.
+func (m *MinimaxAI) onlyMove(p *tak.Position) (tak.Move, bool) {
+	if over, _ := p.GameOver(); over || p.CountMoves() != 1 {
+		return tak.Move{}, false
+	}
+	return p.AllMoves(m.stack[0].moves[:0])[0], true
+}
+
 func (m *MinimaxAI) Evaluate(p *tak.Position) int64 {
-	return m.evaluate(&m.c, p)
+	return m.evaluate.Evaluate(&m.c, p)
+}
+
+// EvaluateAbsolute is like Evaluate, but from White's perspective,
+// regardless of who is to move.
+func (m *MinimaxAI) EvaluateAbsolute(p *tak.Position) int64 {
+	return Absolute(p, m.Evaluate(p))
+}
+
+// A PVStep is one move of a principal variation, annotated by
+// AnnotatePV.
+type PVStep struct {
+	Move tak.Move
+	// Value is the static evaluation of the position after
+	// Move, from the perspective of the player to move at the
+	// start of the variation.
+	Value int64
+}
+
+// AnnotatePV replays `pv` from `p`, and returns the static
+// evaluation after each move, to show where along the line the
+// advantage changes. It stops at the first illegal move, so the
+// result is shorter than `pv` only if `pv` is not playable from `p`.
+func (m *MinimaxAI) AnnotatePV(p *tak.Position, pv []tak.Move) []PVStep {
+	steps := make([]PVStep, 0, len(pv))
+	for i := range pv {
+		next, e := p.Move(&pv[i])
+		if e != nil {
+			break
+		}
+		v := m.Evaluate(next)
+		if i%2 == 0 {
+			v = -v
+		}
+		steps = append(steps, PVStep{Move: pv[i], Value: v})
+		p = next
+	}
+	return steps
+}
+
+// evaluateAt scores `p`, reached at `ply` of the search.
+func (ai *MinimaxAI) evaluateAt(ply int, p *tak.Position) int64 {
+	if ai.after != nil && ply > 0 {
+		return ai.after.EvaluateAfter(&ai.c, p, &ai.stack[ply-1].m)
+	}
+	return ai.evaluate.Evaluate(&ai.c, p)
 }
 
 func teSuffices(te *tableEntry, depth int, α, β int64) bool {
@@ -464,7 +1051,49 @@ func teSuffices(te *tableEntry, depth int, α, β int64) bool {
 	return false
 }
 
-func (ai *MinimaxAI) recordCut(m *tak.Move, move, depth, ply int) {
+// ResetHistory forgets the history and counter-move statistics
+// gathered by earlier searches. Analyze only halves the history
+// scores, so that moves that were good in the previous position are
+// still tried early in the next; when analyzing a batch of unrelated
+// positions that ordering is just noise, and callers should
+// ResetHistory between them instead. The transposition table is not
+// affected.
+func (m *MinimaxAI) ResetHistory() {
+	for k := range m.history {
+		delete(m.history, k)
+	}
+	for k := range m.response {
+		delete(m.response, k)
+	}
+}
+
+// Reset clears the transposition table and the history and
+// counter-move statistics, and reseeds the random number generator,
+// so that the next search behaves exactly as it would on a newly
+// constructed MinimaxAI with the same configuration.
+func (m *MinimaxAI) Reset() {
+	m.ResetHistory()
+	for i := range m.table {
+		m.table[i] = tableEntry{}
+	}
+	for i := range m.checks {
+		m.checks[i] = 0
+	}
+	m.rand = rand.New(rand.NewSource(m.seed))
+	m.setBest(nil, 0, 0)
+}
+
+// historyKey returns the key for `m`, played by `c`, in the history
+// and response tables, so each side's statistics are kept separately.
+func historyKey(c tak.Color, m *tak.Move) uint64 {
+	h := m.Hash() << 1
+	if c == tak.Black {
+		h |= 1
+	}
+	return h
+}
+
+func (ai *MinimaxAI) recordCut(c tak.Color, m *tak.Move, move, depth, ply int) {
 	ai.st.CutNodes++
 	switch move {
 	case 1:
@@ -474,9 +1103,21 @@ func (ai *MinimaxAI) recordCut(m *tak.Move, move, depth, ply int) {
 	default:
 		ai.st.CutSearch += uint64(move + 1)
 	}
-	ai.history[m.Hash()] += (1 << uint(depth))
+	ai.history[historyKey(c, m)] += (1 << uint(depth))
 	if ply > 0 {
-		ai.response[ai.stack[ply-1].m.Hash()] = *m
+		ai.response[historyKey(c.Flip(), &ai.stack[ply-1].m)] = *m
+	}
+}
+
+func (ai *MinimaxAI) onMove(p *tak.Position, m *tak.Move) {
+	if ai.hooks != nil {
+		ai.hooks.OnMove(p, m)
+	}
+}
+
+func (ai *MinimaxAI) onUndo(p *tak.Position, m *tak.Move) {
+	if ai.hooks != nil {
+		ai.hooks.OnUndo(p, m)
 	}
 }
 
@@ -485,13 +1126,30 @@ func (ai *MinimaxAI) pvSearch(
 	ply, depth int,
 	pv []tak.Move,
 	α, β int64) ([]tak.Move, int64) {
+	if ply > ai.seldepth {
+		ai.seldepth = ply
+	}
 	over, _ := p.GameOver()
+	if depth <= 0 && !over && ai.extendFlatRace(ply, p) {
+		ai.st.Extensions++
+		depth = 1
+	}
+	if !over && ai.extendSingleReply(ply, depth, p) {
+		ai.st.SingleReplies++
+		if depth < 0 {
+			depth = 0
+		}
+		depth++
+	}
 	if depth <= 0 || over {
 		ai.st.Evaluated++
 		if over {
 			ai.st.Terminal++
+			if ai.cfg.BestDefense {
+				return nil, evaluateDefense(p)
+			}
 		}
-		return nil, ai.evaluate(&ai.c, p)
+		return nil, ai.evaluateAt(ply, p)
 	}
 
 	ai.st.Visited++
@@ -499,14 +1157,15 @@ func (ai *MinimaxAI) pvSearch(
 		ai.st.Scout++
 	}
 
-	te := ai.ttGet(p.Hash())
+	te := ai.ttGet(p)
 	if te != nil {
 		ai.st.TTHits++
 		if teSuffices(te, depth, α, β) {
-			_, e := p.MovePreallocated(&te.m, ai.stack[ply].p)
+			m := te.move()
+			_, e := p.MovePreallocated(&m, ai.stack[ply].p)
 			if e == nil {
 				ai.st.TTShortcut++
-				ai.stack[ply].pv[0] = te.m
+				ai.stack[ply].pv[0] = m
 				return ai.stack[ply].pv[:1], te.value
 			}
 			te = nil
@@ -538,19 +1197,29 @@ func (ai *MinimaxAI) pvSearch(
 		if len(best) != 0 {
 			newpv = best[1:]
 		}
+		// lo is the value `m` must beat to replace the best
+		// move. When breaking ties, a root move that sorts
+		// first replaces it on a tie, too.
+		lo := α
+		if ply == 0 && ai.tieBreak && i > 1 &&
+			ptn.FormatMove(&m) < ptn.FormatMove(&best[0]) {
+			lo = α - 1
+		}
 		ai.stack[ply].m = m
+		ai.onMove(p, &m)
 		if i > 1 {
-			ms, v = ai.zwSearch(child, ply+1, depth-1, newpv, -α-1, true)
-			if -v > α && -v < β {
+			ms, v = ai.zwSearch(child, ply+1, depth-1, newpv, -lo-1, true)
+			if -v > lo && -v < β {
 				ai.st.ReSearch++
-				ms, v = ai.pvSearch(child, ply+1, depth-1, newpv, -β, -α)
+				ms, v = ai.pvSearch(child, ply+1, depth-1, newpv, -β, -lo)
 			}
 		} else {
 			ms, v = ai.pvSearch(child, ply+1, depth-1, newpv, -β, -α)
 		}
+		ai.onUndo(p, &m)
 		v = -v
 		if ai.cfg.Debug > 4+ply {
-			log.Printf("%*s search ply=%d d=%d e=%d m=%s w=(%d,%d) v=%d pv=%s",
+			ai.logger.Printf("%*s search ply=%d d=%d e=%d m=%s w=(%d,%d) v=%d pv=%s",
 				ply, "", ply, depth, ai.st.Extensions,
 				ptn.FormatMove(&m), α, β, v, formatpv(ms))
 		}
@@ -559,13 +1228,13 @@ func (ai *MinimaxAI) pvSearch(
 			best = append(best[:0], m)
 			best = append(best, ms...)
 		}
-		if v > α {
+		if v > lo {
 			improved = true
 			best = append(best[:0], m)
 			best = append(best, ms...)
 			α = v
 			if α >= β {
-				ai.recordCut(&m, i, depth, ply)
+				ai.recordCut(p.ToMove(), &m, i, depth, ply)
 				break
 			}
 		}
@@ -577,8 +1246,9 @@ func (ai *MinimaxAI) pvSearch(
 	hash := p.Hash()
 	if te = ai.ttPut(hash); te != nil && (te.hash != hash || te.depth <= depth) {
 		te.hash = hash
+		ai.ttVerify(p)
 		te.depth = depth
-		te.m = best[0]
+		te.m = best[0].EncodeMove()
 		te.value = α
 		if !improved {
 			te.bound = upperBound
@@ -598,26 +1268,44 @@ func (ai *MinimaxAI) zwSearch(
 	ply, depth int,
 	pv []tak.Move,
 	α int64, cut bool) ([]tak.Move, int64) {
+	if ply > ai.seldepth {
+		ai.seldepth = ply
+	}
 	over, _ := p.GameOver()
+	if depth <= 0 && !over && ai.extendFlatRace(ply, p) {
+		ai.st.Extensions++
+		depth = 1
+	}
+	if !over && ai.extendSingleReply(ply, depth, p) {
+		ai.st.SingleReplies++
+		if depth < 0 {
+			depth = 0
+		}
+		depth++
+	}
 	if depth <= 0 || over {
 		ai.st.Evaluated++
 		if over {
 			ai.st.Terminal++
+			if ai.cfg.BestDefense {
+				return nil, evaluateDefense(p)
+			}
 		}
-		return nil, ai.evaluate(&ai.c, p)
+		return nil, ai.evaluateAt(ply, p)
 	}
 
 	ai.st.Visited++
 	ai.st.Scout++
 
-	te := ai.ttGet(p.Hash())
+	te := ai.ttGet(p)
 	if te != nil {
 		ai.st.TTHits++
 		if teSuffices(te, depth, α, α+1) {
-			_, e := p.MovePreallocated(&te.m, ai.stack[ply].p)
+			m := te.move()
+			_, e := p.MovePreallocated(&m, ai.stack[ply].p)
 			if e == nil {
 				ai.st.TTShortcut++
-				ai.stack[ply].pv[0] = te.m
+				ai.stack[ply].pv[0] = m
 				return ai.stack[ply].pv[:1], te.value
 			}
 			te = nil
@@ -629,7 +1317,9 @@ func (ai *MinimaxAI) zwSearch(
 		child, e := p.MovePreallocated(&ai.stack[ply].m, ai.stack[ply].p)
 		if e == nil {
 			ai.st.NullSearch++
+			ai.onMove(p, &ai.stack[ply].m)
 			_, v := ai.zwSearch(child, ply+1, depth-3, nil, -α-1, true)
+			ai.onUndo(p, &ai.stack[ply].m)
 			v = -v
 			if v >= α+1 {
 				ai.st.NullCut++
@@ -663,15 +1353,18 @@ func (ai *MinimaxAI) zwSearch(
 
 	var i int
 
-	if cut && depth > 3 && !ai.cfg.NoMultiCut {
+	if cut && depth >= ai.cfg.MultiCutDepth && !ai.cfg.NoMultiCut {
 		cuts := 0
 		ai.st.MCSearch++
-		for _, child := mg.Next(); child != nil && i < multiCutSearch; _, child = mg.Next() {
+		for m, child := mg.Next(); child != nil && i < ai.cfg.MultiCutWidth; m, child = mg.Next() {
 			i++
+			ai.stack[ply].m = m
+			ai.onMove(p, &m)
 			_, v := ai.zwSearch(child, ply+1, depth-1-2, nil, -α-1, !cut)
+			ai.onUndo(p, &m)
 			if -v > α {
 				cuts++
-				if cuts >= multiCutThreshold {
+				if cuts >= ai.cfg.MultiCutThreshold {
 					ai.st.MCCut++
 					return nil, α + 1
 				}
@@ -694,7 +1387,9 @@ func (ai *MinimaxAI) zwSearch(
 			newpv = best[1:]
 		}
 		ai.stack[ply].m = m
+		ai.onMove(p, &m)
 		ms, v = ai.zwSearch(child, ply+1, depth-1, newpv, -α-1, !cut)
+		ai.onUndo(p, &m)
 		v = -v
 
 		if len(best) == 0 {
@@ -705,7 +1400,7 @@ func (ai *MinimaxAI) zwSearch(
 			best = append(best[:0], m)
 			best = append(best, ms...)
 			didCut = true
-			ai.recordCut(&m, i, depth, ply)
+			ai.recordCut(p.ToMove(), &m, i, depth, ply)
 			break
 		}
 		if atomic.LoadInt32(ai.cancel) != 0 {
@@ -715,8 +1410,9 @@ func (ai *MinimaxAI) zwSearch(
 
 	if te = ai.ttPut(p.Hash()); te != nil {
 		te.hash = p.Hash()
+		ai.ttVerify(p)
 		te.depth = depth
-		te.m = best[0]
+		te.m = best[0].EncodeMove()
 		te.value = α
 		if didCut {
 			te.bound = lowerBound
@@ -732,6 +1428,59 @@ func (ai *MinimaxAI) zwSearch(
 	return best, α
 }
 
+// extendFlatRace reports whether to search past the horizon at `p`
+// because the game could end on a flat count within a few plies,
+// where the static evaluation is least reliable. Positions at most
+// flatRaceExtension plies past the nominal depth are extended.
+func (ai *MinimaxAI) extendFlatRace(ply int, p *tak.Position) bool {
+	if ai.cfg.NoExtendFlatRace {
+		return false
+	}
+	if ply >= ai.depth+flatRaceExtension || ply+1 >= len(ai.stack) {
+		return false
+	}
+	// The earliest the game can end: each side places a stone
+	// every other ply, until someone runs out of stones or the
+	// board fills up. We ignore capstones, which only makes us
+	// extend a bit early.
+	ours, theirs := p.WhiteStones(), p.BlackStones()
+	if p.ToMove() == tak.Black {
+		ours, theirs = theirs, ours
+	}
+	end := bitboard.Popcount(ai.c.Mask &^ (p.White | p.Black))
+	if n := 2*ours - 1; n < end {
+		end = n
+	}
+	if n := 2 * theirs; n < end {
+		end = n
+	}
+	return end <= flatRaceHorizon
+}
+
+// extendSingleReply reports whether to search `p`, at `ply` with
+// `depth` plies remaining, one ply deeper because the player to move
+// has a single legal reply. The extension costs nothing in branching,
+// but is bounded to singleReplyExtension plies past the nominal
+// depth.
+func (ai *MinimaxAI) extendSingleReply(ply, depth int, p *tak.Position) bool {
+	if ai.cfg.NoExtendSingleReply || ply == 0 {
+		return false
+	}
+	if depth < 0 {
+		depth = 0
+	}
+	if ply+depth >= ai.depth+singleReplyExtension || ply+depth+1 >= len(ai.stack) {
+		return false
+	}
+	// A player with a move has pieces in reserve, so every
+	// empty square allows a placement. This rules out nearly
+	// every node without counting moves.
+	if bitboard.Popcount(ai.c.Mask&^(p.White|p.Black)) > 1 {
+		return false
+	}
+	return p.CountMoves() == 1
+}
+
 func (ai *MinimaxAI) nullMoveOK(ply, depth int, p *tak.Position) bool {
 	if ai.cfg.NoNullMove {
 		return false
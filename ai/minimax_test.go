@@ -1,12 +1,21 @@
 package ai
 
 import (
+	"bytes"
 	"flag"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
 
 	"golang.org/x/net/context"
 
+	"github.com/nelhage/taktician/bitboard"
 	"github.com/nelhage/taktician/ptn"
 	"github.com/nelhage/taktician/tak"
 )
@@ -121,3 +130,889 @@ func TestRepeatedCancel(t *testing.T) {
 		t.Fatal("did not do full search")
 	}
 }
+
+// hookEvaluator checks that MoveHooks notifications are balanced and
+// describe the positions the search evaluates.
+type hookEvaluator struct {
+	t     *testing.T
+	eval  EvaluationFunc
+	stack []*tak.Position
+	calls int
+}
+
+func (h *hookEvaluator) Evaluate(c *bitboard.Constants, p *tak.Position) int64 {
+	if len(h.stack) > 0 && h.stack[len(h.stack)-1].Hash() != p.Hash() {
+		h.t.Errorf("Evaluate: position does not match hooks")
+	}
+	return h.eval(c, p)
+}
+
+func (h *hookEvaluator) Explain(c *bitboard.Constants, out io.Writer, p *tak.Position) {
+}
+
+func (h *hookEvaluator) OnMove(p *tak.Position, m *tak.Move) {
+	h.calls++
+	if len(h.stack) > 0 && h.stack[len(h.stack)-1].Hash() != p.Hash() {
+		h.t.Errorf("OnMove %s: parent does not match", ptn.FormatMove(m))
+	}
+	child, e := p.Move(m)
+	if e != nil {
+		h.t.Fatalf("OnMove: illegal move %s: %v", ptn.FormatMove(m), e)
+	}
+	h.stack = append(h.stack, child)
+}
+
+func (h *hookEvaluator) OnUndo(p *tak.Position, m *tak.Move) {
+	if len(h.stack) == 0 {
+		h.t.Fatalf("OnUndo %s: empty stack", ptn.FormatMove(m))
+	}
+	h.stack = h.stack[:len(h.stack)-1]
+	if len(h.stack) > 0 && h.stack[len(h.stack)-1].Hash() != p.Hash() {
+		h.t.Errorf("OnUndo %s: parent does not match", ptn.FormatMove(m))
+	}
+}
+
+func TestMoveHooks(t *testing.T) {
+	p, e := ptn.ParseTPS(`x3,2,x/x4,12/1,1,x,1,21C/x,1,x,12111112C,2/2,x,22121,x,2 2 20`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	h := &hookEvaluator{t: t, eval: MakeEvaluator(5, nil)}
+	withHooks := NewMinimax(MinimaxConfig{Size: 5, Depth: 4, Seed: 1, Evaluator: h})
+	plain := NewMinimax(MinimaxConfig{Size: 5, Depth: 4, Seed: 1})
+	pv1, v1, _ := withHooks.Analyze(context.Background(), p)
+	pv2, v2, _ := plain.Analyze(context.Background(), p)
+	if len(h.stack) != 0 {
+		t.Errorf("unbalanced hooks: depth=%d", len(h.stack))
+	}
+	if h.calls == 0 {
+		t.Errorf("hooks were never called")
+	}
+	if v1 != v2 || !pv1[0].Equal(&pv2[0]) {
+		t.Errorf("hooks changed the search: %s=%d, want %s=%d",
+			ptn.FormatMove(&pv1[0]), v1, ptn.FormatMove(&pv2[0]), v2)
+	}
+}
+
+// lastMoveEvaluator checks that EvaluateAfter is passed the move the
+// hooks last reported, and scores placing a wall as a win for the
+// player who placed it.
+type lastMoveEvaluator struct {
+	*hookEvaluator
+	moves []tak.Move
+	after int
+}
+
+func (l *lastMoveEvaluator) OnMove(p *tak.Position, m *tak.Move) {
+	l.hookEvaluator.OnMove(p, m)
+	l.moves = append(l.moves, *m)
+}
+
+func (l *lastMoveEvaluator) OnUndo(p *tak.Position, m *tak.Move) {
+	l.hookEvaluator.OnUndo(p, m)
+	l.moves = l.moves[:len(l.moves)-1]
+}
+
+func (l *lastMoveEvaluator) EvaluateAfter(c *bitboard.Constants, p *tak.Position, m *tak.Move) int64 {
+	l.after++
+	if len(l.moves) == 0 || !l.moves[len(l.moves)-1].Equal(m) {
+		l.t.Errorf("EvaluateAfter %s: does not match hooks", ptn.FormatMove(m))
+	}
+	v := l.Evaluate(c, p)
+	if m.Type == tak.PlaceStanding {
+		v -= WinThreshold / 2
+	}
+	return v
+}
+
+func TestLastMoveEvaluator(t *testing.T) {
+	p, e := ptn.ParseTPS(`x3,2,x/x4,12/1,1,x,1,21C/x,1,x,12111112C,2/2,x,22121,x,2 2 20`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	for _, depth := range []int{1, 4, 6} {
+		l := &lastMoveEvaluator{
+			hookEvaluator: &hookEvaluator{t: t, eval: MakeEvaluator(5, nil)},
+		}
+		ai := NewMinimax(MinimaxConfig{Size: 5, Depth: depth, Seed: 1, Evaluator: l})
+		pv, _, st := ai.Analyze(context.Background(), p)
+		if l.after == 0 {
+			t.Fatalf("depth=%d: EvaluateAfter was never called", depth)
+		}
+		if depth >= 6 && st.MCSearch == 0 {
+			t.Errorf("depth=%d: multi-cut was never tried", depth)
+		}
+		if depth == 1 && pv[0].Type != tak.PlaceStanding {
+			t.Errorf("depth=1: played %s, want a wall", ptn.FormatMove(&pv[0]))
+		}
+	}
+}
+
+func TestAnnotatePV(t *testing.T) {
+	p, e := ptn.ParseTPS(`x3,2,x/x4,12/1,1,x,1,21C/x,1,x,12111112C,2/2,x,22121,x,2 2 20`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: 5, Depth: 4, Seed: 1})
+	pv, _, _ := ai.Analyze(context.Background(), p)
+	steps := ai.AnnotatePV(p, pv)
+	if len(steps) != len(pv) {
+		t.Fatalf("AnnotatePV: %d steps for a pv of %d moves", len(steps), len(pv))
+	}
+	pos := p
+	for i, st := range steps {
+		if !st.Move.Equal(&pv[i]) {
+			t.Errorf("step %d: move=%s, want %s", i, ptn.FormatMove(&st.Move), ptn.FormatMove(&pv[i]))
+		}
+		pos, _ = pos.Move(&pv[i])
+		want := ai.Evaluate(pos)
+		if pos.ToMove() != p.ToMove() {
+			want = -want
+		}
+		if st.Value != want {
+			t.Errorf("step %d: value=%d, want %d", i, st.Value, want)
+		}
+	}
+
+	bad := append([]tak.Move{pv[0]}, pv...)
+	if steps := ai.AnnotatePV(p, bad); len(steps) != 1 {
+		t.Errorf("illegal pv: %d steps, want 1", len(steps))
+	}
+}
+
+func TestAnalyzeAllGameOver(t *testing.T) {
+	p, e := ptn.ParseTPS(`x5/x5/x5/2,2,2,2,x/1,1,1,1,1 2 6`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: 5, Depth: 3})
+	pvs, _, _ := ai.AnalyzeAll(context.Background(), p)
+	if len(pvs) != 1 || len(pvs[0]) != 0 {
+		t.Errorf("game over: pvs=%v", pvs)
+	}
+}
+
+func TestHistoryKey(t *testing.T) {
+	p := tak.New(tak.Config{Size: 5})
+	seen := make(map[uint64]tak.Move)
+	for _, m := range p.AllMoves(nil) {
+		for _, c := range []tak.Color{tak.White, tak.Black} {
+			m := m
+			k := historyKey(c, &m)
+			if o, ok := seen[k]; ok {
+				t.Errorf("collision: %s and %s", ptn.FormatMove(&o), ptn.FormatMove(&m))
+			}
+			seen[k] = m
+		}
+	}
+}
+
+func TestOnlyMove(t *testing.T) {
+	// White has only its capstone left, and controls no stacks,
+	// so its only move is to place the capstone on a3. Black's
+	// walls keep it from already having a road.
+	p, e := ptn.ParseTPSConfig(tak.Config{Size: 3, Pieces: 8, Capstones: 1},
+		`x,12,12/12S,12S,12S/12,12S,12 1 9`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	if over, _ := p.GameOver(); over {
+		t.Fatal("game is already over")
+	}
+	want := tak.Move{X: 0, Y: 2, Type: tak.PlaceCapstone}
+	ai := NewMinimax(MinimaxConfig{Size: 3, Depth: maxDepth})
+	pv, _, st := ai.Analyze(context.Background(), p)
+	if len(pv) == 0 || !pv[0].Equal(&want) {
+		t.Fatalf("pv=%s, want %s", formatpv(pv), ptn.FormatMove(&want))
+	}
+	if st.Depth != 1 {
+		t.Errorf("searched to depth %d", st.Depth)
+	}
+	if st.Visited+st.Evaluated == 0 {
+		t.Errorf("empty stats")
+	}
+	if m := ai.GetMove(context.Background(), p); !m.Equal(&want) {
+		t.Errorf("GetMove=%s, want %s", ptn.FormatMove(&m), ptn.FormatMove(&want))
+	}
+}
+
+func TestImmediateWin(t *testing.T) {
+	cases := []struct {
+		tps  string
+		move string
+	}{
+		{`x5/x5/2,2,2,2,x/x5/1,1,1,1,x 1 5`, "e1"},
+		{`x5/x5/2,2,2,2,x/x5/1,1,1,1,x 2 5`, "e3"},
+		// the road is completed by a slide
+		{`x5/x5/2,2,x,21,x/x3,2,1/1,1,1,1,2S 1 6`, "d3-"},
+	}
+	for i, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatalf("%d: tps: %v", i, e)
+		}
+		ai := NewMinimax(MinimaxConfig{Size: 5, Depth: maxDepth})
+		pv, v, st := ai.Analyze(context.Background(), p)
+		if got := ptn.FormatMove(&pv[0]); got != tc.move {
+			t.Errorf("%d: move=%s, want %s", i, got, tc.move)
+		}
+		if v < WinThreshold {
+			t.Errorf("%d: v=%d, want a win", i, v)
+		}
+		if st.Depth != 1 || st.Visited != 0 {
+			t.Errorf("%d: searched: depth=%d visited=%d", i, st.Depth, st.Visited)
+		}
+	}
+}
+
+func TestExtendFlatRace(t *testing.T) {
+	// Three squares are left to fill, and Black wins the flat
+	// count with correct play; a one-ply search can't see that
+	// without extending.
+	p, e := ptn.ParseTPS(`x,2,x,2/x,2,2S,2S/2S,1S,1,1/2S,1,1S,1 2 10`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	cfg := MinimaxConfig{Size: 4, Depth: 1, NoTable: true}
+
+	plain := cfg
+	plain.NoExtendFlatRace = true
+	_, v, _ := NewMinimax(plain).Analyze(context.Background(), p)
+	if v > WinThreshold {
+		t.Fatalf("unextended search found the win: v=%d", v)
+	}
+
+	pv, v, st := NewMinimax(cfg).Analyze(context.Background(), p)
+	if v < WinThreshold {
+		t.Errorf("extended search missed the win: v=%d pv=%s", v, formatpv(pv))
+	}
+	if st.Extensions == 0 {
+		t.Errorf("no extensions")
+	}
+}
+
+func TestExtendSingleReply(t *testing.T) {
+	// If Black places on a2 or a3, White's only move is to place
+	// its capstone on the other, which fills the board and loses
+	// the flat count. A one-ply search only sees that by
+	// extending.
+	p, e := ptn.ParseTPSConfig(tak.Config{Size: 3, Pieces: 10, Capstones: 1},
+		`x,112S,2/x,112S,112S/2,1112S,12 2 9`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	cfg := MinimaxConfig{Size: 3, Depth: 1, NoTable: true, NoExtendFlatRace: true}
+
+	plain := cfg
+	plain.NoExtendSingleReply = true
+	pv, v, st := NewMinimax(plain).Analyze(context.Background(), p)
+	if v > WinThreshold {
+		t.Fatalf("unextended search found the win: v=%d pv=%s", v, formatpv(pv))
+	}
+	if st.SingleReplies != 0 {
+		t.Errorf("disabled, but extended %d times", st.SingleReplies)
+	}
+
+	pv, v, st = NewMinimax(cfg).Analyze(context.Background(), p)
+	if v < WinThreshold {
+		t.Errorf("extended search missed the win: v=%d pv=%s", v, formatpv(pv))
+	}
+	if st.SingleReplies == 0 {
+		t.Errorf("no single-reply extensions")
+	}
+	if st.Extensions != 0 {
+		t.Errorf("counted %d flat-race extensions", st.Extensions)
+	}
+}
+
+func TestBestDefense(t *testing.T) {
+	// Black must fill a3, which ends the game on a flat count
+	// that White wins. A flat there loses by four flats, and a
+	// wall by five.
+	p, e := ptn.ParseTPS(`x,1,1S/1,1S,1/1S,1,1 2 5`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	values := func(cfg MinimaxConfig, p *tak.Position) map[tak.MoveType]int64 {
+		lines, _ := NewMinimax(cfg).RootMoveLines(context.Background(), p)
+		out := make(map[tak.MoveType]int64)
+		for _, l := range lines {
+			out[l.Move.Type] = l.Value
+		}
+		return out
+	}
+
+	cfg := MinimaxConfig{Size: 3, Depth: 2, Seed: 1}
+	plain := values(cfg, p)
+	if len(plain) != 2 || plain[tak.PlaceFlat] != plain[tak.PlaceStanding] {
+		t.Fatalf("plain: values=%v, want a tie", plain)
+	}
+	if plain[tak.PlaceFlat] > -WinThreshold {
+		t.Fatalf("plain: v=%d, want a loss", plain[tak.PlaceFlat])
+	}
+
+	cfg.BestDefense = true
+	defense := values(cfg, p)
+	if d := defense[tak.PlaceFlat] - defense[tak.PlaceStanding]; d != 2 {
+		t.Errorf("best defense: values=%v, want the flat to score one flat better", defense)
+	}
+
+	// With six komi Black wins either way, by two flats with a
+	// flat on a3 and by one with a wall.
+	kp, e := ptn.ParseTPSConfig(tak.Config{Size: 3, HalfKomi: 12}, `x,1,1S/1,1S,1/1S,1,1 2 5`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	komi := values(cfg, kp)
+	if komi[tak.PlaceStanding] < WinThreshold {
+		t.Fatalf("komi: values=%v, want wins", komi)
+	}
+	if d := komi[tak.PlaceFlat] - komi[tak.PlaceStanding]; d != 2 {
+		t.Errorf("komi: values=%v, want the flat to score one flat better", komi)
+	}
+	for seed := int64(1); seed <= 5; seed++ {
+		cfg.Seed = seed
+		if m := NewMinimax(cfg).GetMove(context.Background(), p); m.Type != tak.PlaceFlat {
+			t.Errorf("seed=%d: played %s, want a3", seed, ptn.FormatMove(&m))
+		}
+	}
+}
+
+func TestMultiCutConfig(t *testing.T) {
+	p, e := ptn.ParseTPS(`2,x,1,x3/x,2,1,2,x2/x,1,221C,1,x2/x2,2S,12C,x2/x6/x6 1 9`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	search := func(cfg MinimaxConfig) Stats {
+		cfg.Size, cfg.Depth, cfg.Seed = 6, 5, 1
+		_, _, st := NewMinimax(cfg).Analyze(context.Background(), p)
+		return st
+	}
+	if st := search(MinimaxConfig{}); st.MCCut == 0 {
+		t.Fatalf("defaults: no multi-cuts in %d searches", st.MCSearch)
+	}
+	st := search(MinimaxConfig{MultiCutWidth: 4, MultiCutThreshold: 5})
+	if st.MCSearch == 0 || st.MCCut != 0 {
+		t.Errorf("threshold above width: MCSearch=%d MCCut=%d", st.MCSearch, st.MCCut)
+	}
+	if st := search(MinimaxConfig{MultiCutDepth: 6}); st.MCSearch != 0 {
+		t.Errorf("depth 6: MCSearch=%d in a depth-5 search", st.MCSearch)
+	}
+}
+
+func TestGetMoveStats(t *testing.T) {
+	p, e := ptn.ParseTPS(`2,x4/x2,2,x2/x,2,2,x2/x2,12,2,1/1,1,21,2,1 1 9`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	for _, window := range []int64{0, 100} {
+		ai := NewMinimax(MinimaxConfig{Size: 5, Depth: 3, Seed: 1, RandomizeWindow: window})
+		m, st := ai.GetMoveStats(context.Background(), p)
+		if _, e := p.Move(&m); e != nil {
+			t.Errorf("window=%d: illegal move %s: %v", window, ptn.FormatMove(&m), e)
+		}
+		if st.Depth != 3 || st.Visited == 0 || st.Evaluated == 0 || st.Elapsed == 0 {
+			t.Errorf("window=%d: empty stats: %+v", window, st)
+		}
+	}
+}
+
+func TestGetMoveTieBreak(t *testing.T) {
+	// The position is symmetric, so every move has at least one
+	// equally good mirror image.
+	p, e := ptn.ParseTPS(`x5/x5/x2,1,x2/x5/x5 2 1`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	var first tak.Move
+	for seed := int64(1); seed <= 5; seed++ {
+		ai := NewMinimax(MinimaxConfig{Size: 5, Depth: 3, Seed: seed})
+		m := ai.GetMove(context.Background(), p)
+		if seed == 1 {
+			first = m
+		} else if !m.Equal(&first) {
+			t.Errorf("seed=%d: GetMove=%s, seed=1 chose %s",
+				seed, ptn.FormatMove(&m), ptn.FormatMove(&first))
+		}
+	}
+
+	// GetMove plays the tied move that sorts first.
+	cfg := MinimaxConfig{Size: 5, Depth: 2, Seed: 1}
+	cfg.MakePrecise()
+	lines, _ := NewMinimax(cfg).RootMoveLines(context.Background(), p)
+	want := ""
+	for _, l := range lines {
+		if n := ptn.FormatMove(&l.Move); l.Value == lines[0].Value && (want == "" || n < want) {
+			want = n
+		}
+	}
+	if m := NewMinimax(cfg).GetMove(context.Background(), p); ptn.FormatMove(&m) != want {
+		t.Errorf("precise GetMove=%s, want %s", ptn.FormatMove(&m), want)
+	}
+
+	// Under a time limit, GetMove plays the PV move without
+	// breaking ties.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for seed := int64(1); seed <= 5; seed++ {
+		cfg := MinimaxConfig{Size: 5, Depth: 3, Seed: seed}
+		pv, _, _ := NewMinimax(cfg).Analyze(context.Background(), p)
+		if m := NewMinimax(cfg).GetMove(ctx, p); !m.Equal(&pv[0]) {
+			t.Errorf("seed=%d: limited GetMove=%s, pv=%s",
+				seed, ptn.FormatMove(&m), formatpv(pv))
+		}
+	}
+}
+
+func TestDeepSearch(t *testing.T) {
+	p, e := ptn.ParseTPS(`x3,2,x/x4,12/1,1,x,1,21C/x,1,x,12111112C,2/2,x,22121,x,2 2 20`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	const depth = 24
+	ai := NewMinimax(MinimaxConfig{Size: 5, Depth: depth})
+	if len(ai.stack) <= depth+flatRaceExtension {
+		t.Fatalf("stack has %d plies, want more than %d",
+			len(ai.stack), depth+flatRaceExtension)
+	}
+	// Search the last couple of plies of a deep iteration
+	// directly, past where the stack used to end.
+	var cancel int32
+	ai.cancel = &cancel
+	ai.depth = depth
+	ply := depth - 2
+	pv, _ := ai.pvSearch(p, ply, 2, nil, MinEval-1, MaxEval+1)
+	if len(pv) == 0 {
+		t.Fatal("no pv")
+	}
+	if _, e := p.Move(&pv[0]); e != nil {
+		t.Fatalf("illegal pv move %s: %v", ptn.FormatMove(&pv[0]), e)
+	}
+}
+
+func TestLargeBoards(t *testing.T) {
+	for _, size := range []int{7, 8} {
+		if DefaultWeights[size].Groups[size-1] == 0 {
+			t.Errorf("size=%d: no weight for %d-wide groups", size, size-1)
+		}
+		p := tak.New(tak.Config{Size: size})
+		for _, m := range []string{"a1", "d4", "e4"} {
+			mv, e := ptn.ParseMove(m)
+			if e != nil {
+				t.Fatalf("parse %s: %v", m, e)
+			}
+			if p, e = p.Move(&mv); e != nil {
+				t.Fatalf("size=%d: %s: %v", size, m, e)
+			}
+		}
+		ai := NewMinimax(MinimaxConfig{Size: size, Depth: 3, Seed: 1})
+		m := ai.GetMove(context.Background(), p)
+		if _, e := p.Move(&m); e != nil {
+			t.Errorf("size=%d: illegal move %s: %v", size, ptn.FormatMove(&m), e)
+		}
+	}
+}
+
+func TestCurrentBest(t *testing.T) {
+	p, e := ptn.ParseTPS(`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: 5, Depth: 5, Seed: 1})
+	type result struct {
+		pv    []tak.Move
+		v     int64
+		depth int
+	}
+	done := make(chan result)
+	go func() {
+		pv, v, st := ai.Analyze(context.Background(), p)
+		done <- result{pv, v, st.Depth}
+	}()
+	last := 0
+	var res result
+	for waiting := true; waiting; {
+		select {
+		case res = <-done:
+			waiting = false
+		default:
+		}
+		pv, _, depth := ai.CurrentBest()
+		if depth < last {
+			t.Fatalf("depth went from %d to %d", last, depth)
+		}
+		if depth > 0 && len(pv) == 0 {
+			t.Fatalf("depth=%d with no pv", depth)
+		}
+		last = depth
+		time.Sleep(time.Millisecond)
+	}
+	pv, v, depth := ai.CurrentBest()
+	if depth != res.depth || v != res.v || len(pv) != len(res.pv) || !pv[0].Equal(&res.pv[0]) {
+		t.Errorf("CurrentBest()=%s/%d/%d, Analyze returned %s/%d/%d",
+			formatpv(pv), v, depth, formatpv(res.pv), res.v, res.depth)
+	}
+}
+
+func TestSeededGame(t *testing.T) {
+	cfg := MinimaxConfig{
+		Size: 5, Depth: 2, Seed: 7,
+		RandomizeWindow: 500, RandomizeScale: 10,
+	}
+	play := func() []string {
+		ai := NewMinimax(cfg)
+		p := tak.New(tak.Config{Size: 5})
+		var moves []string
+		for i := 0; i < 16; i++ {
+			if over, _ := p.GameOver(); over {
+				break
+			}
+			m := ai.GetMove(context.Background(), p)
+			var e error
+			if p, e = p.Move(&m); e != nil {
+				t.Fatalf("illegal move %s: %v", ptn.FormatMove(&m), e)
+			}
+			moves = append(moves, ptn.FormatMove(&m))
+		}
+		return moves
+	}
+	g1, g2 := play(), play()
+	if !reflect.DeepEqual(g1, g2) {
+		t.Errorf("same seed played different games:\n%v\n%v", g1, g2)
+	}
+
+	// The generator is seeded once per engine, not once per
+	// search, so repeated searches of the same position draw
+	// different moves, and a fresh engine repeats the sequence.
+	// Without a table, each search starts from the same state
+	// apart from the generator.
+	draws := func() []string {
+		cfg := cfg
+		cfg.NoTable = true
+		ai := NewMinimax(cfg)
+		p := tak.New(tak.Config{Size: 5})
+		var moves []string
+		for i := 0; i < 10; i++ {
+			m := ai.GetMove(context.Background(), p)
+			moves = append(moves, ptn.FormatMove(&m))
+		}
+		return moves
+	}
+	d1, d2 := draws(), draws()
+	if !reflect.DeepEqual(d1, d2) {
+		t.Errorf("same seed drew different moves:\n%v\n%v", d1, d2)
+	}
+	distinct := make(map[string]bool)
+	for _, m := range d1 {
+		distinct[m] = true
+	}
+	if len(distinct) == 1 {
+		t.Errorf("repeated searches all drew %s", d1[0])
+	}
+}
+
+func TestAnalyzeFrom(t *testing.T) {
+	p, e := ptn.ParseTPS(`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	cfg := MinimaxConfig{Size: 5, Depth: 5, Seed: 1}
+	cfg.MakePrecise()
+	_, want, full := NewMinimax(cfg).Analyze(context.Background(), p)
+
+	// Stand in for an analysis that was interrupted after 3
+	// plies, and resume it with a fresh engine.
+	short := cfg
+	short.Depth = 3
+	part := NewMinimax(short)
+	part.Analyze(context.Background(), p)
+	pv, v, depth := part.CurrentBest()
+	if depth != 3 {
+		t.Fatalf("interrupted at depth %d", depth)
+	}
+
+	resumed := NewMinimax(cfg)
+	_, got, st := resumed.AnalyzeFrom(context.Background(), p, pv, v, depth)
+	if got != want || st.Depth != full.Depth {
+		t.Errorf("resumed from depth %d: v=%d depth=%d, want v=%d depth=%d",
+			depth, got, st.Depth, want, full.Depth)
+	}
+	if st.Evaluated >= full.Evaluated {
+		t.Errorf("resumed search evaluated %d, uninterrupted %d",
+			st.Evaluated, full.Evaluated)
+	}
+}
+
+func TestResetHistory(t *testing.T) {
+	a, e := ptn.ParseTPS(`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: 5, Depth: 4, Seed: 1})
+	ai.Analyze(context.Background(), a)
+	if len(ai.history) == 0 || len(ai.response) == 0 {
+		t.Fatalf("no history after a search: %d/%d", len(ai.history), len(ai.response))
+	}
+	ai.ResetHistory()
+	if len(ai.history) != 0 || len(ai.response) != 0 {
+		t.Fatalf("history not cleared: %d/%d", len(ai.history), len(ai.response))
+	}
+}
+
+func TestRandomEpsilon(t *testing.T) {
+	p, e := ptn.ParseTPS(`x5/x5/x2,1,x2/x5/2,x4 1 3`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	const depth, eps = 3, 50
+	cfg := MinimaxConfig{Size: 5, Depth: depth, NoTable: true}
+	cfg.MakePrecise()
+	_, v, _ := NewMinimax(cfg).Analyze(context.Background(), p)
+
+	childCfg := cfg
+	childCfg.Depth = depth - 1
+	seen := make(map[string]bool)
+	for seed := int64(1); seed <= 10; seed++ {
+		cfg.Seed = seed
+		cfg.RandomEpsilon = eps
+		m := NewMinimax(cfg).GetMove(context.Background(), p)
+		child, e := p.Move(&m)
+		if e != nil {
+			t.Fatalf("seed=%d: illegal move %s: %v", seed, ptn.FormatMove(&m), e)
+		}
+		_, cv, _ := NewMinimax(childCfg).Analyze(context.Background(), child)
+		if -cv < v-eps {
+			t.Errorf("seed=%d: %s has value %d, best is %d", seed, ptn.FormatMove(&m), -cv, v)
+		}
+		seen[ptn.FormatMove(&m)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("always played %v", seen)
+	}
+}
+
+// cancelAfter cancels the search after it has tried `n` moves at the
+// root.
+type cancelAfter struct {
+	EvaluationFunc
+	ai   *MinimaxAI
+	root uint64
+	n    int
+}
+
+func (c *cancelAfter) OnMove(p *tak.Position, m *tak.Move) {
+	if p.Hash() != c.root {
+		return
+	}
+	if c.n--; c.n == 0 {
+		atomic.StoreInt32(c.ai.cancel, 1)
+	}
+}
+
+func (c *cancelAfter) OnUndo(p *tak.Position, m *tak.Move) {}
+
+func TestRootMoveLines(t *testing.T) {
+	p, e := ptn.ParseTPS(`x5/x5/x2,1,x2/x5/2,x4 1 3`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	const depth = 3
+	cfg := MinimaxConfig{Size: 5, Depth: depth, NoTable: true}
+	cfg.MakePrecise()
+	_, v, _ := NewMinimax(cfg).Analyze(context.Background(), p)
+
+	lines, st := NewMinimax(cfg).RootMoveLines(context.Background(), p)
+	if st.Canceled || st.Depth != depth {
+		t.Fatalf("canceled=%v depth=%d", st.Canceled, st.Depth)
+	}
+	legal := 0
+	for _, m := range p.AllMoves(nil) {
+		if _, e := p.Move(&m); e == nil {
+			legal++
+		}
+	}
+	if len(lines) != legal {
+		t.Fatalf("got %d lines, want %d", len(lines), legal)
+	}
+	if lines[0].Value != v {
+		t.Errorf("best line has value %d, Analyze says %d", lines[0].Value, v)
+	}
+	childCfg := cfg
+	childCfg.Depth = depth - 1
+	for i, l := range lines {
+		if i > 0 && l.Value > lines[i-1].Value {
+			t.Errorf("line %d: value %d > %d", i, l.Value, lines[i-1].Value)
+		}
+		if len(l.PV) == 0 || !l.PV[0].Equal(&l.Move) {
+			t.Fatalf("line %s: bad pv %s", ptn.FormatMove(&l.Move), formatpv(l.PV))
+		}
+		child, e := p.Move(&l.Move)
+		if e != nil {
+			t.Fatalf("illegal move %s: %v", ptn.FormatMove(&l.Move), e)
+		}
+		_, cv, _ := NewMinimax(childCfg).Analyze(context.Background(), child)
+		if -cv != l.Value {
+			t.Errorf("line %s: value %d, fresh search %d", ptn.FormatMove(&l.Move), l.Value, -cv)
+		}
+	}
+
+	// The analysis at depth 1 tries every root move once; cancel
+	// partway through the lines that follow.
+	cfg.Depth = 1
+	ca := &cancelAfter{EvaluationFunc: MakeEvaluator(5, nil), root: p.Hash(), n: legal + 5}
+	cfg.Evaluator = ca
+	ca.ai = NewMinimax(cfg)
+	lines, st = ca.ai.RootMoveLines(context.Background(), p)
+	if !st.Canceled {
+		t.Errorf("not canceled")
+	}
+	if len(lines) != 4 {
+		t.Errorf("got %d lines after canceling, want 4", len(lines))
+	}
+}
+
+func TestVerifyTable(t *testing.T) {
+	p, e := ptn.ParseTPS(`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	other, e := ptn.ParseTPS(`x5/x5/x2,1,x2/x5/2,x4 1 3`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	cfg := MinimaxConfig{Size: 5, Depth: 3, Seed: 1}
+	_, want, _ := NewMinimax(cfg).Analyze(context.Background(), p)
+
+	// Force a collision: store a deep, exact entry for `other`
+	// under the hash of `p`, with a move that is illegal in `p`.
+	cfg.VerifyTable = true
+	ai := NewMinimax(cfg)
+	var cancel int32
+	ai.cancel = &cancel
+	bad := tak.Move{X: 2, Y: 2, Type: tak.PlaceFlat}
+	if _, e := p.Move(&bad); e == nil {
+		t.Fatal("forged move is legal")
+	}
+	te := ai.ttPut(p.Hash())
+	te.hash = p.Hash()
+	ai.ttVerify(other)
+	te.depth = cfg.Depth + 1
+	te.bound = exactBound
+	te.m = bad.EncodeMove()
+	te.value = MaxEval
+
+	pv, got, st := ai.Analyze(context.Background(), p)
+	if st.TTCollisions == 0 {
+		t.Error("collision not detected")
+	}
+	if _, e := p.Move(&pv[0]); e != nil {
+		t.Errorf("illegal move %s: %v", ptn.FormatMove(&pv[0]), e)
+	}
+	if got != want || st.Depth != cfg.Depth {
+		t.Errorf("v=%d depth=%d, want v=%d depth=%d", got, st.Depth, want, cfg.Depth)
+	}
+}
+
+func TestTableEntrySize(t *testing.T) {
+	// The table holds tableSize entries; keep them to half a
+	// cache line, with the move packed into a word.
+	if sz := unsafe.Sizeof(tableEntry{}); sz > 32 {
+		t.Errorf("tableEntry is %d bytes", sz)
+	}
+}
+
+func TestReset(t *testing.T) {
+	a, e := ptn.ParseTPS(`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	b, e := ptn.ParseTPS(`x3,2,x/x4,12/1,1,x,1,21C/x,1,x,12111112C,2/2,x,22121,x,2 2 20`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	cfg := MinimaxConfig{Size: 5, Depth: 4, Seed: 1, VerifyTable: true}
+	wantPV, want, wantSt := NewMinimax(cfg).Analyze(context.Background(), b)
+
+	ai := NewMinimax(cfg)
+	ai.Analyze(context.Background(), a)
+	ai.Analyze(context.Background(), b)
+	ai.Reset()
+	pv, got, st := ai.Analyze(context.Background(), b)
+	if got != want || !reflect.DeepEqual(pv, wantPV) {
+		t.Errorf("after Reset: pv=%s v=%d, fresh pv=%s v=%d",
+			formatpv(pv), got, formatpv(wantPV), want)
+	}
+	st.Elapsed, wantSt.Elapsed = 0, 0
+	if st != wantSt {
+		t.Errorf("after Reset: stats %+v, fresh %+v", st, wantSt)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	p, e := ptn.ParseTPS(`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	var std bytes.Buffer
+	log.SetOutput(&std)
+	defer log.SetOutput(os.Stderr)
+
+	var buf bytes.Buffer
+	ai := NewMinimax(MinimaxConfig{
+		Size: 5, Depth: 3, Seed: 1, Debug: 1,
+		Logger: log.New(&buf, "", 0),
+	})
+	ai.Analyze(context.Background(), p)
+	if !strings.Contains(buf.String(), "start search") ||
+		!strings.Contains(buf.String(), "[minimax] deepen: depth=3") {
+		t.Errorf("debug output: %q", buf.String())
+	}
+	if std.Len() != 0 {
+		t.Errorf("wrote to the standard logger: %q", std.String())
+	}
+}
+
+func TestSearchInfo(t *testing.T) {
+	p, e := ptn.ParseTPS(`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	var infos []SearchInfo
+	ai := NewMinimax(MinimaxConfig{
+		Size: 5, Depth: 4, Seed: 1,
+		Info: func(i SearchInfo) { infos = append(infos, i) },
+	})
+	pv, v, st := ai.Analyze(context.Background(), p)
+	if len(infos) != st.Depth {
+		t.Fatalf("got %d infos for a depth-%d search", len(infos), st.Depth)
+	}
+	for i, info := range infos {
+		if info.Depth != i+1 {
+			t.Errorf("info %d: depth=%d", i, info.Depth)
+		}
+		if info.SelDepth < info.Depth {
+			t.Errorf("info %d: seldepth=%d < depth=%d", i, info.SelDepth, info.Depth)
+		}
+		if i > 0 && (info.Nodes <= infos[i-1].Nodes || info.Time < infos[i-1].Time) {
+			t.Errorf("info %d: nodes=%d time=%s, after nodes=%d time=%s", i,
+				info.Nodes, info.Time, infos[i-1].Nodes, infos[i-1].Time)
+		}
+		if info.Bound != BoundExact {
+			t.Errorf("info %d: bound=%d", i, info.Bound)
+		}
+		if len(info.PV) == 0 {
+			t.Fatalf("info %d: empty pv", i)
+		}
+		if _, e := p.Move(&info.PV[0]); e != nil {
+			t.Errorf("info %d: illegal move %s", i, ptn.FormatMove(&info.PV[0]))
+		}
+	}
+	last := infos[len(infos)-1]
+	if last.Score != v || !reflect.DeepEqual(last.PV, pv) {
+		t.Errorf("last info: score=%d pv=%s, Analyze returned %d %s",
+			last.Score, formatpv(last.PV), v, formatpv(pv))
+	}
+	if last.Nodes != st.Visited+st.Evaluated {
+		t.Errorf("last info: nodes=%d, stats say %d", last.Nodes, st.Visited+st.Evaluated)
+	}
+}
@@ -3,9 +3,121 @@ package ai
 import (
 	"sort"
 
+	"github.com/nelhage/taktician/bitboard"
 	"github.com/nelhage/taktician/tak"
 )
 
+// A MoveScorer estimates the static value of a move without playing
+// it, for use in move ordering. An Evaluator may implement MoveScorer
+// to replace the default ScoreMove.
+type MoveScorer interface {
+	ScoreMove(p *tak.Position, m *tak.Move) int
+}
+
+type moveScorerFunc func(p *tak.Position, m *tak.Move) int
+
+func (f moveScorerFunc) ScoreMove(p *tak.Position, m *tak.Move) int {
+	return f(p, m)
+}
+
+// ScoreMove is a cheap static estimate of the value of playing `m` in
+// `p`: it favors central placements next to our own road pieces, and
+// slides that gain control of squares.
+func ScoreMove(p *tak.Position, m *tak.Move) int {
+	sz := p.Size()
+	i := uint(m.X + m.Y*sz)
+	var ours, theirs uint64
+	if p.ToMove() == tak.White {
+		ours, theirs = p.White, p.Black
+	} else {
+		ours, theirs = p.Black, p.White
+	}
+
+	if !m.IsSlide() {
+		dx, dy := 2*m.X-(sz-1), 2*m.Y-(sz-1)
+		if dx < 0 {
+			dx = -dx
+		}
+		if dy < 0 {
+			dy = -dy
+		}
+		score := sz - (dx+dy)/2
+		bit := uint64(1) << i
+		var neighbors uint64
+		if m.X > 0 {
+			neighbors |= bit >> 1
+		}
+		if m.X < sz-1 {
+			neighbors |= bit << 1
+		}
+		if m.Y > 0 {
+			neighbors |= bit >> uint(sz)
+		}
+		if m.Y < sz-1 {
+			neighbors |= bit << uint(sz)
+		}
+		switch m.Type {
+		case tak.PlaceFlat:
+			score += 2 * bitboard.Popcount(neighbors&ours&^p.Standing)
+		case tak.PlaceStanding:
+			score += 2*bitboard.Popcount(neighbors&theirs) - sz
+		case tak.PlaceCapstone:
+			score += 2 * bitboard.Popcount(neighbors&(ours|theirs))
+		}
+		return score
+	}
+
+	// Bit j of stack is set if the j'th piece from the top of the
+	// moving stack is black; the pieces are dropped from the
+	// bottom of the carried stack.
+	stack := p.Stacks[i] << 1
+	if p.Black&(1<<i) != 0 {
+		stack |= 1
+	}
+	black := p.ToMove() == tak.Black
+	gain := func(sq uint64, newBlack bool) int {
+		var v int
+		switch {
+		case ours&sq != 0:
+			v = 1
+		case theirs&sq != 0:
+			v = -1
+		}
+		if newBlack == black {
+			return 1 - v
+		}
+		return -1 - v
+	}
+
+	carry := 0
+	for _, s := range m.Slides {
+		carry += int(s)
+	}
+	score := 0
+	if int(p.Height[i]) > carry {
+		score += gain(1<<i, stack&(1<<uint(carry)) != 0)
+	} else {
+		score--
+	}
+	x, y := m.X, m.Y
+	rem := carry
+	for _, s := range m.Slides {
+		switch m.Type {
+		case tak.SlideLeft:
+			x--
+		case tak.SlideRight:
+			x++
+		case tak.SlideUp:
+			y++
+		case tak.SlideDown:
+			y--
+		}
+		rem -= int(s)
+		score += gain(1<<uint(x+y*sz), stack&(1<<uint(rem)) != 0)
+	}
+	return score
+}
+
 type moveGenerator struct {
 	ai    *MinimaxAI
 	ply   int
@@ -13,7 +125,14 @@ type moveGenerator struct {
 	p     *tak.Position
 
 	te *tableEntry
+	// tm is the TT move, decoded when the generator starts.
+	tm tak.Move
 	pv []tak.Move
+	// cm is the counter-move, if cmOK is set; like the TT and
+	// PV moves, it is tried early and skipped when it comes up
+	// again.
+	cm   tak.Move
+	cmOK bool
 
 	ms []tak.Move
 	i  int
@@ -34,18 +153,61 @@ func (s sortMoves) Swap(i, j int) {
 }
 
 func (mg *moveGenerator) sortMoves() {
+	f := &mg.ai.stack[mg.ply]
+	if len(f.vals) < len(mg.ms) {
+		f.vals = make([]int, len(mg.ms))
+	}
 	s := sortMoves{
 		mg.ms,
-		mg.ai.stack[mg.ply].vals[:len(mg.ms)],
+		f.vals[:len(mg.ms)],
 	}
-	for i, m := range s.ms {
-		s.vs[i] = mg.ai.history[m.Hash()]
+	for i := range s.ms {
+		s.vs[i] = mg.ai.history[historyKey(mg.p.ToMove(), &s.ms[i])]
+		if mg.ai.scorer != nil {
+			s.vs[i] += mg.ai.scorer.ScoreMove(mg.p, &s.ms[i])
+		}
 	}
 	sort.Sort(s)
 }
 
 func (mg *moveGenerator) Reset() {
 	mg.i = 0
+	mg.cmOK = false
+}
+
+// hint returns the i'th of the TT and PV moves, in the order they
+// should be tried. At the root, the principal variation from the
+// previous iteration comes first, so that iterative deepening
+// reliably follows the prior best line; elsewhere the TT move does.
+func (mg *moveGenerator) hint(i int) (tak.Move, bool) {
+	var hints [2]*tak.Move
+	if mg.te != nil {
+		hints[0] = &mg.tm
+	}
+	if len(mg.pv) > 0 {
+		hints[1] = &mg.pv[0]
+	}
+	if mg.ply == 0 {
+		hints[0], hints[1] = hints[1], hints[0]
+	}
+	if hints[i] == nil {
+		return tak.Move{}, false
+	}
+	if i == 1 && hints[0] != nil && hints[0].Equal(hints[1]) {
+		return tak.Move{}, false
+	}
+	return *hints[i], true
+}
+
+// isHint reports whether `m` is one of the TT and PV moves that
+// Next tries first.
+func (mg *moveGenerator) isHint(m *tak.Move) bool {
+	for i := 0; i < 2; i++ {
+		if h, ok := mg.hint(i); ok && h.Equal(m) {
+			return true
+		}
+	}
+	return false
 }
 
 func (mg *moveGenerator) Next() (m tak.Move, p *tak.Position) {
@@ -55,26 +217,27 @@ func (mg *moveGenerator) Next() (m tak.Move, p *tak.Position) {
 		case 0:
 			mg.i++
 			if mg.te != nil {
-				m = mg.te.m
+				mg.tm = mg.te.move()
+			}
+			if hint, ok := mg.hint(0); ok {
+				m = hint
 				break
 			}
 			fallthrough
 		case 1:
 			mg.i++
-			if len(mg.pv) > 0 {
-				m = mg.pv[0]
-				if mg.te != nil && m.Equal(&mg.te.m) {
-					continue
-				}
+			if hint, ok := mg.hint(1); ok {
+				m = hint
 				break
 			}
 			fallthrough
 		case 2:
 			mg.i++
-			if mg.ply == 0 {
+			if mg.ply == 0 || mg.ai.cfg.NoCounterMove {
 				continue
 			}
-			if r, ok := mg.ai.response[mg.ai.stack[mg.ply-1].m.Hash()]; ok {
+			if r, ok := mg.ai.response[historyKey(mg.p.ToMove().Flip(), &mg.ai.stack[mg.ply-1].m)]; ok && !mg.isHint(&r) {
+				mg.cm, mg.cmOK = r, true
 				m = r
 				break
 			}
@@ -83,8 +246,16 @@ func (mg *moveGenerator) Next() (m tak.Move, p *tak.Position) {
 			mg.i++
 			if mg.ms == nil {
 				mg.ms = mg.p.AllMoves(mg.ai.stack[mg.ply].moves[:0])
+				// Keep the buffer if AllMoves had to grow it.
+				mg.ai.stack[mg.ply].moves = mg.ms[:0]
 			}
-			if mg.ply == 0 {
+			if order := mg.ai.cfg.MoveOrder; order != nil {
+				var te *tak.Move
+				if mg.te != nil {
+					te = &mg.tm
+				}
+				order(mg.p, mg.ms, te)
+			} else if mg.ply == 0 {
 				for i := len(mg.ms) - 1; i > 0; i-- {
 					j := mg.ai.rand.Int31n(int32(i))
 					mg.ms[j], mg.ms[i] = mg.ms[i], mg.ms[j]
@@ -94,18 +265,23 @@ func (mg *moveGenerator) Next() (m tak.Move, p *tak.Position) {
 			}
 			fallthrough
 		default:
-			j := mg.i - 3
+			// Cases 0-3 each advance mg.i once, so the
+			// first generated move is at mg.i == 4.
+			j := mg.i - 4
 			mg.i++
 			if j >= len(mg.ms) {
 				return tak.Move{}, nil
 			}
 			m = mg.ms[j]
-			if mg.te != nil && mg.te.m.Equal(&m) {
+			if mg.te != nil && mg.tm.Equal(&m) {
 				continue
 			}
 			if len(mg.pv) != 0 && mg.pv[0].Equal(&m) {
 				continue
 			}
+			if mg.cmOK && mg.cm.Equal(&m) {
+				continue
+			}
 		}
 		child, e := mg.p.MovePreallocated(&m, mg.ai.stack[mg.ply].p)
 		if e == nil {
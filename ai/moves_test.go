@@ -0,0 +1,296 @@
+package ai
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+func TestScoreMove(t *testing.T) {
+	cases := []struct {
+		tps           string
+		better, worse string
+	}{
+		{`x5/x5/x5/x5/x5 1 2`, "c3", "a1"},
+		{`x5/x5/x5/x5/x5 1 2`, "c3", "Sc3"},
+		{`x5/x5/x2,1,x2/x5/x5 1 2`, "c4", "e5"},
+		// capturing 2's stone is better than sliding onto an
+		// empty square
+		{`x5/x5/x,2,1,x2/x5/x5 1 3`, "c3<", "c3>"},
+		// lifting the top off c2 uncovers our own flat; lifting
+		// it off c3 gives up the square
+		{`x5/x5/x2,21,x2/x2,11,x2/x5 1 5`, "c2>", "c3>"},
+	}
+	for i, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Fatalf("%d: tps: %v", i, e)
+		}
+		better, e := ptn.ParseMove(tc.better)
+		if e != nil {
+			t.Fatalf("%d: %v", i, e)
+		}
+		worse, e := ptn.ParseMove(tc.worse)
+		if e != nil {
+			t.Fatalf("%d: %v", i, e)
+		}
+		if b, w := ScoreMove(p, &better), ScoreMove(p, &worse); b <= w {
+			t.Errorf("%d: ScoreMove(%s)=%d <= ScoreMove(%s)=%d",
+				i, tc.better, b, tc.worse, w)
+		}
+	}
+}
+
+var orderingPositions = []string{
+	`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`,
+	`x3,2,x/x4,12/1,1,x,1,21C/x,1,x,12111112C,2/2,x,22121,x,2 2 20`,
+	`2,x4/x2,2,x2/x,2,2,x2/x2,12,2,1/1,1,21,2,1 1 9`,
+	`x,1,x3/x,1,2,x2/x,1,2,1,x/x,2,1C,2C,x/2,x4 1 8`,
+}
+
+func benchmarkOrdering(b *testing.B, cfg MinimaxConfig) {
+	var nodes uint64
+	for i := 0; i < b.N; i++ {
+		for _, tps := range orderingPositions {
+			p, e := ptn.ParseTPS(tps)
+			if e != nil {
+				b.Fatal("tps:", e)
+			}
+			cfg.Size = p.Size()
+			_, _, st := NewMinimax(cfg).Analyze(context.Background(), p)
+			nodes += st.Visited + st.Evaluated
+		}
+	}
+	b.ReportMetric(float64(nodes)/float64(b.N), "nodes/op")
+}
+
+func BenchmarkOrderingHistory(b *testing.B) {
+	benchmarkOrdering(b, MinimaxConfig{Depth: 5, Seed: 1})
+}
+
+func BenchmarkOrderingScoreMoves(b *testing.B) {
+	benchmarkOrdering(b, MinimaxConfig{Depth: 5, Seed: 1, ScoreMoves: true})
+}
+
+func TestScoreMovesSearch(t *testing.T) {
+	for i, tps := range orderingPositions {
+		p, e := ptn.ParseTPS(tps)
+		if e != nil {
+			t.Fatalf("%d: tps: %v", i, e)
+		}
+		ai := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3, ScoreMoves: true})
+		pv, _, _ := ai.Analyze(context.Background(), p)
+		if _, e := p.Move(&pv[0]); e != nil {
+			t.Errorf("%d: illegal move %s: %v", i, ptn.FormatMove(&pv[0]), e)
+		}
+	}
+}
+
+func BenchmarkOrderingNoCounterMove(b *testing.B) {
+	benchmarkOrdering(b, MinimaxConfig{Depth: 5, Seed: 1, NoCounterMove: true})
+}
+
+func orderingNodes(t *testing.T, cfg MinimaxConfig) uint64 {
+	var nodes uint64
+	for i, tps := range orderingPositions {
+		p, e := ptn.ParseTPS(tps)
+		if e != nil {
+			t.Fatalf("%d: tps: %v", i, e)
+		}
+		cfg.Size = p.Size()
+		_, _, st := NewMinimax(cfg).Analyze(context.Background(), p)
+		nodes += st.Visited + st.Evaluated
+	}
+	return nodes
+}
+
+func TestCounterMoveNodes(t *testing.T) {
+	with := orderingNodes(t, MinimaxConfig{Depth: 5, Seed: 1})
+	without := orderingNodes(t, MinimaxConfig{Depth: 5, Seed: 1, NoCounterMove: true})
+	t.Logf("nodes: counter-move=%d no-counter-move=%d", with, without)
+	if with > without {
+		t.Errorf("counter-move ordering searched more nodes: %d > %d", with, without)
+	}
+}
+
+// rootRecorder records the moves the search tries at the root.
+type rootRecorder struct {
+	EvaluationFunc
+	root  uint64
+	moves []tak.Move
+}
+
+func (r *rootRecorder) OnMove(p *tak.Position, m *tak.Move) {
+	if p.Hash() == r.root {
+		r.moves = append(r.moves, *m)
+	}
+}
+
+func (r *rootRecorder) OnUndo(p *tak.Position, m *tak.Move) {}
+
+func TestPVFirst(t *testing.T) {
+	p, e := ptn.ParseTPS(orderingPositions[1])
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	rec := &rootRecorder{EvaluationFunc: MakeEvaluator(5, nil), root: p.Hash()}
+	ai := NewMinimax(MinimaxConfig{Size: 5, Depth: 3, Seed: 1, Evaluator: rec})
+	pv, _, _ := ai.Analyze(context.Background(), p)
+
+	// Plant a conflicting TT move at the root that is too shallow
+	// to cut off the search, and search one ply deeper.
+	var tt tak.Move
+	for _, m := range p.AllMoves(nil) {
+		if !m.Equal(&pv[0]) {
+			tt = m
+			break
+		}
+	}
+	te := ai.ttGet(p)
+	if te == nil {
+		t.Fatal("no TT entry for the root")
+	}
+	te.m = tt.EncodeMove()
+	te.depth = 0
+	te.bound = lowerBound
+
+	rec.moves = nil
+	var cancel int32
+	ai.cancel = &cancel
+	ai.pvSearch(p, 0, 4, pv, MinEval-1, MaxEval+1)
+	if len(rec.moves) < 2 {
+		t.Fatalf("searched %d root moves", len(rec.moves))
+	}
+	if !rec.moves[0].Equal(&pv[0]) {
+		t.Errorf("first root move %s, want PV move %s",
+			ptn.FormatMove(&rec.moves[0]), ptn.FormatMove(&pv[0]))
+	}
+	if !rec.moves[1].Equal(&tt) {
+		t.Errorf("second root move %s, want TT move %s",
+			ptn.FormatMove(&rec.moves[1]), ptn.FormatMove(&tt))
+	}
+}
+
+func TestWidePosition(t *testing.T) {
+	p, e := ptn.ParseTPS(`x8/x8/x2,12121212,x2,21212121,x2/x8/x8/x2,21212121,x2,12121212,x2/x8/x8 1 20`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	n := p.CountMoves()
+	if n <= maxMoves {
+		t.Fatalf("only %d moves", n)
+	}
+	ai := NewMinimax(MinimaxConfig{Size: 8, Depth: 3, Seed: 1})
+	mg := &ai.stack[1].mg
+	*mg = moveGenerator{ai: ai, ply: 1, depth: 2, p: p}
+	got := 0
+	for _, child := mg.Next(); child != nil; _, child = mg.Next() {
+		got++
+	}
+	if got != n {
+		t.Errorf("generated %d moves, want %d", got, n)
+	}
+
+	m := ai.GetMove(context.Background(), p)
+	if _, e := p.Move(&m); e != nil {
+		t.Fatalf("illegal move %s: %v", ptn.FormatMove(&m), e)
+	}
+}
+
+func TestMoveGeneratorAllMoves(t *testing.T) {
+	for i, tps := range orderingPositions {
+		p, e := ptn.ParseTPS(tps)
+		if e != nil {
+			t.Fatalf("%d: tps: %v", i, e)
+		}
+		all := p.AllMoves(nil)
+		ai := NewMinimax(MinimaxConfig{Size: p.Size(), Depth: 3, Seed: 1})
+		// The counter-move to a pass is a plain move, the PV
+		// move, and then no move at all.
+		prev := tak.Move{Type: tak.Pass}
+		ai.stack[0].m = prev
+		key := historyKey(p.ToMove().Flip(), &prev)
+		for j, response := range []*tak.Move{&all[len(all)-1], &all[0], nil} {
+			if response != nil {
+				ai.response[key] = *response
+			} else {
+				delete(ai.response, key)
+			}
+			for _, ply := range []int{0, 1} {
+				mg := &ai.stack[ply].mg
+				*mg = moveGenerator{ai: ai, ply: ply, depth: 2, p: p, pv: all[:1]}
+				seen := make(map[string]int)
+				for m, child := mg.Next(); child != nil; m, child = mg.Next() {
+					seen[ptn.FormatMove(&m)]++
+				}
+				if len(seen) != len(all) {
+					t.Errorf("%d/%d: ply=%d: generated %d distinct moves, want %d",
+						i, j, ply, len(seen), len(all))
+				}
+				for m, n := range seen {
+					if n != 1 {
+						t.Errorf("%d/%d: ply=%d: generated %s %d times",
+							i, j, ply, m, n)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMoveOrder(t *testing.T) {
+	p, e := ptn.ParseTPS(orderingPositions[1])
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+	calls := 0
+	reverse := func(p *tak.Position, ms []tak.Move, te *tak.Move) {
+		calls++
+		for i, j := 0, len(ms)-1; i < j; i, j = i+1, j-1 {
+			ms[i], ms[j] = ms[j], ms[i]
+		}
+	}
+
+	rec := &rootRecorder{EvaluationFunc: MakeEvaluator(5, nil), root: p.Hash()}
+	ai := NewMinimax(MinimaxConfig{
+		Size: 5, Depth: 1, Seed: 1, NoTable: true,
+		Evaluator: rec, MoveOrder: reverse,
+	})
+	ai.Analyze(context.Background(), p)
+	if calls == 0 {
+		t.Fatal("MoveOrder was not called")
+	}
+	var want []tak.Move
+	all := p.AllMoves(nil)
+	for i := len(all) - 1; i >= 0; i-- {
+		if _, e := p.Move(&all[i]); e == nil {
+			want = append(want, all[i])
+		}
+	}
+	if len(rec.moves) == 0 || len(rec.moves) > len(want) {
+		t.Fatalf("searched %d root moves, of %d", len(rec.moves), len(want))
+	}
+	for i := range rec.moves {
+		if !rec.moves[i].Equal(&want[i]) {
+			t.Fatalf("root move %d: got %s, want %s", i,
+				ptn.FormatMove(&rec.moves[i]), ptn.FormatMove(&want[i]))
+		}
+	}
+
+	for i, tps := range orderingPositions {
+		p, e := ptn.ParseTPS(tps)
+		if e != nil {
+			t.Fatalf("%d: tps: %v", i, e)
+		}
+		cfg := MinimaxConfig{Size: p.Size(), Depth: 3, Seed: 1, NoTable: true}
+		_, want, _ := NewMinimax(cfg).Analyze(context.Background(), p)
+		cfg.MoveOrder = reverse
+		_, got, _ := NewMinimax(cfg).Analyze(context.Background(), p)
+		if got != want {
+			t.Errorf("%d: value with MoveOrder=%d, default=%d", i, got, want)
+		}
+	}
+}
@@ -18,6 +18,10 @@ func (r *RandomAI) GetMove(ctx context.Context, p *tak.Position) tak.Move {
 	return moves[i]
 }
 
+func (r *RandomAI) Name() string {
+	return "random"
+}
+
 func NewRandom(seed int64) TakPlayer {
 	return &RandomAI{
 		r: rand.New(rand.NewSource(seed)),
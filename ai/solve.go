@@ -0,0 +1,126 @@
+package ai
+
+import (
+	"github.com/nelhage/taktician/tak"
+	"golang.org/x/net/context"
+)
+
+type Outcome int
+
+const (
+	// Unknown means the search did not find a forced result
+	// within its depth limit.
+	Unknown Outcome = iota
+	// Win and Loss are from the perspective of the player to
+	// move.
+	Win
+	Loss
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Win:
+		return "win"
+	case Loss:
+		return "loss"
+	default:
+		return "unknown"
+	}
+}
+
+// SolveResult is the result of Solve.
+type SolveResult struct {
+	Outcome Outcome
+	// Plies is the number of plies, including the first move,
+	// until the game ends, if Outcome is Win or Loss. A road
+	// completed by the player to move is a win in 1.
+	Plies int
+	// Value is the value of the position, from the
+	// perspective of the player to move.
+	Value int64
+	// PV is the best line found by the search.
+	PV []tak.Move
+	// Depth is the depth of the last completed search.
+	Depth int
+}
+
+// Solve searches `p` to a depth of at most `maxDepth` using a
+// MakePrecise search, stopping early if it finds a forced win for
+// either side, and reports the game-theoretic result. Because the
+// search is precise, a Win or Loss is proven; Unknown only means
+// that no forced result exists within `maxDepth` plies.
+func Solve(p *tak.Position, maxDepth int) SolveResult {
+	cfg := solveConfig(p, maxDepth)
+	ai := NewMinimax(cfg)
+	pv, v, st := ai.Analyze(context.Background(), p)
+	return solveResult(p, pv, v, st.Depth)
+}
+
+func solveConfig(p *tak.Position, maxDepth int) MinimaxConfig {
+	cfg := MinimaxConfig{Size: p.Size(), Depth: maxDepth}
+	cfg.MakePrecise()
+	return cfg
+}
+
+func solveResult(p *tak.Position, pv []tak.Move, v int64, depth int) SolveResult {
+	res := SolveResult{Value: v, PV: pv, Depth: depth}
+	switch {
+	case v > WinThreshold:
+		res.Outcome = Win
+		res.Plies = matePly(MaxEval-v) - p.MoveNumber()
+	case v < -WinThreshold:
+		res.Outcome = Loss
+		res.Plies = matePly(v-MinEval) - p.MoveNumber()
+	}
+	return res
+}
+
+// matePly recovers the move number of the final position from the
+// distance `d` between a terminal evaluation and MaxEval or MinEval,
+// undoing evaluateTerminal's reserve-pieces bonus.
+func matePly(d int64) int {
+	return int((d + moveScale - 1) / moveScale)
+}
+
+// UniqueSolution solves `p` as Solve does and, if the player to move
+// has a forced win, reports the winning move and whether it is the
+// only root move that wins as quickly. Wins that differ only in the
+// number of reserve pieces left over count as equally quick. If there
+// is no forced win within `maxDepth` plies, it returns the best move
+// found and false. If the game is already over, or the search finds
+// no move at all, it returns the zero Move and false.
+func UniqueSolution(p *tak.Position, maxDepth int) (move tak.Move, unique bool) {
+	if over, _ := p.GameOver(); over {
+		return tak.Move{}, false
+	}
+	ai := NewMinimax(solveConfig(p, maxDepth))
+	pv, v, st := ai.Analyze(context.Background(), p)
+	if len(pv) == 0 {
+		return tak.Move{}, false
+	}
+	if v <= WinThreshold {
+		return pv[0], false
+	}
+	// The lowest value of a win that ends the game on the same
+	// move as `v`.
+	lo := MaxEval - moveScale*int64(matePly(MaxEval-v))
+	// Enumerate the root moves directly, so that the answer does
+	// not depend on the search's move ordering.
+	for _, m := range p.AllMoves(nil) {
+		if m.Equal(&pv[0]) {
+			continue
+		}
+		child, e := p.MovePreallocated(&m, ai.stack[0].p)
+		if e != nil {
+			continue
+		}
+		ai.stack[0].m = m
+		ai.onMove(p, &m)
+		_, cv := ai.pvSearch(child, 1, st.Depth-1, nil, -lo-1, -lo+1)
+		ai.onUndo(p, &m)
+		if -cv >= lo {
+			return pv[0], false
+		}
+	}
+	return pv[0], true
+}
@@ -0,0 +1,80 @@
+package ai
+
+import (
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+)
+
+func TestSolve(t *testing.T) {
+	cases := []struct {
+		tps     string
+		depth   int
+		outcome Outcome
+		plies   int
+	}{
+		{"x5/x5/2,2,2,2,x/x5/1,1,1,1,x 1 5", 3, Win, 1},
+		{"x5/x5/2,2,2,2,x/x5/1,1,1,1,x 2 5", 3, Win, 1},
+		{"2,2,2,2,x/x5/2,2,2,2,x/x5/1,1,x,1,x 1 5", 3, Loss, 2},
+		{"x5/x5/x5/x5/x5 1 1", 2, Unknown, 0},
+	}
+	for _, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Errorf("parse(%q): %v", tc.tps, e)
+			continue
+		}
+		res := Solve(p, tc.depth)
+		if res.Outcome != tc.outcome || res.Plies != tc.plies {
+			t.Errorf("Solve(%q)=%s in %d, want %s in %d",
+				tc.tps, res.Outcome, res.Plies, tc.outcome, tc.plies)
+		}
+		if tc.outcome != Unknown && len(res.PV) != tc.plies {
+			t.Errorf("Solve(%q): pv=%s, want %d plies",
+				tc.tps, formatpv(res.PV), tc.plies)
+		}
+	}
+}
+
+func TestUniqueSolution(t *testing.T) {
+	cases := []struct {
+		tps    string
+		move   string
+		unique bool
+	}{
+		{"x4,1C/x5/2,2,2,2,x/x5/1,1,1,1,x 1 6", "e1", true},
+		{"x4,1C/x5/2,2,2,x,x/1,1,1,1,x/1,1,1,1,x 1 7", "", false},
+		// Ce1 leaves one more stone in reserve than e1, but
+		// wins just as fast.
+		{"x5/x5/2,2,2,2,x/x5/1,1,1,1,x 1 5", "", false},
+		{"x5/x5/x5/x5/x5 1 1", "", false},
+	}
+	for _, tc := range cases {
+		p, e := ptn.ParseTPS(tc.tps)
+		if e != nil {
+			t.Errorf("parse(%q): %v", tc.tps, e)
+			continue
+		}
+		m, unique := UniqueSolution(p, 3)
+		if unique != tc.unique {
+			t.Errorf("UniqueSolution(%q): unique=%v, want %v",
+				tc.tps, unique, tc.unique)
+		}
+		if tc.move != "" && ptn.FormatMove(&m) != tc.move {
+			t.Errorf("UniqueSolution(%q)=%s, want %s",
+				tc.tps, ptn.FormatMove(&m), tc.move)
+		}
+	}
+
+	// White has already completed a road.
+	p, e := ptn.ParseTPS("x5/x5/x5/2,2,2,2,x/1,1,1,1,1 2 5")
+	if e != nil {
+		t.Fatal("parse:", e)
+	}
+	if over, _ := p.GameOver(); !over {
+		t.Fatal("game is not over")
+	}
+	if m, unique := UniqueSolution(p, 3); unique || m.Type != 0 {
+		t.Errorf("UniqueSolution(finished)=%#v, %v", m, unique)
+	}
+}
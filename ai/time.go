@@ -0,0 +1,80 @@
+package ai
+
+import "time"
+
+// A TimePolicy decides how much of a game clock to spend on each
+// move.
+type TimePolicy struct {
+	// ExpectedMoves is the number of moves we expect, for each
+	// player, in a typical game. The remaining clock is spread
+	// over the moves we expect to have left, but never fewer
+	// than MinMovesToGo.
+	ExpectedMoves int
+	MinMovesToGo  int
+
+	// IncrementFraction is the fraction of the increment to
+	// spend on top of our share of the clock.
+	IncrementFraction float64
+
+	// MaxFraction caps the fraction of the remaining clock we
+	// will spend on any single move.
+	MaxFraction float64
+
+	// Below PanicTime on the clock, we stop planning ahead and
+	// spend only 1/PanicDivisor of what remains, plus the
+	// increment.
+	PanicTime    time.Duration
+	PanicDivisor int
+
+	// Reserve is kept on the clock, if possible, as a safety
+	// margin against network lag. Floor is the least time we
+	// will spend on a move, clock permitting.
+	Reserve time.Duration
+	Floor   time.Duration
+}
+
+var DefaultTimePolicy = TimePolicy{
+	ExpectedMoves:     40,
+	MinMovesToGo:      10,
+	IncrementFraction: 0.8,
+	MaxFraction:       0.25,
+	PanicTime:         30 * time.Second,
+	PanicDivisor:      30,
+	Reserve:           5 * time.Second,
+	Floor:             100 * time.Millisecond,
+}
+
+// BudgetForMove returns how long to think about a move, given the
+// time `remaining` on our clock, the per-move `increment`, and the
+// number of moves we have already played, using DefaultTimePolicy.
+func BudgetForMove(remaining, increment time.Duration, movesPlayed int) time.Duration {
+	return DefaultTimePolicy.Budget(remaining, increment, movesPlayed)
+}
+
+func (tp *TimePolicy) Budget(remaining, increment time.Duration, movesPlayed int) time.Duration {
+	var budget time.Duration
+	if remaining < tp.PanicTime {
+		budget = remaining / time.Duration(tp.PanicDivisor)
+		budget += time.Duration(tp.IncrementFraction * float64(increment))
+	} else {
+		togo := tp.ExpectedMoves - movesPlayed
+		if togo < tp.MinMovesToGo {
+			togo = tp.MinMovesToGo
+		}
+		usable := remaining - tp.Reserve
+		budget = usable / time.Duration(togo)
+		budget += time.Duration(tp.IncrementFraction * float64(increment))
+		if max := time.Duration(tp.MaxFraction * float64(usable)); budget > max {
+			budget = max
+		}
+	}
+	if budget < tp.Floor {
+		budget = tp.Floor
+	}
+	// Whatever else happens, never risk the last half of the
+	// clock on a single move.
+	if max := remaining / 2; budget > max {
+		budget = max
+	}
+	return budget
+}
@@ -0,0 +1,49 @@
+package ai
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetForMove(t *testing.T) {
+	cases := []struct {
+		remaining, increment time.Duration
+		moves                int
+		min, max             time.Duration
+	}{
+		// early game: a fortieth-or-so of the clock
+		{10 * time.Minute, 0, 0, 10 * time.Second, 20 * time.Second},
+		{10 * time.Minute, 10 * time.Second, 0, 20 * time.Second, 30 * time.Second},
+		// late game: spread over at least MinMovesToGo
+		{5 * time.Minute, 0, 60, 25 * time.Second, 30 * time.Second},
+		// panic mode: a small slice of what remains, plus the
+		// increment
+		{20 * time.Second, 0, 20, 500 * time.Millisecond, time.Second},
+		{20 * time.Second, 5 * time.Second, 20, 4 * time.Second, 5 * time.Second},
+		// nearly flagged: never spend more than half the clock
+		{150 * time.Millisecond, 0, 20, 75 * time.Millisecond, 75 * time.Millisecond},
+		{0, 0, 20, 0, 0},
+	}
+	for i, tc := range cases {
+		got := BudgetForMove(tc.remaining, tc.increment, tc.moves)
+		if got < tc.min || got > tc.max {
+			t.Errorf("%d: BudgetForMove(%s, %s, %d)=%s, want [%s, %s]",
+				i, tc.remaining, tc.increment, tc.moves, got, tc.min, tc.max)
+		}
+	}
+}
+
+func TestBudgetNeverFlags(t *testing.T) {
+	remaining := 3 * time.Minute
+	increment := 2 * time.Second
+	for move := 0; move < 200; move++ {
+		budget := BudgetForMove(remaining, increment, move)
+		if budget > remaining {
+			t.Fatalf("move %d: budget %s exceeds clock %s", move, budget, remaining)
+		}
+		remaining += increment - budget
+	}
+	if remaining <= 0 {
+		t.Fatalf("flagged: %s", remaining)
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"golang.org/x/net/context"
 )
 
+// A TakPlayer is a Tak engine.
 type TakPlayer interface {
 	GetMove(ctx context.Context, p *tak.Position) tak.Move
+	// Name identifies the engine, for logs and match reports.
+	Name() string
 }
@@ -0,0 +1,161 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+)
+
+// LoadWeights reads JSON-encoded Weights from `path`. Unlike
+// json.Unmarshal, it requires that every field be present, and
+// rejects unknown fields, so that a stale or mistyped weights file
+// is an error rather than silently zeroing weights.
+func LoadWeights(path string) (Weights, error) {
+	var w Weights
+	buf, e := ioutil.ReadFile(path)
+	if e != nil {
+		return w, e
+	}
+	if e := ParseWeights(buf, &w); e != nil {
+		return w, fmt.Errorf("%s: %v", path, e)
+	}
+	return w, nil
+}
+
+// ParseWeights parses JSON-encoded weights into `w`, with the same
+// validation as LoadWeights.
+func ParseWeights(buf []byte, w *Weights) error {
+	return parseStrict(buf, w)
+}
+
+// SaveWeights writes `w` to `path` in the format read by
+// LoadWeights.
+func SaveWeights(path string, w *Weights) error {
+	return saveJSON(path, w)
+}
+
+// TaperedWeights holds separate weights for the opening and the
+// endgame; MakeTaperedEvaluator blends them according to the phase
+// of the game. In JSON, it is an object with an Opening and an
+// Endgame field, each a complete set of Weights.
+type TaperedWeights struct {
+	Opening Weights
+	Endgame Weights
+}
+
+// LoadTaperedWeights reads JSON-encoded TaperedWeights from `path`,
+// with the same validation as LoadWeights.
+func LoadTaperedWeights(path string) (TaperedWeights, error) {
+	var tw TaperedWeights
+	buf, e := ioutil.ReadFile(path)
+	if e != nil {
+		return tw, e
+	}
+	if e := ParseTaperedWeights(buf, &tw); e != nil {
+		return tw, fmt.Errorf("%s: %v", path, e)
+	}
+	return tw, nil
+}
+
+// ParseTaperedWeights parses JSON-encoded TaperedWeights into `tw`,
+// with the same validation as LoadWeights.
+func ParseTaperedWeights(buf []byte, tw *TaperedWeights) error {
+	return parseStrict(buf, tw)
+}
+
+// SaveTaperedWeights writes `tw` to `path` in the format read by
+// LoadTaperedWeights.
+func SaveTaperedWeights(path string, tw *TaperedWeights) error {
+	return saveJSON(path, tw)
+}
+
+func parseStrict(buf []byte, v interface{}) error {
+	var raw interface{}
+	if e := json.Unmarshal(buf, &raw); e != nil {
+		return e
+	}
+	if e := checkFields("", reflect.TypeOf(v).Elem(), raw); e != nil {
+		return e
+	}
+	return json.Unmarshal(buf, v)
+}
+
+func saveJSON(path string, v interface{}) error {
+	buf, e := json.MarshalIndent(v, "", "  ")
+	if e != nil {
+		return e
+	}
+	return ioutil.WriteFile(path, append(buf, '\n'), 0644)
+}
+
+// blendWeights returns the weights `num`/`den` of the way from `a`
+// to `b`, rounding each weight toward `a`.
+func blendWeights(a, b *Weights, num, den int) Weights {
+	var out Weights
+	blendValue(reflect.ValueOf(&out).Elem(),
+		reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem(),
+		int64(num), int64(den))
+	return out
+}
+
+func blendValue(out, a, b reflect.Value, num, den int64) {
+	switch out.Kind() {
+	case reflect.Struct:
+		for i := 0; i < out.NumField(); i++ {
+			blendValue(out.Field(i), a.Field(i), b.Field(i), num, den)
+		}
+	case reflect.Array:
+		for i := 0; i < out.Len(); i++ {
+			blendValue(out.Index(i), a.Index(i), b.Index(i), num, den)
+		}
+	case reflect.Int:
+		x, y := a.Int(), b.Int()
+		out.SetInt(x + (y-x)*num/den)
+	default:
+		panic(fmt.Sprintf("blendWeights: unexpected %s", out.Kind()))
+	}
+}
+
+func checkFields(where string, t reflect.Type, v interface{}) error {
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object", fieldName(where))
+		}
+		for k := range obj {
+			if _, ok := t.FieldByName(k); !ok {
+				return fmt.Errorf("unknown weight: %s", where+k)
+			}
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fv, ok := obj[f.Name]
+			if !ok {
+				return fmt.Errorf("missing weight: %s", where+f.Name)
+			}
+			if e := checkFields(where+f.Name+".", f.Type, fv); e != nil {
+				return e
+			}
+		}
+	case reflect.Array:
+		arr, ok := v.([]interface{})
+		if !ok || len(arr) != t.Len() {
+			return fmt.Errorf("%s: expected %d values", fieldName(where), t.Len())
+		}
+		for i, ev := range arr {
+			if e := checkFields(fmt.Sprintf("%s%d.", where, i), t.Elem(), ev); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func fieldName(where string) string {
+	if where == "" {
+		return "weights"
+	}
+	return where[:len(where)-1]
+}
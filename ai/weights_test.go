@@ -0,0 +1,99 @@
+package ai
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWeightsRoundTrip(t *testing.T) {
+	dir, e := ioutil.TempDir("", "weights")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "weights.json")
+	want := DefaultWeights[6]
+	want.Groups[7] = 1000
+	if e := SaveWeights(file, &want); e != nil {
+		t.Fatal("save:", e)
+	}
+	got, e := LoadWeights(file)
+	if e != nil {
+		t.Fatal("load:", e)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip: got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseWeightsErrors(t *testing.T) {
+	cases := []struct {
+		edit func(string) string
+		err  string
+	}{
+		{func(s string) string { return strings.Replace(s, `"Threat":300,`, "", 1) }, "missing weight: Threat"},
+		{func(s string) string { return strings.Replace(s, `"Hard":300,`, "", 1) }, "missing weight: StandingCaptives.Hard"},
+		{func(s string) string { return strings.Replace(s, `"Threat":`, `"Threats":`, 1) }, "unknown weight: Threats"},
+		{func(s string) string { return strings.Replace(s, `"Groups":[0,0,0,`, `"Groups":[`, 1) }, "Groups: expected 8 values"},
+		{func(s string) string {
+			return strings.Replace(s, `"FlatCaptives":{"Hard":200,"Soft":-200}`, `"FlatCaptives":1`, 1)
+		}, "FlatCaptives: expected an object"},
+	}
+	buf, e := json.Marshal(&defaultWeights)
+	if e != nil {
+		t.Fatal(e)
+	}
+	var w Weights
+	if e := ParseWeights(buf, &w); e != nil {
+		t.Fatalf("ParseWeights(defaults): %v", e)
+	}
+	for i, tc := range cases {
+		in := tc.edit(string(buf))
+		if in == string(buf) {
+			t.Fatalf("%d: edit did not apply", i)
+		}
+		e := ParseWeights([]byte(in), &w)
+		if e == nil || e.Error() != tc.err {
+			t.Errorf("%d: err=%v, want %q", i, e, tc.err)
+		}
+	}
+}
+
+func TestTaperedWeightsRoundTrip(t *testing.T) {
+	dir, e := ioutil.TempDir("", "weights")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "tapered.json")
+	want := TaperedWeights{Opening: DefaultWeights[5], Endgame: DefaultWeights[5]}
+	want.Endgame.TopFlat = 1000
+	if e := SaveTaperedWeights(file, &want); e != nil {
+		t.Fatal("save:", e)
+	}
+	got, e := LoadTaperedWeights(file)
+	if e != nil {
+		t.Fatal("load:", e)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip: got %+v, want %+v", got, want)
+	}
+
+	buf, e := json.Marshal(&want)
+	if e != nil {
+		t.Fatal(e)
+	}
+	in := strings.Replace(string(buf), `"TopFlat":1000,`, "", 1)
+	var tw TaperedWeights
+	e = ParseTaperedWeights([]byte(in), &tw)
+	if e == nil || !strings.Contains(e.Error(), "missing weight: Endgame.TopFlat") {
+		t.Errorf("missing endgame weight: err=%v", e)
+	}
+}
@@ -115,6 +115,68 @@ func (c *CLI) render() {
 }
 
 func RenderBoard(g *Glyphs, out io.Writer, p *tak.Position) {
+	renderBoard(g, out, p, nil)
+}
+
+// RenderDiff renders `after`, marking with a `*` each square that
+// differs from `before`, and then lists the marked squares with a
+// description of what happened to each: a stone placed on an empty
+// square, a wall flattened, a stack that changed hands, or a square
+// emptied. The two positions must be the same size.
+func RenderDiff(g *Glyphs, out io.Writer, before, after *tak.Position) {
+	var changes []string
+	changed := make(map[[2]int]bool)
+	for y := after.Size() - 1; y >= 0; y-- {
+		for x := 0; x < after.Size(); x++ {
+			was, is := before.At(x, y), after.At(x, y)
+			if sameSquare(was, is) {
+				continue
+			}
+			changed[[2]int{x, y}] = true
+			changes = append(changes, fmt.Sprintf("%c%d: %s",
+				'a'+x, y+1, describeChange(was, is)))
+		}
+	}
+	renderBoard(g, out, after, func(x, y int) bool {
+		return changed[[2]int{x, y}]
+	})
+	for _, c := range changes {
+		fmt.Fprintf(out, "  %s\n", c)
+	}
+}
+
+func sameSquare(a, b tak.Square) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func describeChange(was, is tak.Square) string {
+	switch {
+	case len(was) == 0:
+		return fmt.Sprintf("%s placed", is[0].Color())
+	case len(is) == 0:
+		return "emptied"
+	case was[0].Kind() == tak.Standing && is[0].Kind() != tak.Standing:
+		return fmt.Sprintf("wall flattened by %s", is[0].Color())
+	case was[0].Color() != is[0].Color():
+		return fmt.Sprintf("now %s", is[0].Color())
+	case len(is) > len(was):
+		return fmt.Sprintf("%d stones added", len(is)-len(was))
+	case len(is) < len(was):
+		return fmt.Sprintf("%d stones removed", len(was)-len(is))
+	default:
+		return "changed"
+	}
+}
+
+func renderBoard(g *Glyphs, out io.Writer, p *tak.Position, mark func(x, y int) bool) {
 	if g == nil {
 		g = &DefaultGlyphs
 	}
@@ -143,7 +205,11 @@ func RenderBoard(g *Glyphs, out io.Writer, p *tak.Position) {
 					panic(fmt.Sprintf("bad stone %v", stone))
 				}
 			}
-			fmt.Fprintf(w, "[%s]\t", strings.Join(stk, " "))
+			marker := ""
+			if mark != nil && mark(x, y) {
+				marker = "*"
+			}
+			fmt.Fprintf(w, "[%s]%s\t", strings.Join(stk, " "), marker)
 		}
 		fmt.Fprintf(w, "\n")
 	}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+)
+
+func TestRenderDiff(t *testing.T) {
+	p, e := ptn.ParseTPS(`x5/x5/1,x,1C,2S,x/x,2,x3/x5 1 5`)
+	if e != nil {
+		t.Fatal("tps:", e)
+	}
+
+	// The capstone flattens the wall on d3.
+	m, e := ptn.ParseMove("c3>")
+	if e != nil {
+		t.Fatal("move:", e)
+	}
+	next, e := p.Move(&m)
+	if e != nil {
+		t.Fatal("c3>:", e)
+	}
+	var buf bytes.Buffer
+	RenderDiff(nil, &buf, p, next)
+	want := strings.Join([]string{
+		"",
+		"[black to play]",
+		"5.\t[]\t[]\t[]\t[]\t[]\t",
+		"4.\t[]\t[]\t[]\t[]\t[]\t",
+		"3.\t[W]\t[]\t[]*\t[WC B]*\t[]\t",
+		"2.\t[]\t[B]\t[]\t[]\t[]\t",
+		"1.\t[]\t[]\t[]\t[]\t[]\t",
+		"\ta.\tb.\tc.\td.\te.\t",
+		"stones: W:20 B:19",
+		"  c3: emptied",
+		"  d3: wall flattened by white",
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("RenderDiff:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	RenderDiff(nil, &buf, p, p)
+	if strings.Contains(buf.String(), "*") {
+		t.Errorf("identical positions marked changed:\n%s", buf.String())
+	}
+
+	m, _ = ptn.ParseMove("a1")
+	next, e = p.Move(&m)
+	if e != nil {
+		t.Fatal("a1:", e)
+	}
+	buf.Reset()
+	RenderDiff(nil, &buf, p, next)
+	if !strings.HasSuffix(buf.String(), "\n  a1: white placed\n") {
+		t.Errorf("placement:\n%s", buf.String())
+	}
+}
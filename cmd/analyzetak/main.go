@@ -21,15 +21,24 @@ import (
 var (
 	all     = flag.Bool("all", false, "show all possible moves")
 	tps     = flag.Bool("tps", false, "render position in tps")
+	pvTPS   = flag.Bool("pv-tps", false, "render the position after each pv move in tps")
+	pvDiff  = flag.Bool("pv-diff", false, "render the board after each move of the principal pv, marking what it changed")
+	pvEval  = flag.Bool("pv-eval", false, "show the static evaluation after each move of the principal pv")
+	verify  = flag.Bool("verify-reply", false, "search the position after the pv's first move afresh, and compare the reply to the pv's")
 	quiet   = flag.Bool("quiet", false, "don't print board diagrams")
 	explain = flag.Bool("explain", false, "explain scoring")
 	eval    = flag.Bool("evaluate", false, "only show static evaluation")
+	abs     = flag.Bool("absolute", false, "report every value from white's perspective, rather than the player to move's")
 
-	move    = flag.Int("move", 0, "PTN move number to analyze")
-	final   = flag.Bool("final", false, "analyze final position only")
-	black   = flag.Bool("black", false, "only analyze black's move")
-	white   = flag.Bool("white", false, "only analyze white's move")
-	variant = flag.String("variant", "", "apply the listed moves after the given position")
+	move     = flag.Int("move", 0, "PTN move number to analyze")
+	final    = flag.Bool("final", false, "analyze final position only")
+	from     = flag.Int("from", 0, "when analyzing a whole game, start at this PTN move number")
+	to       = flag.Int("to", 0, "when analyzing a whole game, stop after this PTN move number")
+	reuse    = flag.Bool("reuse", true, "when analyzing a whole game, keep one engine, and its transposition table, for every position")
+	black    = flag.Bool("black", false, "only analyze black's move")
+	white    = flag.Bool("white", false, "only analyze white's move")
+	variant  = flag.String("variant", "", "apply the listed moves after the given position")
+	position = flag.String("position", "", "analyze this TPS position instead of reading a PTN file")
 
 	debug     = flag.Int("debug", 1, "debug level")
 	depth     = flag.Int("depth", 0, "minimax depth")
@@ -42,17 +51,40 @@ var (
 	extendForces = flag.Bool("extend-forces", true, "extend forced moves")
 	reduceSlides = flag.Bool("reduce-slides", true, "reduce trivial slides")
 	multiCut     = flag.Bool("multi-cut", true, "use multi-cut pruning")
+	mcWidth      = flag.Int("multi-cut-width", 0, "moves multi-cut searches at each node (0 for the default)")
+	mcThreshold  = flag.Int("multi-cut-threshold", 0, "fail-highs multi-cut needs to prune (0 for the default)")
+	mcDepth      = flag.Int("multi-cut-depth", 0, "minimum remaining depth for multi-cut (0 for the default)")
+	counterMove  = flag.Bool("counter-move", true, "try the counter-move to the previous move early")
+	scoreMoves   = flag.Bool("score-moves", false, "order moves by a static estimate of their value")
+	bestDefense  = flag.Bool("best-defense", false, "in lost positions, prefer moves that lose by fewer flats")
 
 	precise = flag.Bool("precise", false, "Limit to optimizations that provably preserve the game-theoretic value")
 
 	cpuProfile = flag.String("cpuprofile", "", "write CPU profile")
 
-	weights = flag.String("weights", "", "JSON-encoded evaluation weights")
+	weights     = flag.String("weights", "", "JSON-encoded evaluation weights")
+	weightsFile = flag.String("weights-file", "", "file of JSON-encoded evaluation weights")
+	taperedFile = flag.String("tapered-weights-file", "", "file of JSON-encoded opening and endgame weights, blended by game phase")
 )
 
 func main() {
 	flag.Parse()
 
+	if *position != "" {
+		p, e := ptn.ParseTPS(*position)
+		if e != nil {
+			log.Fatal("-position:", e)
+		}
+		if *variant != "" {
+			p, e = applyVariant(p, *variant)
+			if e != nil {
+				log.Fatal("-variant:", e)
+			}
+		}
+		analyze(p)
+		return
+	}
+
 	parsed, e := ptn.ParseFile(flag.Arg(0))
 	if e != nil {
 		log.Fatal("parse:", e)
@@ -97,24 +129,64 @@ func main() {
 		if e != nil {
 			log.Fatal("initial:", e)
 		}
-		w, b := makeAI(p), makeAI(p)
-		it := parsed.Iterator()
-		for it.Next() {
-			p := it.Position()
-			m := it.PeekMove()
-			switch {
-			case p.ToMove() == tak.White && color != tak.Black:
-				fmt.Printf("%d. %s\n", p.MoveNumber()/2+1, ptn.FormatMove(&m))
-				analyzeWith(w, p)
-			case p.ToMove() == tak.Black && color != tak.White:
-				fmt.Printf("%d. ... %s\n", p.MoveNumber()/2+1, ptn.FormatMove(&m))
-				analyzeWith(b, p)
+		// One engine analyzes the whole game, for both
+		// colors, so each move's search starts from the table
+		// the last one left. The position after each move lies
+		// in the previous search's tree, whoever is to move.
+		player := makeAI(p)
+		r := plyRange{from: *from, to: *to, color: color}
+		e = r.each(parsed, func(n int, p *tak.Position, m tak.Move) {
+			if !*reuse {
+				player = makeAI(p)
+			}
+			if p.ToMove() == tak.White {
+				fmt.Printf("%d. %s\n", n, ptn.FormatMove(&m))
+			} else {
+				fmt.Printf("%d. ... %s\n", n, ptn.FormatMove(&m))
 			}
+			analyzeWith(player, p)
+		})
+		if e != nil {
+			log.Fatal(e)
+		}
+	}
+}
+
+// A plyRange selects the positions of a game to analyze: those from
+// PTN move `from` through `to`, inclusive, with `color` to move. A
+// zero `to` runs to the end of the game, and NoColor selects both
+// colors.
+type plyRange struct {
+	from, to int
+	color    tak.Color
+}
+
+// each calls `visit` with the PTN move number, the position and the
+// move played from it, for each position of `g` that `r` selects,
+// stopping once the game is over.
+func (r plyRange) each(g *ptn.PTN, visit func(n int, p *tak.Position, m tak.Move)) error {
+	it := g.Iterator()
+	for it.Next() {
+		p := it.Position()
+		n := p.MoveNumber()/2 + 1
+		if r.to != 0 && n > r.to {
+			break
 		}
-		if e := it.Err(); e != nil {
-			log.Fatalf("%d: %v", it.PTNMove(), e)
+		if n < r.from {
+			continue
 		}
+		if over, _ := p.GameOver(); over {
+			break
+		}
+		if r.color != tak.NoColor && p.ToMove() != r.color {
+			continue
+		}
+		visit(n, p, it.PeekMove())
+	}
+	if e := it.Err(); e != nil {
+		return fmt.Errorf("%d: %v", it.PTNMove(), e)
 	}
+	return nil
 }
 
 func applyVariant(p *tak.Position, variant string) (*tak.Position, error) {
@@ -133,14 +205,25 @@ func applyVariant(p *tak.Position, variant string) (*tak.Position, error) {
 }
 
 func makeAI(p *tak.Position) *ai.MinimaxAI {
+	return ai.NewMinimax(makeConfig(p))
+}
+
+func makeConfig(p *tak.Position) ai.MinimaxConfig {
 	var w ai.Weights
-	if *weights == "" {
-		w = ai.DefaultWeights[p.Size()]
-	} else {
+	switch {
+	case *weightsFile != "":
+		var e error
+		w, e = ai.LoadWeights(*weightsFile)
+		if e != nil {
+			log.Fatalf("load weights: %v", e)
+		}
+	case *weights != "":
 		e := json.Unmarshal([]byte(*weights), &w)
 		if e != nil {
 			log.Fatalf("parse weights: %v", e)
 		}
+	default:
+		w = ai.DefaultWeights[p.Size()]
 	}
 	cfg := ai.MinimaxConfig{
 		Size:  p.Size(),
@@ -148,19 +231,125 @@ func makeAI(p *tak.Position) *ai.MinimaxAI {
 		Seed:  *seed,
 		Debug: *debug,
 
-		NoSort:         !*sort,
-		NoTable:        !*table,
-		NoNullMove:     !*nullMove,
-		NoExtendForces: !*extendForces,
-		NoReduceSlides: !*reduceSlides,
-		NoMultiCut:     !*multiCut,
+		NoSort:            !*sort,
+		NoTable:           !*table,
+		NoNullMove:        !*nullMove,
+		NoExtendForces:    !*extendForces,
+		NoReduceSlides:    !*reduceSlides,
+		NoMultiCut:        !*multiCut,
+		MultiCutWidth:     *mcWidth,
+		MultiCutThreshold: *mcThreshold,
+		MultiCutDepth:     *mcDepth,
+		NoCounterMove:     !*counterMove,
+		ScoreMoves:        *scoreMoves,
+		BestDefense:       *bestDefense,
 
 		Evaluate: ai.MakeEvaluator(p.Size(), &w),
 	}
+	if *taperedFile != "" {
+		tw, e := ai.LoadTaperedWeights(*taperedFile)
+		if e != nil {
+			log.Fatalf("load tapered weights: %v", e)
+		}
+		cfg.Evaluate = nil
+		cfg.TaperedWeights = &tw
+	}
 	if *precise {
 		cfg.MakePrecise()
 	}
-	return ai.NewMinimax(cfg)
+	return cfg
+}
+
+// verifyReply searches the position after `pv[0]` with a fresh
+// engine, to one ply less than the `depth` that produced `pv`, and
+// reports whether it picks the reply the pv predicted. Each value is
+// from the perspective of the player to move in the position it was
+// searched from, so a stable search has reply value == -value.
+func verifyReply(p *tak.Position, pv []tak.Move, val int64, depth int) {
+	child, e := p.Move(&pv[0])
+	if e != nil {
+		return
+	}
+	if over, _ := child.GameOver(); over {
+		fmt.Printf(" reply: none, game over after %s\n", ptn.FormatMove(&pv[0]))
+		return
+	}
+	cfg := makeConfig(child)
+	if depth > 1 {
+		cfg.Depth = depth - 1
+	}
+	// The main search has used up its own time limit, so give
+	// the fresh search a limit of its own.
+	ctx := context.Background()
+	if *timeLimit != 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, *timeLimit)
+		defer cancel()
+	}
+	rpv, rval, _ := ai.NewMinimax(cfg).Analyze(ctx, child)
+	predicted := "(none)"
+	if len(pv) > 1 {
+		predicted = ptn.FormatMove(&pv[1])
+	}
+	fresh := "(none)"
+	if len(rpv) > 0 {
+		fresh = ptn.FormatMove(&rpv[0])
+	}
+	fmt.Printf(" reply: predicted=%s fresh=%s\n", predicted, fresh)
+	fmt.Printf("  value=%d for %s; reply value=%d for %s\n",
+		val, p.ToMove(), rval, child.ToMove())
+	switch {
+	case rval != -val:
+		fmt.Printf("  DISAGREE: fresh reply value %d, pv predicts %d\n", rval, -val)
+	case predicted != fresh:
+		fmt.Printf("  different reply, with the same value\n")
+	}
+}
+
+// printPVTPS prints the TPS of each position along `pv`, stopping at
+// the first illegal move.
+func printPVTPS(p *tak.Position, pv []tak.Move) {
+	for _, m := range pv {
+		n, e := p.Move(&m)
+		if e != nil {
+			return
+		}
+		p = n
+		fmt.Printf("  %s [TPS \"%s\"]\n", ptn.FormatMove(&m), ptn.FormatTPS(p))
+	}
+}
+
+// present returns `val`, a value of `p` for the player to move, as it
+// should be printed: from White's perspective if -absolute is set.
+func present(p *tak.Position, val int64) int64 {
+	if *abs {
+		return ai.Absolute(p, val)
+	}
+	return val
+}
+
+// winChance describes the WinProbability of `val`, the value of `p`
+// for the player to move, for whichever side it favors.
+func winChance(p *tak.Position, val int64) string {
+	c, pr := p.ToMove(), ai.WinProbability(val)
+	if pr < 0.5 {
+		c, pr = c.Flip(), 1-pr
+	}
+	return fmt.Sprintf("%.0f%% for %s", 100*pr, c)
+}
+
+// printPVDiff renders the board after each move of `pv`, marking the
+// squares the move changed, stopping at the first illegal move.
+func printPVDiff(p *tak.Position, pv []tak.Move) {
+	for _, m := range pv {
+		n, e := p.Move(&m)
+		if e != nil {
+			return
+		}
+		fmt.Printf(" after %s:", ptn.FormatMove(&m))
+		cli.RenderDiff(nil, os.Stdout, p, n)
+		p = n
+	}
 }
 
 func analyze(p *tak.Position) {
@@ -169,11 +358,7 @@ func analyze(p *tak.Position) {
 
 func analyzeWith(player *ai.MinimaxAI, p *tak.Position) {
 	if *eval {
-		val := player.Evaluate(p)
-		if p.ToMove() == tak.Black {
-			val = -val
-		}
-		fmt.Printf(" Val=%d\n", val)
+		fmt.Printf(" Val=%d\n", player.EvaluateAbsolute(p))
 		if *explain {
 			ai.ExplainScore(player, os.Stdout, p)
 		}
@@ -185,7 +370,7 @@ func analyzeWith(player *ai.MinimaxAI, p *tak.Position) {
 		ctx, cancel = context.WithTimeout(ctx, *timeLimit)
 		defer cancel()
 	}
-	pvs, val, _ := player.AnalyzeAll(ctx, p)
+	pvs, val, st := player.AnalyzeAll(ctx, p)
 	if !*quiet {
 		cli.RenderBoard(nil, os.Stdout, p)
 		if *explain {
@@ -199,8 +384,22 @@ func analyzeWith(player *ai.MinimaxAI, p *tak.Position) {
 			fmt.Printf("%s ", ptn.FormatMove(&m))
 		}
 		fmt.Printf("\n")
+		if *pvTPS {
+			printPVTPS(p, pv)
+		}
+	}
+	fmt.Printf(" value=%d (%s)\n", present(p, val), winChance(p, val))
+	if *pvEval && len(pvs) > 0 {
+		for _, st := range player.AnnotatePV(p, pvs[0]) {
+			fmt.Printf("  %s value=%d\n", ptn.FormatMove(&st.Move), present(p, st.Value))
+		}
+	}
+	if *pvDiff && len(pvs) > 0 {
+		printPVDiff(p, pvs[0])
+	}
+	if *verify && len(pvs) > 0 && len(pvs[0]) > 0 {
+		verifyReply(p, pvs[0], val, st.Depth)
 	}
-	fmt.Printf(" value=%d\n", val)
 	if *tps {
 		fmt.Printf("[TPS \"%s\"]\n", ptn.FormatTPS(p))
 	}
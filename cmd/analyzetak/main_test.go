@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+const testPTN = `[Size "5"]
+
+1. a1 e5
+2. c3 b3
+3. c2 b2
+4. c4 b4
+5. c5 b5
+6. c1
+`
+
+func TestPlyRange(t *testing.T) {
+	g, e := ptn.ParsePTN(strings.NewReader(testPTN))
+	if e != nil {
+		t.Fatal("parse:", e)
+	}
+	cases := []struct {
+		r    plyRange
+		want []string
+	}{
+		{plyRange{from: 2, to: 3},
+			[]string{"2. c3", "2. ... b3", "3. c2", "3. ... b2"}},
+		{plyRange{from: 2, to: 3, color: tak.White},
+			[]string{"2. c3", "3. c2"}},
+		{plyRange{from: 2, to: 3, color: tak.Black},
+			[]string{"2. ... b3", "3. ... b2"}},
+		{plyRange{from: 5},
+			[]string{"5. c5", "5. ... b5", "6. c1"}},
+		{plyRange{to: 1},
+			[]string{"1. a1", "1. ... e5"}},
+		{plyRange{from: 7}, nil},
+	}
+	for _, tc := range cases {
+		var got []string
+		e := tc.r.each(g, func(n int, p *tak.Position, m tak.Move) {
+			if p.ToMove() == tak.White {
+				got = append(got, fmt.Sprintf("%d. %s", n, ptn.FormatMove(&m)))
+			} else {
+				got = append(got, fmt.Sprintf("%d. ... %s", n, ptn.FormatMove(&m)))
+			}
+		})
+		if e != nil {
+			t.Errorf("%+v: %v", tc.r, e)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%+v: analyzed %q, want %q", tc.r, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// evalgraph replays a game and runs a shallow analysis of every
+// position in it, writing the evaluations as CSV or JSON, for
+// plotting how the game swung.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+var (
+	depth  = flag.Int("depth", 3, "minimax depth")
+	limit  = flag.Duration("limit", time.Second, "time limit per position")
+	seed   = flag.Int64("seed", 1, "random seed")
+	format = flag.String("format", "csv", "output format: csv or json")
+)
+
+// A point is the evaluation of one position of the game. Eval and
+// Win, its ai.WinProbability, are from white's perspective, so that a
+// whole game plots on one axis.
+type point struct {
+	Ply    int     `json:"ply"`
+	Move   int     `json:"move"`
+	Side   string  `json:"side"`
+	Played string  `json:"played"`
+	Eval   int64   `json:"eval"`
+	Win    float64 `json:"win"`
+	Depth  int     `json:"depth"`
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("usage: evalgraph [flags] GAME.ptn")
+	}
+	parsed, e := ptn.ParseFile(flag.Arg(0))
+	if e != nil {
+		log.Fatal("parse: ", e)
+	}
+	points, e := graph(parsed)
+	if e != nil {
+		log.Fatal(e)
+	}
+	switch *format {
+	case "csv":
+		e = writeCSV(points)
+	case "json":
+		e = json.NewEncoder(os.Stdout).Encode(points)
+	default:
+		log.Fatalf("unknown format %q", *format)
+	}
+	if e != nil {
+		log.Fatal("write: ", e)
+	}
+}
+
+func graph(parsed *ptn.PTN) ([]point, error) {
+	p, e := parsed.InitialPosition()
+	if e != nil {
+		return nil, fmt.Errorf("initial: %v", e)
+	}
+	player := ai.NewMinimax(ai.MinimaxConfig{
+		Size:  p.Size(),
+		Depth: *depth,
+		Seed:  *seed,
+	})
+	var points []point
+	it := parsed.Iterator()
+	for it.Next() {
+		p := it.Position()
+		pt := point{
+			Ply:  p.MoveNumber(),
+			Move: p.MoveNumber()/2 + 1,
+			Side: p.ToMove().String(),
+		}
+		if m := it.PeekMove(); m.Type != 0 {
+			pt.Played = ptn.FormatMove(&m)
+		}
+		if over, _ := p.GameOver(); over {
+			pt.Eval = player.Evaluate(p)
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), *limit)
+			_, v, st := player.Analyze(ctx, p)
+			cancel()
+			pt.Eval, pt.Depth = v, st.Depth
+		}
+		if p.ToMove() == tak.Black {
+			pt.Eval = -pt.Eval
+		}
+		pt.Win = ai.WinProbability(pt.Eval)
+		points = append(points, pt)
+	}
+	if e := it.Err(); e != nil {
+		return nil, fmt.Errorf("%d: %v", it.PTNMove(), e)
+	}
+	return points, nil
+}
+
+func writeCSV(points []point) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"ply", "move", "side", "played", "eval", "win", "depth"})
+	for _, pt := range points {
+		w.Write([]string{
+			strconv.Itoa(pt.Ply),
+			strconv.Itoa(pt.Move),
+			pt.Side,
+			pt.Played,
+			strconv.FormatInt(pt.Eval, 10),
+			strconv.FormatFloat(pt.Win, 'f', 3, 64),
+			strconv.Itoa(pt.Depth),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
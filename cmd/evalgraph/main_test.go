@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nelhage/taktician/ptn"
+)
+
+const testPTN = `[Size "5"]
+
+1. a1 e5
+2. c3 b3
+3. c2 b2
+4. c4 b4
+`
+
+func TestGraph(t *testing.T) {
+	g, e := ptn.ParsePTN(strings.NewReader(testPTN))
+	if e != nil {
+		t.Fatal("parse:", e)
+	}
+	*depth = 1
+	points, e := graph(g)
+	if e != nil {
+		t.Fatal("graph:", e)
+	}
+	played := []string{"a1", "e5", "c3", "b3", "c2", "b2", "c4", "b4", ""}
+	if len(points) != len(played) {
+		t.Fatalf("got %d points, want %d", len(points), len(played))
+	}
+	for i, pt := range points {
+		if pt.Ply != i {
+			t.Errorf("point %d: ply=%d", i, pt.Ply)
+		}
+		if i > 0 && pt.Move < points[i-1].Move {
+			t.Errorf("point %d: move %d follows %d", i, pt.Move, points[i-1].Move)
+		}
+		if pt.Played != played[i] {
+			t.Errorf("point %d: played=%q, want %q", i, pt.Played, played[i])
+		}
+		if pt.Win < 0 || pt.Win > 1 {
+			t.Errorf("point %d: win=%f", i, pt.Win)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+var (
+	size  = flag.Int("size", 5, "board size")
+	komi  = flag.String("komi", "0", "komi, in flats, awarded to black; may be a half-flat, such as 2.5")
+	games = flag.Int("games", 10, "number of games to play (at most, with -sprt)")
+	seed  = flag.Int64("seed", 0, "starting random seed")
+
+	depth  = flag.Int("depth", 3, "depth to search each move")
+	limit  = flag.Duration("limit", 0, "amount of time to search each move")
+	cutoff = flag.Int("cutoff", 200, "cut games off after how many plies")
+
+	c1 = flag.String("c1", "", "player 1 config (JSON MinimaxConfig)")
+	c2 = flag.String("c2", "", "player 2 config (JSON MinimaxConfig)")
+	w1 = flag.String("w1", "", "player 1 weights")
+	w2 = flag.String("w2", "", "player 2 weights")
+
+	resign     = flag.Int64("resign", 0, "resign when the player to move's evaluation is below minus this")
+	winMargin  = flag.Int64("adjudicate-margin", 0, "adjudicate a win at this evaluation")
+	winPlies   = flag.Int("adjudicate-plies", 0, "adjudicate a win after the margin is held this many plies")
+	drawPlies  = flag.Int("draw-plies", 0, "adjudicate a draw no earlier than this ply")
+	drawMargin = flag.Int64("draw-margin", 0, "adjudicate a draw when the evaluation is within this margin")
+	drawQuiet  = flag.Int("draw-quiet", 0, "adjudicate a draw after the draw margin is held this many plies")
+
+	sprt  = flag.Bool("sprt", false, "stop early once an SPRT reaches a verdict")
+	elo0  = flag.Float64("elo0", 0, "SPRT: elo difference under H0")
+	elo1  = flag.Float64("elo1", 20, "SPRT: elo difference under H1")
+	alpha = flag.Float64("alpha", 0.05, "SPRT: false-positive rate")
+	beta  = flag.Float64("beta", 0.05, "SPRT: false-negative rate")
+
+	out     = flag.String("out", "", "file to write PTN games to")
+	verbose = flag.Bool("v", false, "verbose output")
+)
+
+func playerConfig(c, w string) ai.MinimaxConfig {
+	cfg := ai.MinimaxConfig{
+		Depth: *depth,
+		Size:  *size,
+	}
+	if c != "" {
+		if err := json.Unmarshal([]byte(c), &cfg); err != nil {
+			log.Fatal("config:", err)
+		}
+	}
+	if w != "" {
+		weights := ai.DefaultWeights[*size]
+		if err := json.Unmarshal([]byte(w), &weights); err != nil {
+			log.Fatal("weights:", err)
+		}
+		cfg.Evaluate = ai.MakeEvaluator(*size, &weights)
+	}
+	return cfg
+}
+
+func main() {
+	flag.Parse()
+	if *seed == 0 {
+		*seed = time.Now().Unix()
+	}
+	r := rand.New(rand.NewSource(*seed))
+	halfKomi, err := ptn.ParseKomi(*komi)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	m := &Match{
+		Config: tak.Config{Size: *size, HalfKomi: halfKomi},
+		Limit:  *limit,
+		Cutoff: *cutoff,
+		Resign: *resign,
+		Adjudicate: ai.AdjudicateConfig{
+			WinMargin:      *winMargin,
+			WinPlies:       *winPlies,
+			DrawPlies:      *drawPlies,
+			DrawMargin:     *drawMargin,
+			DrawQuietPlies: *drawQuiet,
+		},
+	}
+	cfgs := [2]ai.MinimaxConfig{
+		playerConfig(*c1, *w1),
+		playerConfig(*c2, *w2),
+	}
+
+	var f *os.File
+	if *out != "" {
+		var e error
+		f, e = os.Create(*out)
+		if e != nil {
+			log.Fatalf("open %s: %v", *out, e)
+		}
+		defer f.Close()
+	}
+
+	test := &SPRT{Elo0: *elo0, Elo1: *elo1, Alpha: *alpha, Beta: *beta}
+	verdict := Continue
+
+	var wins [2]int
+	var draws, cutoffs int
+	played := 0
+	for i := 0; i < *games && verdict == Continue; i++ {
+		// player 1 is white in even games
+		p1, p2 := cfgs[0], cfgs[1]
+		p1.Seed, p2.Seed = r.Int63(), r.Int63()
+		white, black := ai.NewMinimax(p1), ai.NewMinimax(p2)
+		names := [2]string{"p1", "p2"}
+		p1color := tak.White
+		if i%2 == 1 {
+			white, black = black, white
+			names[0], names[1] = names[1], names[0]
+			p1color = tak.Black
+		}
+
+		g, e := m.Play(white, black)
+		if e != nil {
+			log.Fatalf("game %d: %v", i, e)
+		}
+		played++
+		switch {
+		case !g.Over:
+			cutoffs++
+		case g.Winner == tak.NoColor:
+			draws++
+		case g.Winner == p1color:
+			wins[0]++
+		default:
+			wins[1]++
+		}
+		if *verbose {
+			log.Printf("game n=%d plies=%d p1=%s result=%s adjudicated=%v resigned=%v",
+				i, len(g.Moves), p1color, g.Result(), g.Adjudicated, g.Resigned)
+		}
+		if f != nil {
+			fmt.Fprintf(f, "%s\n", g.PTN(m.Config, names[0], names[1]).Render())
+		}
+		if *sprt {
+			// cutoff games count as draws for the test
+			verdict = test.Test(wins[0], wins[1], draws+cutoffs)
+		}
+	}
+
+	fmt.Printf("games=%d seed=%d p1.wins=%d p2.wins=%d draws=%d cutoff=%d\n",
+		played, *seed, wins[0], wins[1], draws, cutoffs)
+	fmt.Printf("elo=%+.1f\n", Elo(wins[0], wins[1], draws+cutoffs))
+	if *sprt {
+		lower, upper := test.Bounds()
+		fmt.Printf("sprt elo0=%g elo1=%g llr=%.3f bounds=[%.3f, %.3f] verdict=%s\n",
+			test.Elo0, test.Elo1, test.LLR(wins[0], wins[1], draws+cutoffs),
+			lower, upper, verdict)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+// An analyzer is a player that can also report its evaluation of the
+// position, which we use for adjudication.
+type analyzer interface {
+	Analyze(ctx context.Context, p *tak.Position) ([]tak.Move, int64, ai.Stats)
+}
+
+type Match struct {
+	Config tak.Config
+	Limit  time.Duration
+	Cutoff int
+
+	// A player resigns when its evaluation of the position it is
+	// to move in falls below -Resign. Zero disables resignation.
+	// Only players that can report an evaluation resign.
+	Resign int64
+
+	Adjudicate ai.AdjudicateConfig
+}
+
+type Game struct {
+	Moves       []tak.Move
+	Position    *tak.Position
+	Over        bool
+	Winner      tak.Color
+	Adjudicated bool
+	Resigned    bool
+}
+
+// Play plays a single game between `white` and `black`.
+func (m *Match) Play(white, black ai.TakPlayer) (*Game, error) {
+	p := tak.New(m.Config)
+	adj := ai.NewAdjudicator(m.Adjudicate)
+	g := &Game{}
+	for ply := 0; m.Cutoff == 0 || ply < m.Cutoff; ply++ {
+		player := white
+		if p.ToMove() == tak.Black {
+			player = black
+		}
+
+		ctx := context.Background()
+		var cancel context.CancelFunc
+		if m.Limit != 0 {
+			ctx, cancel = context.WithTimeout(ctx, m.Limit)
+		}
+		var mv tak.Move
+		var eval int64
+		a, canEval := player.(analyzer)
+		if canEval {
+			var pv []tak.Move
+			pv, eval, _ = a.Analyze(ctx, p)
+			if len(pv) == 0 {
+				if cancel != nil {
+					cancel()
+				}
+				return nil, fmt.Errorf("ply %d: no move found within the time limit", ply)
+			}
+			mv = pv[0]
+		} else {
+			mv = player.GetMove(ctx, p)
+		}
+		if cancel != nil {
+			cancel()
+		}
+
+		if canEval && m.Resign != 0 && eval < -m.Resign {
+			g.Over, g.Winner, g.Resigned = true, p.ToMove().Flip(), true
+			break
+		}
+		if canEval {
+			if over, winner := adj.Adjudicate(p, eval, ply); over {
+				g.Over, g.Winner, g.Adjudicated = true, winner, true
+				break
+			}
+		}
+
+		next, e := p.Move(&mv)
+		if e != nil {
+			return nil, fmt.Errorf("ply %d: illegal move %s: %v",
+				ply, ptn.FormatMove(&mv), e)
+		}
+		p = next
+		g.Moves = append(g.Moves, mv)
+		if over, winner := p.GameOver(); over {
+			g.Over, g.Winner = true, winner
+			break
+		}
+	}
+	g.Position = p
+	return g, nil
+}
+
+// Result returns the game result in PTN notation, or "" if the game
+// was cut off without a result.
+func (g *Game) Result() string {
+	if !g.Over {
+		return ""
+	}
+	if g.Adjudicated || g.Resigned {
+		r := ptn.GameResult{Winner: g.Winner, Reason: ptn.OtherResult}
+		if g.Winner == tak.NoColor {
+			r.Reason = ptn.DrawResult
+		}
+		return r.String()
+	}
+	return ptn.ResultOf(g.Position).String()
+}
+
+func (g *Game) PTN(cfg tak.Config, white, black string) *ptn.PTN {
+	p := &ptn.PTN{}
+	p.Tags = []ptn.Tag{
+		{Name: "Size", Value: fmt.Sprintf("%d", cfg.Size)},
+		{Name: "Player1", Value: white},
+		{Name: "Player2", Value: black},
+	}
+	if cfg.HalfKomi != 0 {
+		p.Tags = append(p.Tags, ptn.Tag{
+			Name: "Komi", Value: ptn.FormatKomi(cfg.HalfKomi)})
+	}
+	p.AddMoves(g.Moves)
+	if r := g.Result(); r != "" {
+		res, _ := ptn.ParseResult(r)
+		p.SetResult(res)
+	}
+	return p
+}
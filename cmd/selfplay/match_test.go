@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/tak"
+)
+
+// stubPlayer plays the first legal move, reporting `eval` for every
+// position, or no move at all if `empty` is set.
+type stubPlayer struct {
+	eval  int64
+	empty bool
+}
+
+func (s *stubPlayer) Analyze(ctx context.Context, p *tak.Position) ([]tak.Move, int64, ai.Stats) {
+	if s.empty {
+		return nil, 0, ai.Stats{}
+	}
+	return p.AllMoves(nil)[:1], s.eval, ai.Stats{}
+}
+
+func (s *stubPlayer) GetMove(ctx context.Context, p *tak.Position) tak.Move {
+	return p.AllMoves(nil)[0]
+}
+
+func (s *stubPlayer) Name() string {
+	return "stub"
+}
+
+func TestMatchResign(t *testing.T) {
+	m := &Match{Config: tak.Config{Size: 5}, Cutoff: 20, Resign: 500}
+	g, e := m.Play(&stubPlayer{eval: 0}, &stubPlayer{eval: -1000})
+	if e != nil {
+		t.Fatal("play:", e)
+	}
+	if !g.Over || !g.Resigned || g.Winner != tak.White || len(g.Moves) != 1 {
+		t.Fatalf("game: over=%v resigned=%v winner=%s plies=%d",
+			g.Over, g.Resigned, g.Winner, len(g.Moves))
+	}
+	if r := g.Result(); r != "1-0" {
+		t.Errorf("Result()=%q", r)
+	}
+
+	m.Resign = 0
+	g, e = m.Play(&stubPlayer{eval: 0}, &stubPlayer{eval: -1000})
+	if e != nil {
+		t.Fatal("play:", e)
+	}
+	if g.Resigned {
+		t.Error("resigned with resignation disabled")
+	}
+}
+
+func TestMatchEmptyPV(t *testing.T) {
+	m := &Match{Config: tak.Config{Size: 5}, Cutoff: 20}
+	_, e := m.Play(&stubPlayer{empty: true}, &stubPlayer{})
+	if e == nil || !strings.Contains(e.Error(), "no move") {
+		t.Errorf("Play: err=%v, want no move found", e)
+	}
+}
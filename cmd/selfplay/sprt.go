@@ -0,0 +1,86 @@
+package main
+
+import "math"
+
+// SPRT performs a sequential probability ratio test of the hypothesis
+// that player 1 is Elo1 stronger than player 2 (H1) against the
+// hypothesis that it is Elo0 stronger (H0), using the normal
+// approximation to the game-score distribution.
+type SPRT struct {
+	Elo0, Elo1  float64
+	Alpha, Beta float64
+}
+
+type Verdict int
+
+const (
+	Continue Verdict = iota
+	AcceptH0
+	AcceptH1
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case AcceptH0:
+		return "H0"
+	case AcceptH1:
+		return "H1"
+	default:
+		return "continue"
+	}
+}
+
+func eloScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// Elo returns the Elo difference implied by a record of `w` wins, `l`
+// losses, and `d` draws.
+func Elo(w, l, d int) float64 {
+	n := float64(w + l + d)
+	if n == 0 {
+		return 0
+	}
+	s := (float64(w) + float64(d)/2) / n
+	s = math.Max(math.Min(s, 1-1e-6), 1e-6)
+	return -400 * math.Log10(1/s-1)
+}
+
+// llrPseudoCount is added to each of the win, loss and draw counts
+// when estimating the score variance, so that it stays positive when
+// a record is one-sided, such as no losses or only draws.
+const llrPseudoCount = 0.5
+
+// LLR returns the log-likelihood ratio of H1 to H0 given the record.
+func (t *SPRT) LLR(w, l, d int) float64 {
+	games := w + l + d
+	if games == 0 {
+		return 0
+	}
+	n := float64(games) + 3*llrPseudoCount
+	ww := (float64(w) + llrPseudoCount) / n
+	dd := (float64(d) + llrPseudoCount) / n
+	s := ww + dd/2
+	m2 := ww + dd/4
+	variance := (m2 - s*s) / float64(games)
+	s0, s1 := eloScore(t.Elo0), eloScore(t.Elo1)
+	return (s1 - s0) * (2*s - s0 - s1) / (2 * variance)
+}
+
+// Bounds returns the LLR bounds below which H0 and above which H1 is
+// accepted.
+func (t *SPRT) Bounds() (lower, upper float64) {
+	return math.Log(t.Beta / (1 - t.Alpha)), math.Log((1 - t.Beta) / t.Alpha)
+}
+
+func (t *SPRT) Test(w, l, d int) Verdict {
+	llr := t.LLR(w, l, d)
+	lower, upper := t.Bounds()
+	switch {
+	case llr <= lower:
+		return AcceptH0
+	case llr >= upper:
+		return AcceptH1
+	}
+	return Continue
+}
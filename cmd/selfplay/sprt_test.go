@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestElo(t *testing.T) {
+	cases := []struct {
+		w, l, d int
+		want    float64
+	}{
+		{0, 0, 0, 0},
+		{1, 1, 2, 0},
+		{3, 1, 0, 400 * math.Log10(3)},
+		{1, 3, 0, -400 * math.Log10(3)},
+		{2, 0, 2, 400 * math.Log10(3)},
+	}
+	for _, tc := range cases {
+		if got := Elo(tc.w, tc.l, tc.d); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("Elo(%d, %d, %d)=%f, want %f", tc.w, tc.l, tc.d, got, tc.want)
+		}
+	}
+	if e := Elo(10, 0, 0); math.IsInf(e, 0) || e < 1000 {
+		t.Errorf("Elo(10, 0, 0)=%f, want large and finite", e)
+	}
+}
+
+func TestSPRTBounds(t *testing.T) {
+	test := &SPRT{Elo0: 0, Elo1: 20, Alpha: 0.05, Beta: 0.05}
+	lower, upper := test.Bounds()
+	want := math.Log(0.05 / 0.95)
+	if math.Abs(lower-want) > 1e-9 || math.Abs(upper+want) > 1e-9 {
+		t.Errorf("Bounds()=%f, %f, want %f, %f", lower, upper, want, -want)
+	}
+}
+
+func TestSPRT(t *testing.T) {
+	test := &SPRT{Elo0: 0, Elo1: 20, Alpha: 0.05, Beta: 0.05}
+	cases := []struct {
+		w, l, d int
+		want    Verdict
+	}{
+		{0, 0, 0, Continue},
+		{10, 10, 5, Continue},
+		{500, 500, 0, Continue},
+		{2000, 2000, 0, AcceptH0},
+		{0, 0, 100, AcceptH0},
+		{40, 0, 0, AcceptH1},
+		{40, 0, 10, AcceptH1},
+		{0, 40, 10, AcceptH0},
+		{600, 480, 200, AcceptH1},
+		{5, 0, 0, Continue},
+	}
+	for _, tc := range cases {
+		if got := test.Test(tc.w, tc.l, tc.d); got != tc.want {
+			t.Errorf("Test(%d, %d, %d)=%s (llr=%.3f), want %s",
+				tc.w, tc.l, tc.d, got, test.LLR(tc.w, tc.l, tc.d), tc.want)
+		}
+	}
+
+	// More evidence in the same direction only strengthens it.
+	if a, b := test.LLR(20, 0, 5), test.LLR(40, 0, 10); !(b > a && a > 0) {
+		t.Errorf("LLR(20, 0, 5)=%f, LLR(40, 0, 10)=%f", a, b)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/nelhage/taktician/ai"
+	"github.com/nelhage/taktician/cli"
+	"github.com/nelhage/taktician/ptn"
+	"github.com/nelhage/taktician/tak"
+)
+
+var (
+	position = flag.String("position", "", "start from this TPS position instead of reading a PTN file")
+	depth    = flag.Int("depth", 0, "default minimax depth for `go`")
+	limit    = flag.Duration("limit", time.Minute, "time limit for `go`")
+	debug    = flag.Int("debug", 0, "debug level")
+	unicode  = flag.Bool("unicode", false, "render board with utf8 glyphs")
+)
+
+const help = `commands:
+  next [n], prev [n]   step forward or back through the game
+  first, last          jump to the start or end of the game
+  play <move>          play a move, discarding any later moves
+  go [depth]           analyze the current position
+  eval                 show the static evaluation
+  moves                list legal moves
+  board                render the board
+  tps                  print the position in TPS
+  quit
+`
+
+// A repl holds the positions of a game, and the one currently being
+// looked at. history[i+1] is the result of playing moves[i] in
+// history[i].
+type repl struct {
+	history []*tak.Position
+	moves   []tak.Move
+	cur     int
+
+	// ais caches an analyzer for each depth `go` has been
+	// given, so that repeated analysis can reuse their tables.
+	ais map[int]*ai.MinimaxAI
+}
+
+func glyphs() *cli.Glyphs {
+	if *unicode {
+		return &cli.UnicodeGlyphs
+	}
+	return &cli.DefaultGlyphs
+}
+
+func main() {
+	flag.Parse()
+
+	var r repl
+	if *position != "" {
+		p, e := ptn.ParseTPS(*position)
+		if e != nil {
+			log.Fatal("-position: ", e)
+		}
+		r.history = []*tak.Position{p}
+	} else {
+		if flag.NArg() != 1 {
+			log.Fatal("usage: takrepl [flags] GAME.ptn")
+		}
+		parsed, e := ptn.ParseFile(flag.Arg(0))
+		if e != nil {
+			log.Fatal("parse: ", e)
+		}
+		if e := r.load(parsed); e != nil {
+			log.Fatal(e)
+		}
+	}
+
+	r.ais = make(map[int]*ai.MinimaxAI)
+	r.render()
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Printf("%s> ", r.label())
+		if !in.Scan() {
+			fmt.Println()
+			break
+		}
+		words := strings.Fields(in.Text())
+		if len(words) == 0 {
+			continue
+		}
+		if words[0] == "quit" || words[0] == "q" {
+			break
+		}
+		if e := r.run(words[0], words[1:]); e != nil {
+			fmt.Println("error:", e)
+		}
+	}
+	if e := in.Err(); e != nil {
+		log.Fatal("read: ", e)
+	}
+}
+
+func (r *repl) load(parsed *ptn.PTN) error {
+	p, e := parsed.InitialPosition()
+	if e != nil {
+		return fmt.Errorf("initial: %v", e)
+	}
+	r.history = []*tak.Position{p}
+	for _, op := range parsed.Ops {
+		m, ok := op.(*ptn.Move)
+		if !ok {
+			continue
+		}
+		next, e := p.Move(&m.Move)
+		if e != nil {
+			log.Printf("stopping at illegal move %s: %v",
+				ptn.FormatMove(&m.Move), e)
+			break
+		}
+		r.moves = append(r.moves, m.Move)
+		r.history = append(r.history, next)
+		p = next
+	}
+	return nil
+}
+
+func (r *repl) position() *tak.Position {
+	return r.history[r.cur]
+}
+
+// label describes the current position in the prompt, as the PTN
+// move number and the move about to be played.
+func (r *repl) label() string {
+	p := r.position()
+	n := p.MoveNumber()/2 + 1
+	if p.ToMove() == tak.White {
+		return fmt.Sprintf("%d.", n)
+	}
+	return fmt.Sprintf("%d. ...", n)
+}
+
+func (r *repl) run(cmd string, args []string) error {
+	switch cmd {
+	case "next", "n":
+		return r.step(args, 1)
+	case "prev", "p":
+		return r.step(args, -1)
+	case "first":
+		r.cur = 0
+		r.render()
+	case "last":
+		r.cur = len(r.history) - 1
+		r.render()
+	case "play":
+		if len(args) != 1 {
+			return errors.New("usage: play <move>")
+		}
+		return r.play(args[0])
+	case "go":
+		d := *depth
+		if len(args) > 0 {
+			var e error
+			if d, e = strconv.Atoi(args[0]); e != nil {
+				return fmt.Errorf("bad depth %q", args[0])
+			}
+		}
+		r.analyze(d)
+	case "eval":
+		p := r.position()
+		player := r.analyzer(*depth)
+		fmt.Printf("value=%d\n", player.EvaluateAbsolute(p))
+		ai.ExplainScore(player, os.Stdout, p)
+	case "moves":
+		p := r.position()
+		if over, _ := p.GameOver(); !over {
+			for _, m := range p.AllMoves(nil) {
+				fmt.Printf("%s ", ptn.FormatMove(&m))
+			}
+		}
+		fmt.Println()
+	case "board":
+		r.render()
+	case "tps":
+		fmt.Printf("[TPS \"%s\"]\n", ptn.FormatTPS(r.position()))
+	case "help", "?":
+		fmt.Print(help)
+	default:
+		return fmt.Errorf("unknown command %q (try `help')", cmd)
+	}
+	return nil
+}
+
+func (r *repl) step(args []string, dir int) error {
+	n := 1
+	if len(args) > 0 {
+		var e error
+		if n, e = strconv.Atoi(args[0]); e != nil || n < 0 {
+			return fmt.Errorf("bad count %q", args[0])
+		}
+	}
+	to := r.cur + dir*n
+	if to < 0 {
+		to = 0
+	}
+	if to >= len(r.history) {
+		to = len(r.history) - 1
+	}
+	for i := r.cur; i < to; i++ {
+		fmt.Printf("%s\n", ptn.FormatMove(&r.moves[i]))
+	}
+	r.cur = to
+	r.render()
+	return nil
+}
+
+func (r *repl) play(move string) error {
+	m, e := ptn.ParseMove(move)
+	if e != nil {
+		return e
+	}
+	if over, _ := r.position().GameOver(); over {
+		return errors.New("game is over")
+	}
+	next, e := r.position().Move(&m)
+	if e != nil {
+		return e
+	}
+	r.history = append(r.history[:r.cur+1], next)
+	r.moves = append(r.moves[:r.cur], m)
+	r.cur++
+	r.render()
+	return nil
+}
+
+func (r *repl) analyze(depth int) {
+	ctx := context.Background()
+	if *limit != 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, *limit)
+		defer cancel()
+	}
+	pv, val, st := r.analyzer(depth).Analyze(ctx, r.position())
+	fmt.Printf("depth=%d value=%d pv=", st.Depth, val)
+	for _, m := range pv {
+		fmt.Printf("%s ", ptn.FormatMove(&m))
+	}
+	fmt.Println()
+}
+
+func (r *repl) analyzer(depth int) *ai.MinimaxAI {
+	if a, ok := r.ais[depth]; ok {
+		return a
+	}
+	a := ai.NewMinimax(ai.MinimaxConfig{
+		Size:  r.history[0].Size(),
+		Depth: depth,
+		Debug: *debug,
+	})
+	r.ais[depth] = a
+	return a
+}
+
+func (r *repl) render() {
+	p := r.position()
+	cli.RenderBoard(glyphs(), os.Stdout, p)
+	if over, winner := p.GameOver(); over {
+		if winner == tak.NoColor {
+			fmt.Println("game over: draw")
+		} else {
+			fmt.Printf("game over: %s wins\n", winner)
+		}
+	}
+}
@@ -39,8 +39,8 @@ func (t *Taktician) GetMove(
 	mine, theirs time.Duration) tak.Move {
 	if p.ToMove() == t.g.Color {
 		var cancel context.CancelFunc
-		timeout := t.timeBound(mine)
-		if p.MoveNumber() < 2 {
+		timeout := t.timeBound(mine, p.MoveNumber()/2)
+		if p.IsOpeningSwap() {
 			timeout = 20 * time.Second
 		}
 		ctx, cancel = context.WithTimeout(ctx, timeout)
@@ -51,11 +51,16 @@ func (t *Taktician) GetMove(
 	return t.ai.GetMove(ctx, p)
 }
 
-func (t *Taktician) timeBound(remaining time.Duration) time.Duration {
-	if t.g.Size == 4 {
+func (t *Taktician) timeBound(remaining time.Duration, moves int) time.Duration {
+	if remaining == 0 {
+		// we don't know our clock
 		return *limit
 	}
-	return *limit
+	budget := ai.BudgetForMove(remaining, *increment, moves)
+	if budget > *limit {
+		return *limit
+	}
+	return budget
 }
 
 func (t *Taktician) GameOver() {
@@ -2,16 +2,19 @@ package ptn
 
 import (
 	"errors"
-	"regexp"
 
 	"github.com/nelhage/taktician/tak"
 )
 
-var moveRE = regexp.MustCompile(
-	// [place] [carry] position [direction] [drops] [top]
-	`\A([CFS]?)([1-8]?)([a-h][1-9])([<>+-]?)([1-8]*)([CFS]?)\z`,
-)
-
+// ParseMove parses a move in PTN notation. In addition to the
+// canonical form produced by FormatMove, it accepts the common
+// variants seen in the wild:
+//
+//   - an explicit `F` for flat placements (`Fa1`)
+//   - an uppercase file (`A1`, `3A1>12`)
+//   - explicit carry and drop counts (`1a1>`, `3a1>3`)
+//   - the carry count after the square (`a13>12`)
+//   - a trailing `*` marking that a wall was flattened (`a1>*`)
 func ParseMove(move string) (tak.Move, error) {
 	if len(move) < 2 {
 		return tak.Move{}, errors.New("move too short")
@@ -19,16 +22,20 @@ func ParseMove(move string) (tak.Move, error) {
 
 	var m tak.Move
 	var stack int
+	placed := false
 	i := 0
 	switch move[i] {
 	case 'F':
 		m.Type = tak.PlaceFlat
+		placed = true
 		i++
 	case 'S':
 		m.Type = tak.PlaceStanding
+		placed = true
 		i++
 	case 'C':
 		m.Type = tak.PlaceCapstone
+		placed = true
 		i++
 	default:
 		if move[i] >= '1' && move[i] <= '8' {
@@ -44,10 +51,14 @@ func ParseMove(move string) (tak.Move, error) {
 		return tak.Move{}, errors.New("move too short")
 	}
 
-	if move[i] >= 'a' && move[i] <= 'h' {
-		m.X = int(move[i] - 'a')
+	switch f := move[i]; {
+	case f >= 'a' && f <= 'h':
+		m.X = int(f - 'a')
 		i++
-	} else {
+	case f >= 'A' && f <= 'H':
+		m.X = int(f - 'A')
+		i++
+	default:
 		return tak.Move{}, errors.New("illegal move")
 	}
 	if move[i] >= '1' && move[i] <= '8' {
@@ -62,6 +73,16 @@ func ParseMove(move string) (tak.Move, error) {
 		}
 		return m, nil
 	}
+	if placed {
+		return tak.Move{}, errors.New("illegal move")
+	}
+	if stack == 0 && move[i] >= '1' && move[i] <= '8' {
+		stack = int(move[i] - '0')
+		i++
+		if i == len(move) {
+			return tak.Move{}, errors.New("illegal move")
+		}
+	}
 	switch move[i] {
 	case '<':
 		m.Type = tak.SlideLeft
@@ -78,8 +99,15 @@ func ParseMove(move string) (tak.Move, error) {
 		stack = 1
 	}
 	i++
-	for ; i != len(move); i++ {
+	end := len(move)
+	if move[end-1] == '*' {
+		end--
+	}
+	for ; i < end; i++ {
 		d := move[i]
+		if d < '1' || d > '8' {
+			return tak.Move{}, errors.New("malformed move: bad drop")
+		}
 		m.Slides = append(m.Slides, byte(d-'0'))
 		stack -= int(d - '0')
 	}
@@ -92,26 +120,61 @@ func ParseMove(move string) (tak.Move, error) {
 	return m, nil
 }
 
+// FormatOptions selects the PTN dialect produced by
+// FormatMoveOptions. The zero value produces the canonical notation
+// used by FormatMove.
+type FormatOptions struct {
+	// FlatPrefix writes an explicit `F` before flat placements.
+	FlatPrefix bool
+	// ExplicitCounts always writes the carry count and drop
+	// counts of a slide, even when they could be omitted.
+	ExplicitCounts bool
+	// SquareFirst writes the carry count after the square
+	// (`a13>12`) instead of before it (`3a1>12`).
+	SquareFirst bool
+	// Uppercase writes the file of the square as an uppercase
+	// letter.
+	Uppercase bool
+}
+
 func FormatMove(m *tak.Move) string {
+	return FormatMoveOptions(m, FormatOptions{})
+}
+
+func FormatMoveOptions(m *tak.Move, o FormatOptions) string {
 	var out []byte
+	var count []byte
 	stack := 0
 	if len(m.Slides) > 0 {
 		for _, c := range m.Slides {
 			stack += int(c)
 		}
-		if stack != 1 {
-			out = append(out, byte('0'+stack))
+		if stack != 1 || o.ExplicitCounts {
+			count = append(count, byte('0'+stack))
 		}
 	}
+	if !o.SquareFirst {
+		out = append(out, count...)
+	}
 	switch m.Type {
 	case tak.PlaceFlat:
+		if o.FlatPrefix {
+			out = append(out, 'F')
+		}
 	case tak.PlaceCapstone:
 		out = append(out, 'C')
 	case tak.PlaceStanding:
 		out = append(out, 'S')
 	}
-	out = append(out, byte('a'+m.X))
+	if o.Uppercase {
+		out = append(out, byte('A'+m.X))
+	} else {
+		out = append(out, byte('a'+m.X))
+	}
 	out = append(out, byte('1'+m.Y))
+	if o.SquareFirst {
+		out = append(out, count...)
+	}
 	switch m.Type {
 	case tak.SlideLeft:
 		out = append(out, '<')
@@ -122,7 +185,7 @@ func FormatMove(m *tak.Move) string {
 	case tak.SlideDown:
 		out = append(out, '-')
 	}
-	if len(m.Slides) > 0 && int(m.Slides[0]) != stack {
+	if len(m.Slides) > 0 && (int(m.Slides[0]) != stack || o.ExplicitCounts) {
 		for _, s := range m.Slides {
 			out = append(out, byte('0'+s))
 		}
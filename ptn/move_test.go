@@ -80,6 +80,11 @@ func TestParseMoveErrors(t *testing.T) {
 		"6a1>2222",
 		"a",
 		"3a",
+		"Sa1>",
+		"Fa1+",
+		"a13",
+		"3a13>",
+		"a1>x",
 	}
 	for _, b := range bad {
 		_, e := ParseMove(b)
@@ -89,6 +94,74 @@ func TestParseMoveErrors(t *testing.T) {
 	}
 }
 
+func TestParseMoveDialects(t *testing.T) {
+	cases := []struct {
+		out tak.Move
+		in  []string
+	}{
+		{
+			tak.Move{X: 0, Y: 0, Type: tak.PlaceFlat},
+			[]string{"a1", "Fa1", "A1", "FA1"},
+		},
+		{
+			tak.Move{X: 2, Y: 3, Type: tak.PlaceStanding},
+			[]string{"Sc4", "SC4"},
+		},
+		{
+			tak.Move{X: 0, Y: 0, Type: tak.SlideRight, Slides: []byte{1}},
+			[]string{"a1>", "1a1>", "1a1>1", "a11>", "a11>1", "A1>", "a1>*"},
+		},
+		{
+			tak.Move{X: 3, Y: 3, Type: tak.SlideDown, Slides: []byte{2, 1}},
+			[]string{"3d4-21", "3d4-2", "d43-21", "d43-2", "3D4-21", "3d4-21*"},
+		},
+	}
+	for _, tc := range cases {
+		for _, in := range tc.in {
+			get, err := ParseMove(in)
+			if err != nil {
+				t.Errorf("ParseMove(%s): err=%v", in, err)
+				continue
+			}
+			if !reflect.DeepEqual(get, tc.out) {
+				t.Errorf("ParseMove(%s)=%#v not %#v", in, get, tc.out)
+			}
+		}
+	}
+}
+
+func TestFormatMoveOptions(t *testing.T) {
+	slide := tak.Move{X: 3, Y: 3, Type: tak.SlideDown, Slides: []byte{2, 1}}
+	single := tak.Move{X: 0, Y: 0, Type: tak.SlideRight, Slides: []byte{1}}
+	flat := tak.Move{X: 0, Y: 0, Type: tak.PlaceFlat}
+	cases := []struct {
+		m    tak.Move
+		opts FormatOptions
+		out  string
+	}{
+		{flat, FormatOptions{}, "a1"},
+		{flat, FormatOptions{FlatPrefix: true}, "Fa1"},
+		{flat, FormatOptions{FlatPrefix: true, Uppercase: true}, "FA1"},
+		{slide, FormatOptions{}, "3d4-21"},
+		{slide, FormatOptions{SquareFirst: true}, "d43-21"},
+		{slide, FormatOptions{Uppercase: true}, "3D4-21"},
+		{single, FormatOptions{}, "a1>"},
+		{single, FormatOptions{ExplicitCounts: true}, "1a1>1"},
+		{single, FormatOptions{ExplicitCounts: true, SquareFirst: true}, "a11>1"},
+	}
+	for _, tc := range cases {
+		out := FormatMoveOptions(&tc.m, tc.opts)
+		if out != tc.out {
+			t.Errorf("FormatMoveOptions(%#v, %+v)=%s not %s", tc.m, tc.opts, out, tc.out)
+			continue
+		}
+		back, err := ParseMove(out)
+		if err != nil || !reflect.DeepEqual(back, tc.m) {
+			t.Errorf("ParseMove(%s)=%#v, %v not %#v", out, back, err, tc.m)
+		}
+	}
+}
+
 func BenchmarkParseMove(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		ParseMove("3a1+111")
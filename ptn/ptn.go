@@ -3,13 +3,18 @@ package ptn
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"math"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/nelhage/taktician/tak"
@@ -51,6 +56,19 @@ type Move struct {
 	opCommon
 	Move      tak.Move
 	Modifiers string
+
+	// TimeLeft is the mover's remaining clock time after the
+	// move, read from a `[%clk ...]` annotation in a comment
+	// directly following it. HasClock reports whether there was
+	// one.
+	TimeLeft time.Duration
+	HasClock bool
+}
+
+// Placeholder is the `--` standing in for white's missing move
+// when a game starts from a TPS with black to play.
+type Placeholder struct {
+	opCommon
 }
 
 type Comment struct {
@@ -64,15 +82,88 @@ type Result struct {
 }
 
 func (r *Result) Winner() tak.Color {
-	switch r.Result {
-	case "R-0", "F-0", "1-0":
-		return tak.White
-	case "0-R", "0-F", "0-1":
-		return tak.Black
-	case "1/2-1/2":
+	res, e := ParseResult(r.Result)
+	if e != nil {
 		return tak.NoColor
 	}
-	return tak.NoColor
+	return res.Winner
+}
+
+type ResultReason int
+
+const (
+	RoadResult ResultReason = iota
+	FlatResult
+	// OtherResult is a win by resignation, time, or any other
+	// means, written as "1-0" or "0-1".
+	OtherResult
+	DrawResult
+)
+
+// A GameResult is the outcome of a game, as recorded in a PTN result
+// string such as "R-0" or "1/2-1/2". Draws have a Winner of NoColor.
+type GameResult struct {
+	Winner tak.Color
+	Reason ResultReason
+}
+
+var resultReasons = map[string]ResultReason{
+	"R": RoadResult,
+	"F": FlatResult,
+	"1": OtherResult,
+}
+
+// ParseResult parses a PTN result string.
+func ParseResult(s string) (GameResult, error) {
+	if s == "1/2-1/2" {
+		return GameResult{Winner: tak.NoColor, Reason: DrawResult}, nil
+	}
+	bits := strings.Split(s, "-")
+	if len(bits) == 2 {
+		if r, ok := resultReasons[bits[0]]; ok && bits[1] == "0" {
+			return GameResult{Winner: tak.White, Reason: r}, nil
+		}
+		if r, ok := resultReasons[bits[1]]; ok && bits[0] == "0" {
+			return GameResult{Winner: tak.Black, Reason: r}, nil
+		}
+	}
+	return GameResult{}, fmt.Errorf("bad result: %q", s)
+}
+
+func (r GameResult) String() string {
+	var kind string
+	switch r.Reason {
+	case RoadResult:
+		kind = "R"
+	case FlatResult:
+		kind = "F"
+	case OtherResult:
+		kind = "1"
+	}
+	switch {
+	case r.Reason == DrawResult || r.Winner == tak.NoColor:
+		return "1/2-1/2"
+	case r.Winner == tak.White:
+		return kind + "-0"
+	default:
+		return "0-" + kind
+	}
+}
+
+// ResultOf returns the result of `p`, which must be a finished
+// game.
+func ResultOf(p *tak.Position) GameResult {
+	d := p.WinDetails()
+	switch {
+	case d.Reason == tak.Draw || d.Winner == tak.NoColor:
+		return GameResult{Winner: tak.NoColor, Reason: DrawResult}
+	case d.Reason == tak.RoadWin:
+		return GameResult{Winner: d.Winner, Reason: RoadResult}
+	case d.Reason == tak.FlatsWin:
+		return GameResult{Winner: d.Winner, Reason: FlatResult}
+	default:
+		return GameResult{Winner: d.Winner, Reason: OtherResult}
+	}
 }
 
 type PTN struct {
@@ -80,8 +171,17 @@ type PTN struct {
 	Ops  []Op
 }
 
+// ParsePTN parses a PTN game from `r`, which may be gzip-compressed.
 func ParsePTN(r io.Reader) (*PTN, error) {
 	buf := bufio.NewReader(r)
+	if magic, _ := buf.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		z, err := gzip.NewReader(buf)
+		if err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		buf = bufio.NewReader(z)
+	}
 	ch, _, err := buf.ReadRune()
 	if err != nil {
 		return nil, err
@@ -117,26 +217,142 @@ func (p *PTN) FindTag(name string) string {
 	return ""
 }
 
-func (p *PTN) InitialPosition() (*tak.Position, error) {
-	sizeTag := p.FindTag("Size")
-	size, e := strconv.Atoi(sizeTag)
+// Result returns the result of the game, from the `Result` tag or,
+// failing that, the result at the end of the moves. It returns nil if
+// the game has no result.
+func (p *PTN) Result() (*GameResult, error) {
+	s := p.FindTag("Result")
+	if s == "" {
+		for i := len(p.Ops) - 1; i >= 0; i-- {
+			if r, ok := p.Ops[i].(*Result); ok {
+				s = r.Result
+				break
+			}
+		}
+	}
+	if s == "" {
+		return nil, nil
+	}
+	r, e := ParseResult(s)
 	if e != nil {
-		return nil, fmt.Errorf("bad size: %s", sizeTag)
+		return nil, e
 	}
-	tps := p.FindTag("TPS")
-	var out *tak.Position
-	if tps == "" {
-		out = tak.New(tak.Config{Size: size})
-	} else {
-		out, e = ParseTPS(tps)
+	return &r, nil
+}
+
+// SetResult records `r` as the result of the game, in both the
+// `Result` tag and at the end of the moves.
+func (p *PTN) SetResult(r GameResult) {
+	s := r.String()
+	found := false
+	for i := range p.Tags {
+		if p.Tags[i].Name == "Result" {
+			p.Tags[i].Value = s
+			found = true
+		}
+	}
+	if !found {
+		p.Tags = append(p.Tags, Tag{Name: "Result", Value: s})
+	}
+	if n := len(p.Ops); n > 0 {
+		if o, ok := p.Ops[n-1].(*Result); ok {
+			o.Result = s
+			return
+		}
+	}
+	p.Ops = append(p.Ops, &Result{Result: s})
+}
+
+// GameConfig returns the game configuration described by the
+// `Size`, `Flats`, `Caps` and `Komi` tags. Missing tags fall back to
+// the defaults for the board size; if there is no `Size` tag, the
+// size is taken from the `TPS` tag.
+func (p *PTN) GameConfig() (tak.Config, error) {
+	size := 0
+	if tag := p.FindTag("Size"); tag != "" {
+		var e error
+		size, e = strconv.Atoi(tag)
 		if e != nil {
-			return nil, fmt.Errorf("bad TPS: %v", e)
+			return tak.Config{}, fmt.Errorf("bad size: %s", tag)
 		}
-		if out.Size() != size {
-			return nil, fmt.Errorf("size mismatch: tag %d != TPS %d",
-				size, out.Size())
+	}
+	if tps := p.FindTag("TPS"); tps != "" {
+		rows := strings.Count(strings.SplitN(tps, " ", 2)[0], "/") + 1
+		if size == 0 {
+			size = rows
+		} else if size != rows {
+			return tak.Config{}, fmt.Errorf("size mismatch: tag %d != TPS %d",
+				size, rows)
 		}
 	}
+	if size < 3 || size > 8 {
+		return tak.Config{}, fmt.Errorf("bad size: %d", size)
+	}
+	cfg := tak.DefaultConfig(size)
+
+	if tag := p.FindTag("Flats"); tag != "" {
+		n, e := strconv.Atoi(tag)
+		if e != nil || n < 1 {
+			return tak.Config{}, fmt.Errorf("bad flats: %s", tag)
+		}
+		cfg.Pieces = n
+	}
+	if tag := p.FindTag("Caps"); tag != "" {
+		n, e := strconv.Atoi(tag)
+		if e != nil || n < 0 {
+			return tak.Config{}, fmt.Errorf("bad caps: %s", tag)
+		}
+		if n == 0 && cfg.Capstones != 0 {
+			return tak.Config{}, fmt.Errorf("size %d without capstones is not supported", size)
+		}
+		cfg.Capstones = n
+	}
+	if tag := p.FindTag("Komi"); tag != "" {
+		n, e := ParseKomi(tag)
+		if e != nil {
+			return tak.Config{}, e
+		}
+		cfg.HalfKomi = n
+	}
+	return cfg, nil
+}
+
+// ParseKomi parses a komi in flats, such as "2" or "2.5", and
+// returns it in half-flats, as tak.Config.HalfKomi holds it.
+func ParseKomi(s string) (int, error) {
+	f, e := strconv.ParseFloat(s, 64)
+	if e != nil || 2*f != math.Trunc(2*f) {
+		return 0, fmt.Errorf("bad komi: %s", s)
+	}
+	return int(2 * f), nil
+}
+
+// FormatKomi formats a komi given in half-flats as ParseKomi parses
+// it.
+func FormatKomi(half int) string {
+	s := strconv.Itoa(half / 2)
+	if half%2 == 0 {
+		return s
+	}
+	if half < 0 && half/2 == 0 {
+		s = "-0"
+	}
+	return s + ".5"
+}
+
+func (p *PTN) InitialPosition() (*tak.Position, error) {
+	cfg, e := p.GameConfig()
+	if e != nil {
+		return nil, e
+	}
+	tps := p.FindTag("TPS")
+	if tps == "" {
+		return tak.New(cfg), nil
+	}
+	out, e := ParseTPSConfig(cfg, tps)
+	if e != nil {
+		return nil, fmt.Errorf("bad TPS: %v", e)
+	}
 	return out, nil
 }
 
@@ -165,6 +381,35 @@ func (p *PTN) PositionAtMove(move int, color tak.Color) (*tak.Position, error) {
 	return it.Position(), nil
 }
 
+// CanonicalGameKey returns a key identifying the sequence of
+// positions in the game, which is the same for any rotation or
+// reflection of it. Games with the same key are duplicates up to
+// symmetry, and differ at most in their tags and comments.
+func (p *PTN) CanonicalGameKey() (string, error) {
+	pos, e := p.InitialPosition()
+	if e != nil {
+		return "", e
+	}
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], pos.CanonicalHash())
+	h.Write(buf[:])
+	plies := 0
+	for _, op := range p.Ops {
+		m, ok := op.(*Move)
+		if !ok {
+			continue
+		}
+		if pos, e = pos.Move(&m.Move); e != nil {
+			return "", fmt.Errorf("move %d: %s: %v", plies+1, FormatMove(&m.Move), e)
+		}
+		plies++
+		binary.LittleEndian.PutUint64(buf[:], pos.CanonicalHash())
+		h.Write(buf[:])
+	}
+	return fmt.Sprintf("%d/%d/%016x", pos.Size(), plies, h.Sum64()), nil
+}
+
 func readEvents(r *bufio.Reader, ptn *PTN) error {
 	for {
 		if e := skipWS(r); e != nil {
@@ -204,7 +449,13 @@ func readMoves(r *bufio.Reader, ptn *PTN) error {
 		common := opCommon{tok}
 		switch {
 		case tok[0] == '{':
-			ptn.Ops = append(ptn.Ops, &Comment{common, tok[1 : len(tok)-1]})
+			c := tok[1 : len(tok)-1]
+			if n := len(ptn.Ops); n > 0 {
+				if m, ok := ptn.Ops[n-1].(*Move); ok {
+					m.TimeLeft, m.HasClock = parseClock(c)
+				}
+			}
+			ptn.Ops = append(ptn.Ops, &Comment{common, c})
 		case tok[len(tok)-1] == '.':
 			n, e := strconv.Atoi(tok[:len(tok)-1])
 			if e != nil {
@@ -213,18 +464,68 @@ func readMoves(r *bufio.Reader, ptn *PTN) error {
 			ptn.Ops = append(ptn.Ops, &MoveNumber{common, n})
 		case resultRE.MatchString(tok):
 			ptn.Ops = append(ptn.Ops, &Result{common, tok})
+		case tok == "--":
+			if !placeholderOK(ptn) {
+				return errors.New("unexpected --: only valid as the first move of a TPS with black to play")
+			}
+			ptn.Ops = append(ptn.Ops, &Placeholder{common})
 		default:
 			trimmed := strings.TrimRight(tok, "?!'")
 			move, e := ParseMove(trimmed)
 			if e != nil {
 				return fmt.Errorf("bad move: %s", trimmed)
 			}
-			ptn.Ops = append(ptn.Ops, &Move{common, move, tok[len(trimmed):]})
+			ptn.Ops = append(ptn.Ops, &Move{opCommon: common, Move: move, Modifiers: tok[len(trimmed):]})
 		}
 	}
 	return s.Err()
 }
 
+// placeholderOK reports whether a `--` may appear next in `ptn`:
+// before any move, in a game rooted at a TPS with black to play.
+func placeholderOK(ptn *PTN) bool {
+	for _, op := range ptn.Ops {
+		switch op.(type) {
+		case *Move, *Placeholder:
+			return false
+		}
+	}
+	bits := strings.Fields(ptn.FindTag("TPS"))
+	return len(bits) == 3 && bits[1] == "2"
+}
+
+var clockRE = regexp.MustCompile(`\[%(?:clk|clock)\s+([0-9:.]+)\s*\]`)
+
+// parseClock extracts the time from a `[%clk H:MM:SS]` or `[%clock
+// H:MM:SS]` annotation in `comment`. Hours are optional, and seconds
+// may be fractional. It returns false if there is no well-formed
+// annotation.
+func parseClock(comment string) (time.Duration, bool) {
+	m := clockRE.FindStringSubmatch(comment)
+	if m == nil {
+		return 0, false
+	}
+	parts := strings.Split(m[1], ":")
+	if len(parts) > 3 {
+		return 0, false
+	}
+	secs, e := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if e != nil {
+		return 0, false
+	}
+	d := time.Duration(secs * float64(time.Second))
+	unit := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		n, e := strconv.Atoi(parts[i])
+		if e != nil {
+			return 0, false
+		}
+		d += time.Duration(n) * unit
+		unit *= 60
+	}
+	return d, true
+}
+
 func splitMoves(buf []byte, atEOF bool) (int, []byte, error) {
 	start := 0
 	for start < len(buf) && unicode.IsSpace(rune(buf[start])) {
@@ -279,6 +580,8 @@ func (p *PTN) Render() string {
 			fmt.Fprintf(&out, "\n%d.", o.Number)
 		case *Move:
 			fmt.Fprintf(&out, " %s%s", FormatMove(&o.Move), o.Modifiers)
+		case *Placeholder:
+			out.WriteString(" --")
 		case *Comment:
 			fmt.Fprintf(&out, " {%s}", o.Comment)
 		case *Result:
@@ -2,9 +2,14 @@ package ptn
 
 import (
 	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nelhage/taktician/tak"
 )
@@ -100,6 +105,44 @@ func TestParsePTNBOM(t *testing.T) {
 	}
 }
 
+func TestParsePTNGzip(t *testing.T) {
+	var buf bytes.Buffer
+	z := gzip.NewWriter(&buf)
+	z.Write([]byte(testGame))
+	if err := z.Close(); err != nil {
+		t.Fatal("gzip:", err)
+	}
+
+	want, err := ParsePTN(bytes.NewBufferString(testGame))
+	if err != nil {
+		t.Fatal("parse:", err)
+	}
+
+	dir, err := ioutil.TempDir("", "ptn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "game.ptn.gz")
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParsePTN(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("parse gzip:", err)
+	}
+	fromFile, err := ParseFile(file)
+	if err != nil {
+		t.Fatal("parse file:", err)
+	}
+	for _, p := range []*PTN{got, fromFile} {
+		if !reflect.DeepEqual(p.Tags, want.Tags) || !reflect.DeepEqual(p.Ops, want.Ops) {
+			t.Errorf("gzipped game parsed differently: %+v", p)
+		}
+	}
+}
+
 func TestRoundTripPTN(t *testing.T) {
 	ptn, err := ParsePTN(bytes.NewBufferString(testGame))
 	if err != nil {
@@ -214,3 +257,308 @@ func TestPositionAtMove(t *testing.T) {
 	}
 
 }
+
+func TestGameConfig(t *testing.T) {
+	cases := []struct {
+		tags []Tag
+		cfg  tak.Config
+		err  bool
+	}{
+		{[]Tag{{"Size", "5"}}, tak.Config{Size: 5, Pieces: 21, Capstones: 1}, false},
+		{[]Tag{{"Size", "6"}, {"Komi", "2"}}, tak.Config{Size: 6, Pieces: 30, Capstones: 1, HalfKomi: 4}, false},
+		{[]Tag{{"Size", "6"}, {"Komi", "2.5"}}, tak.Config{Size: 6, Pieces: 30, Capstones: 1, HalfKomi: 5}, false},
+		{[]Tag{{"Size", "6"}, {"Flats", "32"}, {"Caps", "2"}}, tak.Config{Size: 6, Pieces: 32, Capstones: 2}, false},
+		{[]Tag{{"Size", "4"}, {"Caps", "0"}}, tak.Config{Size: 4, Pieces: 15}, false},
+		{[]Tag{{"TPS", "x4/x4/x4/x4 1 1"}}, tak.Config{Size: 4, Pieces: 15}, false},
+		{nil, tak.Config{}, true},
+		{[]Tag{{"Size", "9"}}, tak.Config{}, true},
+		{[]Tag{{"Size", "5"}, {"Komi", "1.25"}}, tak.Config{}, true},
+		{[]Tag{{"Size", "5"}, {"Komi", "two"}}, tak.Config{}, true},
+		{[]Tag{{"Size", "5"}, {"Flats", "none"}}, tak.Config{}, true},
+		{[]Tag{{"Size", "6"}, {"Caps", "0"}}, tak.Config{}, true},
+		{[]Tag{{"Size", "5"}, {"TPS", "x4/x4/x4/x4 1 1"}}, tak.Config{}, true},
+	}
+	for _, tc := range cases {
+		p := &PTN{Tags: tc.tags}
+		cfg, e := p.GameConfig()
+		if tc.err {
+			if e == nil {
+				t.Errorf("GameConfig(%v): no error", tc.tags)
+			}
+			continue
+		}
+		if e != nil {
+			t.Errorf("GameConfig(%v): %v", tc.tags, e)
+			continue
+		}
+		if cfg != tc.cfg {
+			t.Errorf("GameConfig(%v)=%+v != %+v", tc.tags, cfg, tc.cfg)
+		}
+	}
+}
+
+func TestInitialPositionKomi(t *testing.T) {
+	// The board is full, and the flats are level.
+	for _, tc := range []struct {
+		komi   string
+		winner tak.Color
+	}{
+		{"2", tak.Black},
+		{"0.5", tak.Black},
+		{"-0.5", tak.White},
+	} {
+		p, e := ParsePTN(bytes.NewBufferString(`[Size "4"]
+[Komi "` + tc.komi + `"]
+[TPS "1,2,1,2/2,1,2,1/1,2,1,2/x,1,2,1 2 8"]
+
+8. a1
+`))
+		if e != nil {
+			t.Fatalf("komi %s: parse: %v", tc.komi, e)
+		}
+		pos, e := p.PositionAtMove(0, tak.NoColor)
+		if e != nil {
+			t.Fatalf("komi %s: position: %v", tc.komi, e)
+		}
+		if ok, winner := pos.GameOver(); !ok || winner != tc.winner {
+			t.Errorf("komi %s: GameOver()=%v,%s, want a win for %s",
+				tc.komi, ok, winner, tc.winner)
+		}
+	}
+}
+
+func TestFormatKomi(t *testing.T) {
+	for _, s := range []string{"0", "2", "2.5", "-0.5", "-3.5"} {
+		half, e := ParseKomi(s)
+		if e != nil {
+			t.Errorf("ParseKomi(%q): %v", s, e)
+			continue
+		}
+		if got := FormatKomi(half); got != s {
+			t.Errorf("FormatKomi(%d)=%q, want %q", half, got, s)
+		}
+	}
+}
+
+func TestTPSRootedGame(t *testing.T) {
+	const tps = "x3,2,x/x,1,x3/x2,12,x2/x,2,1,x2/x5 2 5"
+	src := `[Size "5"]
+[TPS "` + tps + `"]
+
+5. -- c4
+6. c2+ c4-
+7. b4> Sd3
+`
+	p, e := ParsePTN(bytes.NewBufferString(src))
+	if e != nil {
+		t.Fatal("parse:", e)
+	}
+	init, e := p.InitialPosition()
+	if e != nil {
+		t.Fatal("initial:", e)
+	}
+	if got := FormatTPS(init); got != tps {
+		t.Fatalf("initial=%s != %s", got, tps)
+	}
+
+	replay := init
+	for _, m := range []string{"c4", "c2+", "c4-", "b4>", "Sd3"} {
+		mv, e := ParseMove(m)
+		if e != nil {
+			t.Fatalf("parse %s: %v", m, e)
+		}
+		if replay, e = replay.Move(&mv); e != nil {
+			t.Fatalf("move %s: %v", m, e)
+		}
+	}
+
+	final, e := p.PositionAtMove(0, tak.NoColor)
+	if e != nil {
+		t.Fatal("final:", e)
+	}
+	const expect = "x3,2,x/x2,1,x2/x2,1212,2S,x/x,2,x3/x5 1 8"
+	if got := FormatTPS(final); got != expect {
+		t.Errorf("final=%s != %s", got, expect)
+	}
+	if final.Hash() != replay.Hash() {
+		t.Errorf("final=%s != replay=%s", FormatTPS(final), FormatTPS(replay))
+	}
+
+	mid, e := p.PositionAtMove(6, tak.Black)
+	if e != nil {
+		t.Fatal("move 6:", e)
+	}
+	if got := FormatTPS(mid); got != "x3,2,x/x,1,2,x2/x2,121,x2/x,2,x3/x5 2 6" {
+		t.Errorf("move 6=%s", got)
+	}
+
+	out := p.Render()
+	if !strings.Contains(out, "\n5. -- c4\n") {
+		t.Errorf("render lost the placeholder:\n%s", out)
+	}
+	again, e := ParsePTN(bytes.NewBufferString(out))
+	if e != nil {
+		t.Fatal("reparse:", e)
+	}
+	if got := again.Render(); got != out {
+		t.Errorf("round-trip:\n%s\n!=\n%s", got, out)
+	}
+}
+
+func TestBadPlaceholder(t *testing.T) {
+	const black = `[TPS "x5/x5/x5/x5/x5 2 5"]`
+	cases := []struct {
+		name string
+		src  string
+	}{
+		{"no TPS", "1. -- a1\n"},
+		{"white to move", `[TPS "x5/x5/x5/x5/x5 1 5"]` + "\n\n5. -- a1\n"},
+		{"after a move", black + "\n\n5. a1 --\n"},
+		{"twice", black + "\n\n5. -- --\n"},
+	}
+	for _, tc := range cases {
+		_, e := ParsePTN(bytes.NewBufferString(tc.src))
+		if e == nil || !strings.Contains(e.Error(), "--") {
+			t.Errorf("%s: got err=%v, want a -- error", tc.name, e)
+		}
+	}
+}
+
+func TestResult(t *testing.T) {
+	cases := []struct {
+		in  string
+		out GameResult
+	}{
+		{"R-0", GameResult{tak.White, RoadResult}},
+		{"0-R", GameResult{tak.Black, RoadResult}},
+		{"F-0", GameResult{tak.White, FlatResult}},
+		{"0-F", GameResult{tak.Black, FlatResult}},
+		{"1-0", GameResult{tak.White, OtherResult}},
+		{"0-1", GameResult{tak.Black, OtherResult}},
+		{"1/2-1/2", GameResult{tak.NoColor, DrawResult}},
+	}
+	for _, tc := range cases {
+		got, e := ParseResult(tc.in)
+		if e != nil {
+			t.Errorf("ParseResult(%q): %v", tc.in, e)
+			continue
+		}
+		if got != tc.out {
+			t.Errorf("ParseResult(%q)=%+v, want %+v", tc.in, got, tc.out)
+		}
+		if s := got.String(); s != tc.in {
+			t.Errorf("String(%+v)=%q, want %q", got, s, tc.in)
+		}
+
+		var p PTN
+		p.AddMoves([]tak.Move{{X: 0, Y: 0, Type: tak.PlaceFlat}})
+		p.SetResult(tc.out)
+		back, e := ParsePTN(strings.NewReader(p.Render()))
+		if e != nil {
+			t.Fatalf("parse(%q): %v", p.Render(), e)
+		}
+		if r, e := back.Result(); e != nil || r == nil || *r != tc.out {
+			t.Errorf("round trip %q: got %v, %v", tc.in, r, e)
+		}
+		if res, ok := back.Ops[len(back.Ops)-1].(*Result); !ok || res.Result != tc.in {
+			t.Errorf("round trip %q: ops=%v", tc.in, back.Ops)
+		}
+	}
+
+	for _, bad := range []string{"", "0-0", "R-F", "1-1", "It Works!"} {
+		if _, e := ParseResult(bad); e == nil {
+			t.Errorf("ParseResult(%q): no error", bad)
+		}
+	}
+
+	var p PTN
+	if r, e := p.Result(); r != nil || e != nil {
+		t.Errorf("no result: got %v, %v", r, e)
+	}
+	p.Ops = append(p.Ops, &Result{Result: "0-R"})
+	if r, e := p.Result(); e != nil || r == nil || *r != (GameResult{tak.Black, RoadResult}) {
+		t.Errorf("result from moves: got %v, %v", r, e)
+	}
+}
+
+func TestCanonicalGameKey(t *testing.T) {
+	cases := []struct {
+		a, b string
+		same bool
+	}{
+		{"1. a1 e5 2. c3 b4 3. c2 c4", "1. a1 e5 2. c3 b4 3. c2 c4", true},
+		// reflected left to right
+		{"1. a1 e5 2. c3 b4 3. c2 c4", "1. e1 a5 2. c3 d4 3. c2 c4", true},
+		// reflected in the diagonal, with the slide direction
+		{"1. a1 e5 2. c3 b4 3. c3> d4", "1. a1 e5 2. c3 d2 3. c3+ d4", true},
+		// rotated a quarter turn
+		{"1. a2 e5 2. c3 b3", "1. b5 e1 2. c3 c4", true},
+		{"1. a1 e5 2. c3 b4 3. c2 c4", "1. a1 e5 2. c3 b4 3. c2 d4", false},
+		{"1. a1 e5 2. c3 b4", "1. a1 e5 2. c3 b4 3. c2", false},
+		{"1. a1 e5 2. c3 b4", "1. a1 e5 2. Sc3 b4", false},
+	}
+	for _, tc := range cases {
+		var keys [2]string
+		for i, src := range []string{tc.a, tc.b} {
+			g, e := ParsePTN(bytes.NewBufferString("[Size \"5\"]\n\n" + src))
+			if e != nil {
+				t.Fatalf("parse %q: %v", src, e)
+			}
+			if keys[i], e = g.CanonicalGameKey(); e != nil {
+				t.Fatalf("key %q: %v", src, e)
+			}
+		}
+		if (keys[0] == keys[1]) != tc.same {
+			t.Errorf("%q=%s, %q=%s, want same=%v", tc.a, keys[0], tc.b, keys[1], tc.same)
+		}
+	}
+
+	g, e := ParsePTN(bytes.NewBufferString("[Size \"5\"]\n\n1. a1 e5 2. a1"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := g.CanonicalGameKey(); e == nil {
+		t.Errorf("illegal game: no error")
+	}
+}
+
+const timedGame = `
+[Size "5"]
+[Clock "10:00 +5"]
+
+1. a1 {[%clk 0:09:58]} e5 {[%clk 0:09:59.5]}
+2. b1 {a fine move} c3 {[%clock 9:41]}
+3. c1 {[%clk bogus]} d3
+`
+
+func TestParseClock(t *testing.T) {
+	p, err := ParsePTN(bytes.NewBufferString(timedGame))
+	if err != nil {
+		t.Fatal("parse:", err)
+	}
+	type clock struct {
+		left time.Duration
+		ok   bool
+	}
+	want := []clock{
+		{9*time.Minute + 58*time.Second, true},
+		{9*time.Minute + 59500*time.Millisecond, true},
+		{0, false},
+		{9*time.Minute + 41*time.Second, true},
+		{0, false},
+		{0, false},
+	}
+	var got []clock
+	for _, o := range p.Ops {
+		if m, ok := o.(*Move); ok {
+			got = append(got, clock{m.TimeLeft, m.HasClock})
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("clocks=%v, want %v", got, want)
+	}
+
+	if c := p.Ops[2].(*Comment); c.Comment != "[%clk 0:09:58]" {
+		t.Errorf("clock comment not preserved: %q", c.Comment)
+	}
+}
@@ -10,21 +10,44 @@ import (
 )
 
 func ParseTPS(tpn string) (*tak.Position, error) {
+	pieces, move, err := parseTPS(tpn)
+	if err != nil {
+		return nil, err
+	}
+	return tak.FromSquares(tak.Config{Size: len(pieces)}, pieces, move)
+}
+
+// ParseTPSConfig parses a TPS string into a position using the
+// specified game configuration, which must agree with the TPS on the
+// board size.
+func ParseTPSConfig(cfg tak.Config, tpn string) (*tak.Position, error) {
+	pieces, move, err := parseTPS(tpn)
+	if err != nil {
+		return nil, err
+	}
+	if len(pieces) != cfg.Size {
+		return nil, fmt.Errorf("size mismatch: config %d != TPS %d",
+			cfg.Size, len(pieces))
+	}
+	return tak.FromSquares(cfg, pieces, move)
+}
+
+func parseTPS(tpn string) ([][]tak.Square, int, error) {
 	var pieces [][]tak.Square
 	words := strings.Split(tpn, " ")
 	if len(words) != 3 {
-		return nil, errors.New("bad TPN: wrong number of words")
+		return nil, 0, errors.New("bad TPN: wrong number of words")
 	}
 	turn, err := strconv.Atoi(words[1])
 	if err != nil {
-		return nil, fmt.Errorf("bad turn: %s", words[1])
+		return nil, 0, fmt.Errorf("bad turn: %s", words[1])
 	}
 	if turn != 1 && turn != 2 {
-		return nil, fmt.Errorf("bad turn: %s", words[1])
+		return nil, 0, fmt.Errorf("bad turn: %s", words[1])
 	}
 	move, err := strconv.Atoi(words[2])
 	if err != nil {
-		return nil, fmt.Errorf("bad move: %s", words[2])
+		return nil, 0, fmt.Errorf("bad move: %s", words[2])
 	}
 	move = 2*(move-1) + (turn - 1)
 
@@ -32,19 +55,19 @@ func ParseTPS(tpn string) (*tak.Position, error) {
 	for _, r := range rows {
 		row, err := parseRow(r)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		pieces = append([][]tak.Square{row}, pieces...)
 	}
 	if len(pieces) < 3 || len(pieces) > 8 {
-		return nil, fmt.Errorf("bad size board: %d", len(pieces))
+		return nil, 0, fmt.Errorf("bad size board: %d", len(pieces))
 	}
 	for i, r := range pieces {
 		if len(r) != len(pieces) {
-			return nil, fmt.Errorf("row %d bad length: %d", i, len(r))
+			return nil, 0, fmt.Errorf("row %d bad length: %d", i, len(r))
 		}
 	}
-	return tak.FromSquares(tak.Config{Size: len(pieces)}, pieces, move)
+	return pieces, move, nil
 }
 
 func FormatTPS(p *tak.Position) string {
@@ -0,0 +1,119 @@
+package ptn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+// MaxTreeDepth is the deepest tree ExportTree will write; the number
+// of positions grows far too quickly past it for a useful drawing.
+const MaxTreeDepth = 4
+
+// A TreeFormat selects the output of ExportTree.
+type TreeFormat int
+
+const (
+	// TreeDOT writes a Graphviz digraph.
+	TreeDOT TreeFormat = iota
+	// TreeJSON writes an object of "nodes" and "edges".
+	TreeJSON
+)
+
+type treeNode struct {
+	ID    int    `json:"id"`
+	TPS   string `json:"tps"`
+	Depth int    `json:"depth"`
+	Over  bool   `json:"over"`
+}
+
+type treeEdge struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Move string `json:"move"`
+}
+
+// ExportTree writes the tree of legal moves from `p`, to `depth`
+// plies, to `w`. Nodes are labeled with the TPS of their position and
+// edges with their move. Positions reached by more than one sequence
+// of moves are written once, identified by their Hash, so the result
+// is a directed acyclic graph. Finished games are not expanded.
+func ExportTree(p *tak.Position, depth int, w io.Writer, format TreeFormat) error {
+	if depth < 0 || depth > MaxTreeDepth {
+		return fmt.Errorf("tree depth %d: must be between 0 and %d", depth, MaxTreeDepth)
+	}
+	nodes, edges := buildTree(p, depth)
+	switch format {
+	case TreeDOT:
+		return writeDOT(w, nodes, edges)
+	case TreeJSON:
+		return json.NewEncoder(w).Encode(struct {
+			Nodes []treeNode `json:"nodes"`
+			Edges []treeEdge `json:"edges"`
+		}{nodes, edges})
+	default:
+		return fmt.Errorf("unknown tree format %d", format)
+	}
+}
+
+// buildTree expands the tree breadth-first, so that each position is
+// expanded once, at the shallowest depth it is reached.
+func buildTree(root *tak.Position, depth int) ([]treeNode, []treeEdge) {
+	ids := map[uint64]int{root.Hash(): 0}
+	over, _ := root.GameOver()
+	nodes := []treeNode{{ID: 0, TPS: FormatTPS(root), Over: over}}
+	var edges []treeEdge
+	level := []*tak.Position{root}
+	for d := 1; d <= depth; d++ {
+		var next []*tak.Position
+		for _, p := range level {
+			if over, _ := p.GameOver(); over {
+				continue
+			}
+			from := ids[p.Hash()]
+			for _, m := range p.AllMoves(nil) {
+				child, _ := p.Move(&m)
+				id, ok := ids[child.Hash()]
+				if !ok {
+					id = len(nodes)
+					ids[child.Hash()] = id
+					over, _ := child.GameOver()
+					nodes = append(nodes, treeNode{
+						ID: id, TPS: FormatTPS(child), Depth: d, Over: over,
+					})
+					next = append(next, child)
+				}
+				edges = append(edges, treeEdge{From: from, To: id, Move: FormatMove(&m)})
+			}
+		}
+		level = next
+	}
+	return nodes, edges
+}
+
+func writeDOT(w io.Writer, nodes []treeNode, edges []treeEdge) error {
+	if _, e := fmt.Fprintln(w, "digraph tak {"); e != nil {
+		return e
+	}
+	for _, n := range nodes {
+		shape := "box"
+		if n.Over {
+			shape = "doubleoctagon"
+		}
+		if _, e := fmt.Fprintf(w, "  n%d [label=%s shape=%s];\n",
+			n.ID, strconv.Quote(n.TPS), shape); e != nil {
+			return e
+		}
+	}
+	for _, ed := range edges {
+		if _, e := fmt.Fprintf(w, "  n%d -> n%d [label=%s];\n",
+			ed.From, ed.To, strconv.Quote(ed.Move)); e != nil {
+			return e
+		}
+	}
+	_, e := fmt.Fprintln(w, "}")
+	return e
+}
@@ -0,0 +1,90 @@
+package ptn
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+// treeSize counts the distinct positions within `depth` plies of
+// `p`, and the legal moves out of those less than `depth` plies from
+// it, by depth-first search.
+func treeSize(p *tak.Position, depth int) (nodes, edges int) {
+	type visit struct {
+		p *tak.Position
+		d int
+	}
+	seen := make(map[uint64]visit)
+	var walk func(p *tak.Position, d int)
+	walk = func(p *tak.Position, d int) {
+		if old, ok := seen[p.Hash()]; ok && old.d <= d {
+			return
+		}
+		seen[p.Hash()] = visit{p, d}
+		if over, _ := p.GameOver(); over || d == depth {
+			return
+		}
+		for _, m := range p.AllMoves(nil) {
+			if child, e := p.Move(&m); e == nil {
+				walk(child, d+1)
+			}
+		}
+	}
+	walk(p, 0)
+	for _, v := range seen {
+		if over, _ := v.p.GameOver(); over || v.d == depth {
+			continue
+		}
+		for _, m := range v.p.AllMoves(nil) {
+			if _, e := v.p.Move(&m); e == nil {
+				edges++
+			}
+		}
+	}
+	return len(seen), edges
+}
+
+func TestExportTree(t *testing.T) {
+	p, e := ParseTPS(`x3/2,1,2/1,2,1 1 4`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for depth := 0; depth <= 3; depth++ {
+		var dot, js bytes.Buffer
+		if e := ExportTree(p, depth, &dot, TreeDOT); e != nil {
+			t.Fatalf("depth=%d: %v", depth, e)
+		}
+		if e := ExportTree(p, depth, &js, TreeJSON); e != nil {
+			t.Fatalf("depth=%d: %v", depth, e)
+		}
+		var tree struct {
+			Nodes []treeNode
+			Edges []treeEdge
+		}
+		if e := json.Unmarshal(js.Bytes(), &tree); e != nil {
+			t.Fatalf("depth=%d: json: %v", depth, e)
+		}
+		nodes, edges := treeSize(p, depth)
+		if len(tree.Nodes) != nodes || len(tree.Edges) != edges {
+			t.Errorf("depth=%d: %d nodes and %d edges, want %d and %d",
+				depth, len(tree.Nodes), len(tree.Edges), nodes, edges)
+		}
+		if n := strings.Count(dot.String(), " -> "); n != len(tree.Edges) {
+			t.Errorf("depth=%d: dot has %d edges, json %d", depth, n, len(tree.Edges))
+		}
+		if n := strings.Count(dot.String(), "[label=") - len(tree.Edges); n != nodes {
+			t.Errorf("depth=%d: dot has %d nodes, want %d", depth, n, nodes)
+		}
+		if depth == 3 && len(tree.Edges) < len(tree.Nodes) {
+			t.Errorf("depth=3: no transpositions: %d nodes, %d edges",
+				len(tree.Nodes), len(tree.Edges))
+		}
+	}
+
+	if e := ExportTree(p, MaxTreeDepth+1, &bytes.Buffer{}, TreeDOT); e == nil {
+		t.Errorf("depth past MaxTreeDepth: no error")
+	}
+}
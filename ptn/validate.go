@@ -0,0 +1,83 @@
+package ptn
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+var errMoveAfterEnd = errors.New("move after end of game")
+
+// MoveError describes an illegal move encountered while replaying a
+// PTN.
+type MoveError struct {
+	// Ply is the ply number (starting from 0) at which the move
+	// was played.
+	Ply int
+	// Move is the move as written in the PTN.
+	Move string
+	// Err is the reason the move was rejected.
+	Err error
+	// TPS is the position the move was played in.
+	TPS string
+}
+
+func (e *MoveError) Error() string {
+	return fmt.Sprintf("ply %d: %s: %v [TPS \"%s\"]", e.Ply, e.Move, e.Err, e.TPS)
+}
+
+// Validate replays every move in the PTN against a position built
+// from `cfg` and the game's `TPS` tag, if any, and returns a
+// *MoveError for each illegal move. If `cfg.Size` is zero, the
+// configuration is read from the PTN tags.
+//
+// Illegal moves are skipped and replay continues, so errors after the
+// first may be consequences of an earlier one.
+func (p *PTN) Validate(cfg tak.Config) []error {
+	if cfg.Size == 0 {
+		var e error
+		if cfg, e = p.GameConfig(); e != nil {
+			return []error{e}
+		}
+	}
+	var pos *tak.Position
+	if tps := p.FindTag("TPS"); tps != "" {
+		var e error
+		if pos, e = ParseTPSConfig(cfg, tps); e != nil {
+			return []error{fmt.Errorf("bad TPS: %v", e)}
+		}
+	} else {
+		pos = tak.New(cfg)
+	}
+
+	var errs []error
+	for _, op := range p.Ops {
+		m, ok := op.(*Move)
+		if !ok {
+			continue
+		}
+		text := m.Source()
+		if text == "" {
+			text = FormatMove(&m.Move) + m.Modifiers
+		}
+		var e error
+		var next *tak.Position
+		if over, _ := pos.GameOver(); over {
+			e = errMoveAfterEnd
+		} else {
+			next, e = pos.Move(&m.Move)
+		}
+		if e != nil {
+			errs = append(errs, &MoveError{
+				Ply:  pos.MoveNumber(),
+				Move: text,
+				Err:  e,
+				TPS:  FormatTPS(pos),
+			})
+			continue
+		}
+		pos = next
+	}
+	return errs
+}
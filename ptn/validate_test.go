@@ -0,0 +1,49 @@
+package ptn
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nelhage/taktician/tak"
+)
+
+func TestValidate(t *testing.T) {
+	good, e := ParsePTN(bytes.NewBufferString(testGame))
+	if e != nil {
+		t.Fatal("parse:", e)
+	}
+	if errs := good.Validate(tak.Config{}); len(errs) != 0 {
+		t.Errorf("valid game: %v", errs)
+	}
+
+	bad, e := ParsePTN(bytes.NewBufferString(`[Size "5"]
+
+1. e5 a1
+2. a1 b1
+3. e4 c1
+4. e3 d1
+5. e2 e1
+6. d2
+`))
+	if e != nil {
+		t.Fatal("parse:", e)
+	}
+	errs := bad.Validate(tak.Config{Size: 5})
+	expect := []MoveError{
+		{Ply: 2, Move: "a1", Err: tak.ErrOccupied, TPS: "x4,2/x5/x5/x5/1,x4 1 2"},
+		{Ply: 9, Move: "d2", Err: errMoveAfterEnd, TPS: "x4,2/x4,2/x4,2/x4,2/1,1,1,1,1 2 5"},
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("Validate()=%v, want %d errors", errs, len(expect))
+	}
+	for i, e := range errs {
+		me, ok := e.(*MoveError)
+		if !ok {
+			t.Errorf("[%d] not a *MoveError: %v", i, e)
+			continue
+		}
+		if *me != expect[i] {
+			t.Errorf("[%d] %#v != %#v", i, *me, expect[i])
+		}
+	}
+}
@@ -132,6 +132,6 @@ func copyPosition(p *Position, out *Position) {
 }
 
 func Alloc(size int) *Position {
-	p := Position{cfg: &Config{Size: size}}
+	p := Position{cfg: &Config{Size: size, CarryLimit: size}}
 	return alloc(&p)
 }
@@ -0,0 +1,89 @@
+package tak
+
+import "github.com/nelhage/taktician/bitboard"
+
+// MoveEffect describes what a move changed on the board. Squares are
+// given as bitmasks, with square (x, y) at bit x+y*size.
+type MoveEffect struct {
+	// Touched is the set of squares whose stacks changed: the
+	// square placed on, or the origin and every square dropped on
+	// by a slide.
+	Touched uint64
+	// Flattened is the set of walls flattened by a capstone.
+	Flattened uint64
+	// Changed is the set of squares whose top piece changed
+	// color, including squares that became empty or occupied.
+	Changed uint64
+	// CapturedWhite and CapturedBlack count the white and black
+	// stones that a slide newly left beneath a top of the other
+	// color, on the squares it dropped on.
+	CapturedWhite, CapturedBlack int
+	// Drops lists, for a slide, each square it dropped on, in
+	// the order it dropped on them.
+	Drops []Drop
+}
+
+// A Drop records the stones a slide dropped on one square.
+type Drop struct {
+	X, Y int
+	// Stones are the stones dropped, top first, as At would
+	// report them.
+	Stones Square
+}
+
+// MoveVerbose makes a move as Move does, and also reports its effect
+// on the board.
+func (p *Position) MoveVerbose(m *Move) (*Position, MoveEffect, error) {
+	next, e := p.Move(m)
+	if e != nil {
+		return nil, MoveEffect{}, e
+	}
+	var eff MoveEffect
+	for i := range p.Height {
+		if p.Height[i] != next.Height[i] || p.Stacks[i] != next.Stacks[i] {
+			eff.Touched |= 1 << uint(i)
+		}
+	}
+	eff.Changed = (p.White ^ next.White) | (p.Black ^ next.Black)
+	eff.Flattened = p.Standing &^ next.Standing & next.Caps
+	if !m.IsSlide() {
+		return next, eff, nil
+	}
+	var dx, dy int
+	for _, d := range slideDirs {
+		if d.t == m.Type {
+			dx, dy = d.dx, d.dy
+		}
+	}
+	x, y := m.X, m.Y
+	for _, c := range m.Slides {
+		x, y = x+dx, y+dy
+		i := uint(x + y*p.Size())
+		eff.Drops = append(eff.Drops, Drop{
+			X: x, Y: y, Stones: next.At(x, y)[:c],
+		})
+		w0, b0 := p.prisoners(i)
+		w1, b1 := next.prisoners(i)
+		if w1 > w0 {
+			eff.CapturedWhite += w1 - w0
+		}
+		if b1 > b0 {
+			eff.CapturedBlack += b1 - b0
+		}
+	}
+	return next, eff, nil
+}
+
+// prisoners counts the white and black stones on square `i` that lie
+// beneath a top of the other color.
+func (p *Position) prisoners(i uint) (white, black int) {
+	h := uint(p.Height[i])
+	if h < 2 {
+		return 0, 0
+	}
+	below := bitboard.Popcount(p.Stacks[i] & (1<<(h-1) - 1))
+	if p.White&(1<<i) != 0 {
+		return 0, below
+	}
+	return int(h) - 1 - below, 0
+}
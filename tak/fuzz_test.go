@@ -0,0 +1,50 @@
+package tak
+
+import "testing"
+
+// FuzzMoveRoundtrip interprets its input as a game: the first byte
+// picks the board size, and each later byte picks one of the moves
+// generated in the current position. Illegal picks are skipped.
+// After every move, the position must pass checkPosition, the
+// position it was played from must be unchanged, and GameOver must
+// agree with a copy of the position.
+func FuzzMoveRoundtrip(f *testing.F) {
+	f.Add([]byte{2, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	f.Add([]byte{0, 3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5, 8, 9, 7, 9, 3, 2, 3, 8})
+	f.Add([]byte{5, 255, 128, 64, 32, 16, 8, 4, 2, 1, 0, 200, 100, 50, 25})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		p := New(DefaultConfig(3 + int(data[0])%6))
+		var buf []Move
+		for i, b := range data[1:] {
+			if over, _ := p.GameOver(); over {
+				break
+			}
+			before := p.Clone()
+			buf = p.AllMoves(buf[:0])
+			m := buf[int(b)%len(buf)]
+			next, e := p.Move(&m)
+			if e != nil {
+				continue
+			}
+			if !p.Equal(before) || p.Hash() != before.Hash() {
+				t.Fatalf("move %d (%+v) modified its parent", i, m)
+			}
+			if e := checkPosition(next); e != nil {
+				t.Fatalf("move %d (%+v): %v", i, m, e)
+			}
+			over, winner := next.GameOver()
+			if o, w := next.Clone().GameOver(); o != over || w != winner {
+				t.Fatalf("move %d (%+v): GameOver()=%v,%s, clone=%v,%s",
+					i, m, over, winner, o, w)
+			}
+			if o, w := next.GameOver(); o != over || w != winner {
+				t.Fatalf("move %d (%+v): GameOver changed from %v,%s to %v,%s",
+					i, m, over, winner, o, w)
+			}
+			p = next
+		}
+	})
+}
@@ -11,11 +11,37 @@ type Config struct {
 	Pieces    int
 	Capstones int
 
+	// HalfKomi is the komi awarded to Black when the game is
+	// decided by a flat count, in half-flats: a komi of 2.5 flats
+	// is a HalfKomi of 5. An odd HalfKomi rules out a drawn flat
+	// count.
+	HalfKomi int
+
+	// CarryLimit is the most stones a slide may pick up. Zero,
+	// or any value above Size, means Size, as in the standard
+	// rules.
+	CarryLimit int
+
+	// NoRoads plays the flats-only variant, in which completing
+	// a road does not end the game, and every game is decided
+	// by a flat count.
+	NoRoads bool
+
 	c bitboard.Constants
 }
 
 var defaultPieces = []int{0, 0, 0, 10, 15, 21, 30, 40, 50}
-var defaultCaps = []int{0, 0, 0, 0, 0, 1, 1, 1, 2}
+var defaultCaps = []int{0, 0, 0, 0, 0, 1, 1, 2, 2}
+
+// DefaultConfig returns the standard game configuration for a board
+// of the specified size.
+func DefaultConfig(size int) Config {
+	return Config{
+		Size:      size,
+		Pieces:    defaultPieces[size],
+		Capstones: defaultCaps[size],
+	}
+}
 
 func New(g Config) *Position {
 	if g.Pieces == 0 {
@@ -24,6 +50,9 @@ func New(g Config) *Position {
 	if g.Capstones == 0 {
 		g.Capstones = defaultCaps[g.Size]
 	}
+	if g.CarryLimit <= 0 || g.CarryLimit > g.Size {
+		g.CarryLimit = g.Size
+	}
 	g.c = bitboard.Precompute(uint(g.Size))
 	p := alloc(&Position{
 		cfg:         &g,
@@ -38,8 +67,43 @@ func New(g Config) *Position {
 	return p
 }
 
+// Clone returns a copy of `p`, including its analysis, that shares
+// no storage with it.
 func (p *Position) Clone() *Position {
-	return alloc(p)
+	c := alloc(p)
+	c.analysis.WhiteGroups = append(c.analysis.WhiteGroups, p.analysis.WhiteGroups...)
+	alloc := c.analysis.WhiteGroups
+	alloc = alloc[len(alloc):len(alloc):cap(alloc)]
+	c.analysis.BlackGroups = append(alloc, p.analysis.BlackGroups...)
+	return c
+}
+
+// Equal reports whether `p` and `o` are the same position: the same
+// pieces on the board and in reserve, at the same move number.
+func (p *Position) Equal(o *Position) bool {
+	if p.Size() != o.Size() || p.move != o.move {
+		return false
+	}
+	if p.whiteStones != o.whiteStones || p.whiteCaps != o.whiteCaps ||
+		p.blackStones != o.blackStones || p.blackCaps != o.blackCaps {
+		return false
+	}
+	if p.White != o.White || p.Black != o.Black ||
+		p.Standing != o.Standing || p.Caps != o.Caps {
+		return false
+	}
+	for i := range p.Height {
+		if p.Height[i] != o.Height[i] {
+			return false
+		}
+		if p.Height[i] > 1 {
+			mask := uint64(1)<<(p.Height[i]-1) - 1
+			if p.Stacks[i]&mask != o.Stacks[i]&mask {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 type Square []Piece
@@ -68,6 +132,13 @@ type Position struct {
 type Analysis struct {
 	WhiteGroups []uint64
 	BlackGroups []uint64
+
+	// The squares topped by each kind of piece, by color. For
+	// each color, the flat, wall and capstone masks partition
+	// the squares it controls.
+	WhiteFlat, BlackFlat uint64
+	WhiteWall, BlackWall uint64
+	WhiteCap, BlackCap   uint64
 }
 
 // FromSquares initializes a Position with the specified squares and
@@ -96,18 +167,23 @@ func FromSquares(cfg Config, board [][]Square, move int) (*Position, error) {
 				p.Standing |= (1 << i)
 			}
 			for j, piece := range sq {
+				var stones *byte
 				switch piece {
 				case MakePiece(White, Capstone):
-					p.whiteCaps--
+					stones = &p.whiteCaps
 				case MakePiece(Black, Capstone):
-					p.blackCaps--
+					stones = &p.blackCaps
 				case MakePiece(White, Flat), MakePiece(White, Standing):
-					p.whiteStones--
+					stones = &p.whiteStones
 				case MakePiece(Black, Flat), MakePiece(Black, Standing):
-					p.blackStones--
+					stones = &p.blackStones
 				default:
 					return nil, errors.New("bad stone")
 				}
+				if *stones == 0 {
+					return nil, errors.New("too many stones")
+				}
+				*stones--
 				if j == 0 {
 					continue
 				}
@@ -207,6 +283,25 @@ func (p *Position) ToMove() Color {
 	return Black
 }
 
+// NoRoads reports whether `p` is a game of the flats-only variant;
+// see Config.NoRoads.
+func (p *Position) NoRoads() bool {
+	return p.cfg.NoRoads
+}
+
+// CarryLimit returns the most stones a slide in `p` may carry; see
+// Config.CarryLimit.
+func (p *Position) CarryLimit() int {
+	return p.cfg.CarryLimit
+}
+
+// IsOpeningSwap reports whether the next move is one of the first
+// two plies, in which each player places a flat of the opponent's
+// color.
+func (p *Position) IsOpeningSwap() bool {
+	return p.move < 2
+}
+
 func (p *Position) MoveNumber() int {
 	return p.move
 }
@@ -219,6 +314,38 @@ func (p *Position) BlackStones() int {
 	return int(p.blackStones)
 }
 
+func (p *Position) WhiteCaps() int {
+	return int(p.whiteCaps)
+}
+
+func (p *Position) BlackCaps() int {
+	return int(p.blackCaps)
+}
+
+// Reserves returns the number of stones and capstones color `c` has
+// left to place.
+func (p *Position) Reserves(c Color) (flats, caps int) {
+	if c == White {
+		return int(p.whiteStones), int(p.whiteCaps)
+	}
+	return int(p.blackStones), int(p.blackCaps)
+}
+
+// HalfKomi returns the komi of `p`'s game, in half-flats; see
+// Config.HalfKomi.
+func (p *Position) HalfKomi() int {
+	return p.cfg.HalfKomi
+}
+
+// StartingReserves returns the number of stones and capstones each
+// player had to place at the start of the game.
+func (p *Position) StartingReserves() (flats, caps int) {
+	return p.cfg.Pieces, p.cfg.Capstones
+}
+
+// GameOver reports whether the game is over and, if it is, who won.
+// A game that ends in a draw returns true and NoColor; an unfinished
+// game returns false.
 func (p *Position) GameOver() (over bool, winner Color) {
 	if p, ok := p.hasRoad(); ok {
 		return true, p
@@ -242,18 +369,19 @@ func (p *Position) roadAt(x, y int) (Color, bool) {
 }
 
 func (p *Position) hasRoad() (Color, bool) {
+	if p.cfg.NoRoads {
+		return White, false
+	}
 	white, black := false, false
 
 	for _, g := range p.analysis.WhiteGroups {
-		if ((g&p.cfg.c.T) != 0 && (g&p.cfg.c.B) != 0) ||
-			((g&p.cfg.c.L) != 0 && (g&p.cfg.c.R) != 0) {
+		if isRoad(&p.cfg.c, g) {
 			white = true
 			break
 		}
 	}
 	for _, g := range p.analysis.BlackGroups {
-		if ((g&p.cfg.c.T) != 0 && (g&p.cfg.c.B) != 0) ||
-			((g&p.cfg.c.L) != 0 && (g&p.cfg.c.R) != 0) {
+		if isRoad(&p.cfg.c, g) {
 			black = true
 			break
 		}
@@ -280,6 +408,7 @@ func (p *Position) Analysis() *Analysis {
 }
 
 func (p *Position) analyze() {
+	p.analyzePieces()
 	wr := p.White &^ p.Standing
 	br := p.Black &^ p.Standing
 	alloc := p.analysis.WhiteGroups[:0]
@@ -289,6 +418,77 @@ func (p *Position) analyze() {
 	p.analysis.BlackGroups = bitboard.FloodGroups(&p.cfg.c, br, alloc)
 }
 
+func (p *Position) analyzePieces() {
+	a := &p.analysis
+	a.WhiteWall = p.White & p.Standing
+	a.BlackWall = p.Black & p.Standing
+	a.WhiteCap = p.White & p.Caps
+	a.BlackCap = p.Black & p.Caps
+	a.WhiteFlat = p.White &^ (p.Standing | p.Caps)
+	a.BlackFlat = p.Black &^ (p.Standing | p.Caps)
+}
+
+// analyzeFrom updates the analysis of `p`, which was produced by a
+// move from `prev`. Groups whose squares and neighbors were not
+// touched by the move are carried over from `prev`, and only the
+// remainder of the board is flooded again. The result is identical
+// to analyze().
+func (p *Position) analyzeFrom(prev *Position) {
+	p.analyzePieces()
+	wr := p.White &^ p.Standing
+	br := p.Black &^ p.Standing
+	alloc := p.analysis.WhiteGroups[:0]
+	p.analysis.WhiteGroups = updateGroups(&p.cfg.c,
+		prev.analysis.WhiteGroups, prev.White&^prev.Standing, wr, alloc)
+	alloc = p.analysis.WhiteGroups
+	alloc = alloc[len(alloc):len(alloc):cap(alloc)]
+	p.analysis.BlackGroups = updateGroups(&p.cfg.c,
+		prev.analysis.BlackGroups, prev.Black&^prev.Standing, br, alloc)
+}
+
+// updateGroups returns the groups of `now`, given the groups `old`
+// of `was`, in the same order as bitboard.FloodGroups would.
+func updateGroups(c *bitboard.Constants, old []uint64, was, now uint64, out []uint64) []uint64 {
+	changed := was ^ now
+	if changed == 0 {
+		return append(out, old...)
+	}
+	touched := bitboard.Grow(c, c.Mask, changed)
+	var keep uint64
+	for _, g := range old {
+		if g&touched == 0 {
+			keep |= g
+		}
+	}
+
+	var seen uint64
+	bits := now
+	for bits != 0 {
+		next := bits & (bits - 1)
+		bit := bits &^ next
+		bits = next
+		if seen&bit != 0 {
+			continue
+		}
+		var g uint64
+		if keep&bit != 0 {
+			for _, og := range old {
+				if og&bit != 0 {
+					g = og
+					break
+				}
+			}
+		} else {
+			g = bitboard.Flood(c, now, bit)
+		}
+		if g != bit {
+			out = append(out, g)
+		}
+		seen |= g
+	}
+	return out
+}
+
 func (p *Position) countFlats() (w int, b int) {
 	w = bitboard.Popcount(p.White &^ (p.Standing | p.Caps))
 	b = bitboard.Popcount(p.Black &^ (p.Standing | p.Caps))
@@ -297,6 +497,8 @@ func (p *Position) countFlats() (w int, b int) {
 
 func (p *Position) flatsWinner() Color {
 	cw, cb := p.countFlats()
+	// Compare doubled counts, so that half-flat komi is exact.
+	cw, cb = 2*cw, 2*cb+p.cfg.HalfKomi
 	if cw > cb {
 		return White
 	}
@@ -306,18 +508,132 @@ func (p *Position) flatsWinner() Color {
 	return NoColor
 }
 
+// FlatCountFinishThreat reports whether color `c`, were it `c`'s turn
+// to play, could end the game by filling the last empty square or
+// playing its last piece, and win it on the resulting flat count.
+// Moves that instead win by road are not counted; see
+// HasImmediateRoadWin.
+func (p *Position) FlatCountFinishThreat(c Color) bool {
+	if p.move < 2 {
+		return false
+	}
+	var reserves byte
+	if c == White {
+		reserves = p.whiteStones + p.whiteCaps
+	} else {
+		reserves = p.blackStones + p.blackCaps
+	}
+	empty := bitboard.Popcount(p.cfg.c.Mask &^ (p.White | p.Black))
+	if reserves != 1 && empty != 1 {
+		return false
+	}
+	q := p
+	if p.ToMove() != c {
+		q = p.Clone()
+		q.move++
+	}
+	next := alloc(q)
+	for _, m := range q.AllMoves(nil) {
+		n, e := q.MovePreallocated(&m, next)
+		if e != nil {
+			continue
+		}
+		if d := n.WinDetails(); d.Over && d.Reason == FlatsWin && d.Winner == c {
+			return true
+		}
+	}
+	return false
+}
+
+// FillRaceResult predicts the end of a game in which both players do
+// nothing but place stones on the empty squares, until the board
+// fills or one of them runs out of pieces. In such a race each player
+// does best to place flats, holding their capstone back to the end,
+// so the final flat count follows from the reserves, the number of
+// empty squares and who is to move. FillRaceResult returns the winner
+// of that count, or NoColor for a draw.
+//
+// decisive is false when the race is not that simple: in the opening,
+// or when some way of filling the empty squares would complete a
+// road for either player. It considers placements only; slides that
+// capture or free flats are for the caller to rule out.
+func (p *Position) FillRaceResult() (winner Color, decisive bool) {
+	if over, winner := p.GameOver(); over {
+		return winner, true
+	}
+	if p.move < 2 {
+		return NoColor, false
+	}
+	empty := p.cfg.c.Mask &^ (p.White | p.Black)
+	if !p.cfg.NoRoads &&
+		(canRoad(&p.cfg.c, (p.White&^p.Standing)|empty) ||
+			canRoad(&p.cfg.c, (p.Black&^p.Standing)|empty)) {
+		return NoColor, false
+	}
+
+	var flats [2]int
+	flats[0], flats[1] = p.countFlats()
+	stones := [2]int{int(p.whiteStones), int(p.blackStones)}
+	caps := [2]int{int(p.whiteCaps), int(p.blackCaps)}
+	who := 0
+	if p.ToMove() == Black {
+		who = 1
+	}
+	for n := bitboard.Popcount(empty); n > 0; n-- {
+		if stones[who] > 0 {
+			stones[who]--
+			flats[who]++
+		} else {
+			caps[who]--
+		}
+		if stones[who]+caps[who] == 0 {
+			break
+		}
+		who ^= 1
+	}
+	w, b := 2*flats[0], 2*flats[1]+p.cfg.HalfKomi
+	switch {
+	case w > b:
+		return White, true
+	case b > w:
+		return Black, true
+	}
+	return NoColor, true
+}
+
 type WinReason int
 
 const (
 	RoadWin WinReason = iota
 	FlatsWin
 	Resignation
+	// Draw is a game that ended on a flat count with neither
+	// side ahead.
+	Draw
+)
+
+// An EndTrigger is the condition that ended a game.
+type EndTrigger int
+
+const (
+	NotEnded EndTrigger = iota
+	EndedByRoad
+	// EndedByFullBoard is a flat count because every square is
+	// occupied. A placement that both fills the board and empties
+	// a reserve is reported as EndedByFullBoard.
+	EndedByFullBoard
+	// EndedByReserves is a flat count because a player has placed
+	// all of their stones and capstones.
+	EndedByReserves
 )
 
+// WinDetails describes the result of a game. Reason, Winner and
+// Trigger are only meaningful if Over is set.
 type WinDetails struct {
 	Over       bool
 	Reason     WinReason
 	Winner     Color
+	Trigger    EndTrigger
 	WhiteFlats int
 	BlackFlats int
 }
@@ -328,10 +644,24 @@ func (p *Position) WinDetails() WinDetails {
 	d.Over = over
 	d.Winner = c
 	d.WhiteFlats, d.BlackFlats = p.countFlats()
-	if _, ok := p.hasRoad(); ok {
+	_, road := p.hasRoad()
+	switch {
+	case road:
 		d.Reason = RoadWin
-	} else {
+	case over && c == NoColor:
+		d.Reason = Draw
+	default:
 		d.Reason = FlatsWin
 	}
+	switch {
+	case !over:
+		d.Trigger = NotEnded
+	case road:
+		d.Trigger = EndedByRoad
+	case (p.White | p.Black) == p.cfg.c.Mask:
+		d.Trigger = EndedByFullBoard
+	default:
+		d.Trigger = EndedByReserves
+	}
 	return d
 }
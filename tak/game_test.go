@@ -1,6 +1,11 @@
 package tak
 
-import "testing"
+import (
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"testing"
+)
 
 func TestHasRoad(t *testing.T) {
 	p := New(Config{Size: 5})
@@ -104,6 +109,39 @@ func TestFlatsWinnerCapLeft(t *testing.T) {
 	}
 }
 
+func TestIncrementalAnalysis(t *testing.T) {
+	r := rand.New(rand.NewSource(17))
+	for _, size := range []int{3, 4, 5, 6, 8} {
+		for game := 0; game < 20; game++ {
+			p := New(Config{Size: size})
+			next := Alloc(size)
+			for ply := 0; ply < 200; ply++ {
+				if over, _ := p.GameOver(); over {
+					break
+				}
+				moves := p.AllMoves(nil)
+				var e error
+				for {
+					m := moves[r.Intn(len(moves))]
+					if next, e = p.MovePreallocated(&m, next); e == nil {
+						break
+					}
+				}
+				full := next.Clone()
+				full.analyze()
+				if !reflect.DeepEqual(next.analysis.WhiteGroups, full.analysis.WhiteGroups) ||
+					!reflect.DeepEqual(next.analysis.BlackGroups, full.analysis.BlackGroups) {
+					t.Fatalf("size=%d game=%d ply=%d: incremental=%x/%x full=%x/%x",
+						size, game, ply,
+						next.analysis.WhiteGroups, next.analysis.BlackGroups,
+						full.analysis.WhiteGroups, full.analysis.BlackGroups)
+				}
+				p, next = next, p
+			}
+		}
+	}
+}
+
 func BenchmarkEmptyHasRoad(b *testing.B) {
 	p := New(Config{Size: 5})
 	b.ReportAllocs()
@@ -242,3 +280,492 @@ func TestHash(t *testing.T) {
 		t.Fatalf("hash fail when swapping flat/standing")
 	}
 }
+
+func TestAnalysisPieces(t *testing.T) {
+	p := New(Config{Size: 5})
+	set(p, 0, 0, Square{MakePiece(White, Flat)})
+	set(p, 1, 0, Square{MakePiece(White, Standing), MakePiece(Black, Flat)})
+	set(p, 2, 0, Square{MakePiece(White, Capstone)})
+	set(p, 0, 1, Square{MakePiece(Black, Flat), MakePiece(White, Flat)})
+	set(p, 1, 1, Square{MakePiece(Black, Standing)})
+	set(p, 2, 1, Square{MakePiece(Black, Capstone), MakePiece(White, Standing)})
+	p.analyze()
+	a := p.Analysis()
+	for _, tc := range []struct {
+		name      string
+		got, want uint64
+	}{
+		{"WhiteFlat", a.WhiteFlat, 1 << 0},
+		{"WhiteWall", a.WhiteWall, 1 << 1},
+		{"WhiteCap", a.WhiteCap, 1 << 2},
+		{"BlackFlat", a.BlackFlat, 1 << 5},
+		{"BlackWall", a.BlackWall, 1 << 6},
+		{"BlackCap", a.BlackCap, 1 << 7},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s=%x, want %x", tc.name, tc.got, tc.want)
+		}
+	}
+
+	r := rand.New(rand.NewSource(3))
+	p = New(Config{Size: 5})
+	for ply := 0; ply < 200; ply++ {
+		if over, _ := p.GameOver(); over {
+			break
+		}
+		moves := p.AllMoves(nil)
+		for {
+			m := moves[r.Intn(len(moves))]
+			if next, e := p.Move(&m); e == nil {
+				p = next
+				break
+			}
+		}
+		a := p.Analysis()
+		for _, part := range []struct {
+			occ              uint64
+			flat, wall, caps uint64
+		}{
+			{p.White, a.WhiteFlat, a.WhiteWall, a.WhiteCap},
+			{p.Black, a.BlackFlat, a.BlackWall, a.BlackCap},
+		} {
+			if part.flat|part.wall|part.caps != part.occ ||
+				part.flat&part.wall != 0 || part.flat&part.caps != 0 ||
+				part.wall&part.caps != 0 {
+				t.Fatalf("ply=%d: flat=%x wall=%x cap=%x do not partition %x",
+					ply, part.flat, part.wall, part.caps, part.occ)
+			}
+		}
+	}
+}
+
+func TestCloneEqual(t *testing.T) {
+	a := moves([]Move{
+		Move{X: 0, Y: 0, Type: PlaceFlat},
+		Move{X: 1, Y: 1, Type: PlaceFlat},
+
+		Move{X: 3, Y: 2, Type: PlaceFlat},
+		Move{X: 4, Y: 3, Type: PlaceFlat},
+
+		Move{X: 1, Y: 3, Type: PlaceStanding},
+		Move{X: 3, Y: 1, Type: PlaceFlat},
+
+		Move{X: 3, Y: 2, Type: SlideLeft, Slides: []byte{1}},
+		Move{X: 4, Y: 3, Type: SlideLeft, Slides: []byte{1}},
+
+		Move{X: 1, Y: 1, Type: SlideLeft, Slides: []byte{1}},
+		Move{X: 0, Y: 0, Type: SlideUp, Slides: []byte{1}},
+	})
+	c := a.Clone()
+	if c.Hash() != a.Hash() {
+		t.Fatalf("clone hash=%x, want %x", c.Hash(), a.Hash())
+	}
+	if !c.Equal(a) || !a.Equal(c) {
+		t.Fatal("clone not Equal to original")
+	}
+	if !reflect.DeepEqual(c.analysis, a.analysis) {
+		t.Fatalf("clone analysis=%+v, want %+v", c.analysis, a.analysis)
+	}
+
+	// The clone must not share storage with the original.
+	want := a.Clone()
+	next, e := c.Move(&Move{X: 0, Y: 4, Type: PlaceFlat})
+	if e != nil {
+		t.Fatal("move:", e)
+	}
+	copyPosition(next, c)
+	c.analyze()
+	if a.Equal(c) {
+		t.Fatal("Equal after a move")
+	}
+	if !a.Equal(want) || !reflect.DeepEqual(a.analysis, want.analysis) {
+		t.Fatal("modifying the clone changed the original")
+	}
+
+	// Equal ignores the capacity of the board slices.
+	l := *a
+	l.Height = append(make([]uint8, 0, 100), a.Height...)
+	l.Stacks = append(make([]uint64, 0, 100), a.Stacks...)
+	if !l.Equal(a) {
+		t.Fatal("Equal depends on slice capacity")
+	}
+
+	for _, tc := range []struct {
+		name string
+		m    func(p *Position)
+	}{
+		{"move", func(p *Position) { p.move++ }},
+		{"reserves", func(p *Position) { p.whiteStones-- }},
+		{"standing", func(p *Position) { p.Standing ^= 1 }},
+		{"stack", func(p *Position) { p.Stacks[5] ^= 1 }},
+	} {
+		o := a.Clone()
+		tc.m(o)
+		if a.Equal(o) {
+			t.Errorf("%s: Equal after change", tc.name)
+		}
+	}
+}
+
+func TestFlatCountFinishThreat(t *testing.T) {
+	// A full 5x5 board but for e5: white has 11 flats and a
+	// wall, and black 12 flats, so filling e5 wins for black and
+	// draws for white.
+	full := New(Config{Size: 5})
+	full.move = 24
+	w, b := 0, 0
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 5; x++ {
+			switch {
+			case x == 4 && y == 4:
+			case (x+y)%2 == 0 && w < 12:
+				k := Flat
+				if w == 0 {
+					k = Standing
+				}
+				set(full, x, y, Square{MakePiece(White, k)})
+				w++
+			default:
+				set(full, x, y, Square{MakePiece(Black, Flat)})
+				b++
+			}
+		}
+	}
+	full.analyze()
+	if over, _ := full.GameOver(); over {
+		t.Fatal("board is already over")
+	}
+
+	// An open board where white has a single stone left and leads
+	// on flats.
+	last := New(Config{Size: 5})
+	last.move = 10
+	set(last, 0, 0, Square{MakePiece(White, Flat)})
+	set(last, 2, 0, Square{MakePiece(White, Flat)})
+	set(last, 4, 2, Square{MakePiece(Black, Flat)})
+	last.whiteStones = 1
+	last.whiteCaps = 0
+	last.analyze()
+
+	for _, tc := range []struct {
+		name string
+		p    *Position
+		c    Color
+		want bool
+	}{
+		{"full/white", full, White, false},
+		{"full/black", full, Black, true},
+		{"last/white", last, White, true},
+		{"last/black", last, Black, false},
+		{"opening", New(Config{Size: 5}), White, false},
+	} {
+		if got := tc.p.FlatCountFinishThreat(tc.c); got != tc.want {
+			t.Errorf("%s: FlatCountFinishThreat=%v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestReserves(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	for _, size := range []int{5, 6} {
+		cfg := DefaultConfig(size)
+		p := New(Config{Size: size})
+		for ply := 0; ply < 500; ply++ {
+			if over, _ := p.GameOver(); over {
+				break
+			}
+			moves := p.AllMoves(nil)
+			var next *Position
+			var m Move
+			for {
+				m = moves[r.Intn(len(moves))]
+				var e error
+				if next, e = p.Move(&m); e == nil {
+					break
+				}
+			}
+
+			// The opening plies place a stone of the
+			// other color.
+			placer := p.ToMove()
+			if p.IsOpeningSwap() {
+				placer = placer.Flip()
+			}
+			wantFlats, wantCaps := p.Reserves(placer)
+			switch m.Type {
+			case PlaceFlat, PlaceStanding:
+				wantFlats--
+			case PlaceCapstone:
+				wantCaps--
+			}
+			flats, caps := next.Reserves(placer)
+			if flats != wantFlats || caps != wantCaps {
+				t.Fatalf("size=%d ply=%d %v: reserves=%d/%d, want %d/%d",
+					size, ply, m, flats, caps, wantFlats, wantCaps)
+			}
+			of, oc := p.Reserves(placer.Flip())
+			if nf, nc := next.Reserves(placer.Flip()); nf != of || nc != oc {
+				t.Fatalf("size=%d ply=%d: opponent reserves changed", size, ply)
+			}
+			p = next
+		}
+
+		// Everything placed is on the board.
+		onBoard := 0
+		for _, h := range p.Height {
+			onBoard += int(h)
+		}
+		total := p.WhiteStones() + p.BlackStones() + p.WhiteCaps() + p.BlackCaps()
+		if onBoard+total != 2*(cfg.Pieces+cfg.Capstones) {
+			t.Errorf("size=%d: %d on board + %d in reserve, want %d",
+				size, onBoard, total, 2*(cfg.Pieces+cfg.Capstones))
+		}
+	}
+}
+
+func TestWinDetailsDraw(t *testing.T) {
+	// A full 4x4 checkerboard: 8 flats each, and no roads.
+	full := New(Config{Size: 4})
+	full.move = 16
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			c := White
+			if (x+y)%2 == 1 {
+				c = Black
+			}
+			set(full, x, y, Square{MakePiece(c, Flat)})
+		}
+	}
+	full.analyze()
+
+	// White is out of pieces, and ahead on flats.
+	out := New(Config{Size: 5})
+	out.move = 10
+	set(out, 0, 0, Square{MakePiece(White, Flat)})
+	set(out, 2, 0, Square{MakePiece(White, Flat)})
+	set(out, 4, 4, Square{MakePiece(Black, Flat)})
+	out.whiteStones, out.whiteCaps = 0, 0
+	out.analyze()
+
+	// The same board, with reserves left.
+	open := out.Clone()
+	open.whiteStones = 1
+
+	// A white road along the bottom edge of a full board.
+	road := full.Clone()
+	for x := 0; x < 4; x++ {
+		set(road, x, 0, Square{MakePiece(White, Flat)})
+	}
+	road.analyze()
+
+	// The full board, with Black's last stone just placed.
+	both := full.Clone()
+	both.blackStones, both.blackCaps = 0, 0
+
+	cases := []struct {
+		name    string
+		p       *Position
+		over    bool
+		winner  Color
+		reason  WinReason
+		trigger EndTrigger
+	}{
+		{"full board", full, true, NoColor, Draw, EndedByFullBoard},
+		{"out of pieces", out, true, White, FlatsWin, EndedByReserves},
+		{"unfinished", open, false, NoColor, 0, NotEnded},
+		{"road", road, true, White, RoadWin, EndedByRoad},
+		{"full and out of pieces", both, true, NoColor, Draw, EndedByFullBoard},
+	}
+	for _, tc := range cases {
+		over, winner := tc.p.GameOver()
+		if over != tc.over || winner != tc.winner {
+			t.Errorf("%s: GameOver()=%v,%s, want %v,%s",
+				tc.name, over, winner, tc.over, tc.winner)
+		}
+		d := tc.p.WinDetails()
+		if d.Over != tc.over || d.Winner != tc.winner || (tc.over && d.Reason != tc.reason) ||
+			d.Trigger != tc.trigger {
+			t.Errorf("%s: WinDetails()=%+v, want over=%v winner=%s reason=%d trigger=%d",
+				tc.name, d, tc.over, tc.winner, tc.reason, tc.trigger)
+		}
+	}
+	if d := full.WinDetails(); d.WhiteFlats != 8 || d.BlackFlats != 8 {
+		t.Errorf("full board flats=%d/%d", d.WhiteFlats, d.BlackFlats)
+	}
+}
+
+func TestCountFlats(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	for game := 0; game < 20; game++ {
+		p := New(Config{Size: 5})
+		for ply := 0; ply < 300; ply++ {
+			if over, _ := p.GameOver(); over {
+				break
+			}
+			moves := p.AllMoves(nil)
+			for {
+				m := moves[r.Intn(len(moves))]
+				if next, e := p.Move(&m); e == nil {
+					p = next
+					break
+				}
+			}
+
+			var w, b int
+			for x := 0; x < p.Size(); x++ {
+				for y := 0; y < p.Size(); y++ {
+					top := p.Top(x, y)
+					if top == 0 || top.Kind() != Flat {
+						continue
+					}
+					if top.Color() == White {
+						w++
+					} else {
+						b++
+					}
+				}
+			}
+			if cw, cb := p.countFlats(); cw != w || cb != b {
+				t.Fatalf("game=%d ply=%d: countFlats=%d/%d, board has %d/%d",
+					game, ply, cw, cb, w, b)
+			}
+		}
+	}
+}
+
+func TestNoRoads(t *testing.T) {
+	for _, noRoads := range []bool{false, true} {
+		// A white road along the bottom edge, and three
+		// black flats above it.
+		p := New(Config{Size: 4, NoRoads: noRoads})
+		p.move = 8
+		for x := 0; x < 4; x++ {
+			set(p, x, 0, Square{MakePiece(White, Flat)})
+			if x < 3 {
+				set(p, x, 1, Square{MakePiece(Black, Flat)})
+			}
+		}
+		p.analyze()
+		over, winner := p.GameOver()
+		if noRoads {
+			if over {
+				t.Errorf("no roads: road ended the game, winner=%s", winner)
+			}
+			if p.HasImmediateRoadWin(Black) || p.RoadDistance(White) != -1 {
+				t.Errorf("no roads: HasImmediateRoadWin=%v RoadDistance=%d",
+					p.HasImmediateRoadWin(Black), p.RoadDistance(White))
+			}
+		} else if !over || winner != White {
+			t.Errorf("road: GameOver()=%v,%s", over, winner)
+		}
+
+		// Fill the rest of the board, without a black road,
+		// giving Black two more flats than White.
+		for _, sq := range []struct {
+			x, y int
+			c    Color
+		}{
+			{3, 1, White},
+			{0, 2, Black}, {1, 2, Black}, {2, 2, Black}, {3, 2, White},
+			{0, 3, Black}, {1, 3, Black}, {2, 3, Black}, {3, 3, White},
+		} {
+			set(p, sq.x, sq.y, Square{MakePiece(sq.c, Flat)})
+		}
+		p.move = 16
+		p.analyze()
+		d := p.WinDetails()
+		if noRoads {
+			if !d.Over || d.Winner != Black || d.Reason != FlatsWin ||
+				d.Trigger != EndedByFullBoard {
+				t.Errorf("no roads: full board: %+v", d)
+			}
+		} else if d.Winner != White || d.Reason != RoadWin {
+			t.Errorf("road: full board: %+v", d)
+		}
+	}
+}
+
+func TestFillRaceResult(t *testing.T) {
+	// Walls down the diagonal of a 4x4 board rule out any road,
+	// leaving a pure race to fill the other squares.
+	race := func(cfg Config, toMove Color, ws, wc, bs, bc byte) *Position {
+		p := New(cfg)
+		p.move = 10
+		if toMove == Black {
+			p.move++
+		}
+		for i := 0; i < 4; i++ {
+			c := White
+			if i%2 == 1 {
+				c = Black
+			}
+			set(p, i, i, Square{MakePiece(c, Standing)})
+		}
+		set(p, 1, 0, Square{MakePiece(White, Flat)})
+		p.whiteStones, p.whiteCaps = ws, wc
+		p.blackStones, p.blackCaps = bs, bc
+		p.analyze()
+		return p
+	}
+	four := Config{Size: 4}
+	open := New(four)
+	open.move = 10
+	set(open, 0, 0, Square{MakePiece(White, Flat)})
+	open.analyze()
+	cases := []struct {
+		name     string
+		p        *Position
+		winner   Color
+		decisive bool
+	}{
+		// White fills six of the 11 squares, and black five.
+		{"white to move", race(four, White, 10, 0, 10, 0), White, true},
+		{"black to move", race(four, Black, 10, 0, 10, 0), NoColor, true},
+		{"komi", race(Config{Size: 4, HalfKomi: 4}, White, 10, 0, 10, 0), NoColor, true},
+		{"more komi", race(Config{Size: 4, HalfKomi: 6}, White, 10, 0, 10, 0), Black, true},
+		{"half komi", race(Config{Size: 4, HalfKomi: 3}, White, 10, 0, 10, 0), White, true},
+		{"more half komi", race(Config{Size: 4, HalfKomi: 5}, White, 10, 0, 10, 0), Black, true},
+		// Black's last stone ends the game after three
+		// placements, two of them Black's, leaving the flats
+		// level.
+		{"black runs out", race(four, Black, 10, 0, 2, 0), NoColor, true},
+		// White places its stone, and then its capstone,
+		// which ends the game without adding a flat.
+		{"capstone last", race(four, White, 1, 1, 10, 0), White, true},
+		{"opening", New(four), NoColor, false},
+		{"road possible", open, NoColor, false},
+	}
+
+	for _, tc := range cases {
+		winner, decisive := tc.p.FillRaceResult()
+		if winner != tc.winner || decisive != tc.decisive {
+			t.Errorf("%s: FillRaceResult()=%s,%v want %s,%v",
+				tc.name, winner, decisive, tc.winner, tc.decisive)
+			continue
+		}
+		if !decisive {
+			continue
+		}
+		// Play the race out, and check it ends as predicted.
+		p := tc.p
+		for {
+			if over, w := p.GameOver(); over {
+				if w != winner {
+					t.Errorf("%s: race won by %s, predicted %s", tc.name, w, winner)
+				}
+				break
+			}
+			empty := p.cfg.c.Mask &^ (p.White | p.Black)
+			i := bits.TrailingZeros64(empty)
+			m := Move{X: i % p.Size(), Y: i / p.Size(), Type: PlaceFlat}
+			if s, _ := p.Reserves(p.ToMove()); s == 0 {
+				m.Type = PlaceCapstone
+			}
+			next, e := p.Move(&m)
+			if e != nil {
+				t.Fatalf("%s: %#v: %v", tc.name, m, e)
+			}
+			p = next
+		}
+	}
+}
@@ -37,11 +37,49 @@ func (p *Position) hashAt(i uint) uint64 {
 }
 
 func (p *Position) Hash() uint64 {
-	h := p.hash
-	h = hash64(h, p.White)
-	h = hash64(h, p.Black)
-	h = hash64(h, p.Standing)
-	h = hash64(h, p.Caps)
+	return p.finishHash(p.hash, p.White, p.Black, p.Standing, p.Caps)
+}
+
+func (p *Position) finishHash(h, white, black, standing, caps uint64) uint64 {
+	h = hash64(h, white)
+	h = hash64(h, black)
+	h = hash64(h, standing)
+	h = hash64(h, caps)
 	h = hash8(h, byte(p.ToMove()))
 	return h
 }
+
+// CanonicalHash returns a hash of `p` that is the same for every
+// rotation and reflection of the position: the least Hash of any of
+// its symmetric images.
+func (p *Position) CanonicalHash() uint64 {
+	best := p.Hash()
+	for _, s := range symmetries[1:] {
+		if h := s.hash(p); h < best {
+			best = h
+		}
+	}
+	return best
+}
+
+// hash returns the Hash of the image of `p` under `s`.
+func (s symmetry) hash(p *Position) uint64 {
+	sz := p.Size()
+	h := uint64(fnvBasis)
+	var white, black, standing, caps uint64
+	for y := 0; y < sz; y++ {
+		for x := 0; x < sz; x++ {
+			i := uint(x + y*sz)
+			tx, ty := s.apply(sz, x, y)
+			j := uint(tx + ty*sz)
+			white |= (p.White >> i & 1) << j
+			black |= (p.Black >> i & 1) << j
+			standing |= (p.Standing >> i & 1) << j
+			caps |= (p.Caps >> i & 1) << j
+			if p.Height[i] > 1 {
+				h ^= hash64(hash8(basis[j], p.Height[i]), p.Stacks[i])
+			}
+		}
+	}
+	return p.finishHash(h, white, black, standing, caps)
+}
@@ -78,18 +78,86 @@ func (m *Move) Hash() uint64 {
 	return h
 }
 
+// EncodeMove packs a move on a board of at most 8x8 into 20 bits:
+// three each for X and Y, four for the type, and, for slides, three
+// for the number of stones carried and seven marking where in the
+// carried stones each drop ends. The zero Move encodes as 0.
+func (m *Move) EncodeMove() uint32 {
+	c := uint32(m.X) | uint32(m.Y)<<3 | uint32(m.Type)<<6
+	if !m.IsSlide() || len(m.Slides) == 0 {
+		return c
+	}
+	var carry, drops uint32
+	for i, s := range m.Slides {
+		carry += uint32(s)
+		if i != len(m.Slides)-1 {
+			drops |= 1 << (carry - 1)
+		}
+	}
+	return c | (carry-1)<<10 | drops<<13
+}
+
+// DecodeMove inverts EncodeMove. The Slides of the returned move are
+// shared, and must not be modified.
+func DecodeMove(c uint32) Move {
+	m := Move{
+		X:    int(c & 7),
+		Y:    int((c >> 3) & 7),
+		Type: MoveType((c >> 6) & 0xf),
+	}
+	if m.IsSlide() {
+		m.Slides = slideCodes[(c>>10)&7][(c>>13)&0x7f]
+	}
+	return m
+}
+
+// A moveError is an error that is also an instance (for errors.Is)
+// of a more general error, so callers can test for either.
+type moveError struct {
+	msg  string
+	kind error
+}
+
+func (e *moveError) Error() string {
+	return e.msg
+}
+
+func (e *moveError) Is(target error) bool {
+	return errors.Is(e.kind, target)
+}
+
+// The errors returned by Move and MovePreallocated. Some refine others:
+// ErrCannotFlatten and ErrBadCarry are both an ErrIllegalSlide, and
+// ErrNoCapstone is an ErrNoPiece; test for them with errors.Is.
 var (
+	ErrGameOver       = errors.New("game is over")
 	ErrOccupied       = errors.New("position is occupied")
+	ErrNoPiece        = errors.New("no pieces of that kind left")
+	ErrNoCapstone     = &moveError{"capstone has already been played", ErrNoPiece}
 	ErrIllegalSlide   = errors.New("illegal slide")
-	ErrNoCapstone     = errors.New("capstone has already been played")
 	ErrIllegalOpening = errors.New("illegal opening move")
+	ErrOffBoard       = errors.New("move leaves the board")
+
+	ErrCannotFlatten = &moveError{"slide onto a wall or capstone", ErrIllegalSlide}
+	ErrNotOwner      = &moveError{"slide of a stack the player does not control", ErrIllegalSlide}
+	ErrBadCarry      = &moveError{"bad carry", ErrIllegalSlide}
+	ErrCarryLimit    = &moveError{"slide carries more stones than the carry limit", ErrBadCarry}
+	ErrStackHeight   = &moveError{"slide carries more stones than the stack holds", ErrBadCarry}
+	ErrZeroDrop      = &moveError{"slide drops no stones on a square", ErrBadCarry}
 )
 
+// Move returns the position after playing `m` in `p`, or an error if
+// `m` is illegal, including when the game is already over.
 func (p *Position) Move(m *Move) (*Position, error) {
 	return p.MovePreallocated(m, nil)
 }
 
+// MovePreallocated is like Move, but reuses `next`, if it is non-nil,
+// for the result.
 func (p *Position) MovePreallocated(m *Move, next *Position) (*Position, error) {
+	if over, _ := p.GameOver(); over {
+		return nil, ErrGameOver
+	}
 	if next == nil {
 		next = alloc(p)
 	} else {
@@ -100,7 +168,7 @@ func (p *Position) MovePreallocated(m *Move, next *Position) (*Position, error)
 	dx, dy := 0, 0
 	switch m.Type {
 	case Pass:
-		next.analyze()
+		next.analyzeFrom(p)
 		return next, nil
 	case PlaceFlat:
 		place = MakePiece(p.ToMove(), Flat)
@@ -125,6 +193,9 @@ func (p *Position) MovePreallocated(m *Move, next *Position) (*Position, error)
 		}
 		place = MakePiece(place.Color().Flip(), place.Kind())
 	}
+	if m.X < 0 || m.X >= p.cfg.Size || m.Y < 0 || m.Y >= p.cfg.Size {
+		return nil, ErrOffBoard
+	}
 	i := uint(m.X + m.Y*p.Size())
 	if place != 0 {
 		if (p.White|p.Black)&(1<<i) != 0 {
@@ -151,7 +222,10 @@ func (p *Position) MovePreallocated(m *Move, next *Position) (*Position, error)
 			}
 		}
 		if *stones <= 0 {
-			return nil, ErrNoCapstone
+			if place.Kind() == Capstone {
+				return nil, ErrNoCapstone
+			}
+			return nil, ErrNoPiece
 		}
 		*stones--
 		if place.Color() == White {
@@ -160,7 +234,7 @@ func (p *Position) MovePreallocated(m *Move, next *Position) (*Position, error)
 			next.Black |= (1 << i)
 		}
 		next.Height[i]++
-		next.analyze()
+		next.analyzeFrom(p)
 		return next, nil
 	}
 
@@ -168,14 +242,20 @@ func (p *Position) MovePreallocated(m *Move, next *Position) (*Position, error)
 	for _, c := range m.Slides {
 		ct += uint(c)
 	}
-	if ct > uint(p.cfg.Size) || ct < 1 || ct > uint(p.Height[i]) {
+	if ct < 1 {
 		return nil, ErrIllegalSlide
 	}
+	if ct > uint(p.cfg.CarryLimit) {
+		return nil, ErrCarryLimit
+	}
 	if p.ToMove() == White && p.White&(1<<i) == 0 {
-		return nil, ErrIllegalSlide
+		return nil, ErrNotOwner
 	}
 	if p.ToMove() == Black && p.Black&(1<<i) == 0 {
-		return nil, ErrIllegalSlide
+		return nil, ErrNotOwner
+	}
+	if ct > uint(p.Height[i]) {
+		return nil, ErrStackHeight
 	}
 
 	top := p.Top(m.X, m.Y)
@@ -207,20 +287,20 @@ func (p *Position) MovePreallocated(m *Move, next *Position) (*Position, error)
 	for _, c := range m.Slides {
 		x += dx
 		y += dy
+		if int(c) < 1 {
+			return nil, ErrZeroDrop
+		}
 		if x < 0 || x >= next.cfg.Size ||
 			y < 0 || y >= next.cfg.Size {
-			return nil, ErrIllegalSlide
-		}
-		if int(c) < 1 || uint(c) > ct {
-			return nil, ErrIllegalSlide
+			return nil, ErrOffBoard
 		}
 		i = uint(x + y*p.Size())
 		switch {
 		case next.Caps&(1<<i) != 0:
-			return nil, ErrIllegalSlide
+			return nil, ErrCannotFlatten
 		case next.Standing&(1<<i) != 0:
 			if ct != 1 || top.Kind() != Capstone {
-				return nil, ErrIllegalSlide
+				return nil, ErrCannotFlatten
 			}
 			next.Standing &= ^(1 << i)
 		}
@@ -253,16 +333,33 @@ func (p *Position) MovePreallocated(m *Move, next *Position) (*Position, error)
 		}
 	}
 
-	next.analyze()
+	next.analyzeFrom(p)
 	return next, nil
 }
 
 var slides [][][]byte
 
+// slideCodes[c-1][d] is the drop sequence carrying c stones that
+// EncodeMove marks with the drop bits d.
+var slideCodes [8][128][]byte
+
+// slideCounts[h][n] is the number of slides of a stack of h pieces
+// that cover at most n squares.
+var slideCounts [10][9]int
+
 func init() {
 	slides = make([][][]byte, 10)
 	for s := 1; s <= 8; s++ {
 		slides[s] = calculateSlides(s)
+		for _, sl := range slides[s] {
+			m := Move{Type: SlideLeft, Slides: sl}
+			slideCodes[s-1][m.EncodeMove()>>13] = sl
+		}
+		for _, sl := range slides[s] {
+			for n := len(sl); n < len(slideCounts[s]); n++ {
+				slideCounts[s][n]++
+			}
+		}
 	}
 }
 
@@ -280,25 +377,32 @@ func calculateSlides(stack int) [][]byte {
 	return out
 }
 
+// AllMoves appends every legal move in `p` to `moves`, and returns
+// the result. It does not check whether the game is over.
 func (p *Position) AllMoves(moves []Move) []Move {
 	next := p.ToMove()
-	cap := false
+	var stones, cap bool
 	if next == White {
-		cap = p.whiteCaps > 0
+		stones, cap = p.whiteStones > 0, p.whiteCaps > 0
 	} else {
-		cap = p.blackCaps > 0
+		stones, cap = p.blackStones > 0, p.blackCaps > 0
+	}
+	if p.move < 2 {
+		stones, cap = true, false
 	}
 	for x := 0; x < p.cfg.Size; x++ {
 		for y := 0; y < p.cfg.Size; y++ {
 			i := uint(y*p.cfg.Size + x)
 			if p.Height[i] == 0 {
-				moves = append(moves, Move{x, y, PlaceFlat, nil})
-				if p.move >= 2 {
-					moves = append(moves, Move{x, y, PlaceStanding, nil})
-					if cap {
-						moves = append(moves, Move{x, y, PlaceCapstone, nil})
+				if stones {
+					moves = append(moves, Move{x, y, PlaceFlat, nil})
+					if p.move >= 2 {
+						moves = append(moves, Move{x, y, PlaceStanding, nil})
 					}
 				}
+				if cap {
+					moves = append(moves, Move{x, y, PlaceCapstone, nil})
+				}
 				continue
 			}
 			if p.move < 2 {
@@ -310,24 +414,16 @@ func (p *Position) AllMoves(moves []Move) []Move {
 				continue
 			}
 
-			type dircnt struct {
-				d MoveType
-				c int
-			}
-			dirs := [4]dircnt{
-				{SlideLeft, x},
-				{SlideRight, p.cfg.Size - x - 1},
-				{SlideDown, y},
-				{SlideUp, p.cfg.Size - y - 1},
+			h := p.Height[i]
+			if h > uint8(p.cfg.CarryLimit) {
+				h = uint8(p.cfg.CarryLimit)
 			}
-			for _, d := range dirs {
-				h := p.Height[i]
-				if h > uint8(p.cfg.Size) {
-					h = uint8(p.cfg.Size)
-				}
+			for _, d := range slideDirs {
+				n, flatten := p.slideRange(x, y, d.dx, d.dy)
 				for _, s := range slides[h] {
-					if len(s) <= d.c {
-						moves = append(moves, Move{x, y, d.d, s})
+					if len(s) <= n ||
+						(flatten && len(s) == n+1 && s[n] == 1) {
+						moves = append(moves, Move{x, y, d.t, s})
 					}
 				}
 			}
@@ -336,3 +432,90 @@ func (p *Position) AllMoves(moves []Move) []Move {
 
 	return moves
 }
+
+var slideDirs = [4]struct {
+	t      MoveType
+	dx, dy int
+}{
+	{SlideLeft, -1, 0},
+	{SlideRight, 1, 0},
+	{SlideDown, 0, -1},
+	{SlideUp, 0, 1},
+}
+
+// slideRange returns the number of squares a slide from (x,y) in
+// direction (dx,dy) may cover before it reaches the edge of the board,
+// a capstone or a wall, and whether the stack's capstone can flatten
+// the wall it stopped at.
+func (p *Position) slideRange(x, y, dx, dy int) (n int, flatten bool) {
+	sz := p.cfg.Size
+	capTop := p.Caps&(1<<uint(y*sz+x)) != 0
+	for x, y = x+dx, y+dy; x >= 0 && x < sz && y >= 0 && y < sz; x, y = x+dx, y+dy {
+		bit := uint64(1) << uint(y*sz+x)
+		if (p.Standing|p.Caps)&bit != 0 {
+			return n, capTop && p.Standing&bit != 0
+		}
+		n++
+	}
+	return n, false
+}
+
+// CountMoves returns the number of moves AllMoves would return for
+// `p`, without generating them.
+func (p *Position) CountMoves() int {
+	next := p.ToMove()
+	var stones, cap bool
+	var mine uint64
+	if next == White {
+		stones, cap = p.whiteStones > 0, p.whiteCaps > 0
+		mine = p.White
+	} else {
+		stones, cap = p.blackStones > 0, p.blackCaps > 0
+		mine = p.Black
+	}
+	places := 0
+	switch {
+	case p.move < 2:
+		places = 1
+	case stones:
+		places = 2
+	}
+	if cap && p.move >= 2 {
+		places++
+	}
+	n := 0
+	sz := p.cfg.Size
+	for x := 0; x < sz; x++ {
+		for y := 0; y < sz; y++ {
+			i := uint(y*sz + x)
+			if p.Height[i] == 0 {
+				n += places
+				continue
+			}
+			if p.move < 2 || mine&(1<<i) == 0 {
+				continue
+			}
+			h := p.Height[i]
+			if h > uint8(p.cfg.CarryLimit) {
+				h = uint8(p.cfg.CarryLimit)
+			}
+			c := &slideCounts[h]
+			for _, d := range slideDirs {
+				r, flatten := p.slideRange(x, y, d.dx, d.dy)
+				n += c[r]
+				if !flatten {
+					continue
+				}
+				// Slides ending with the capstone alone
+				// on the wall: any slide of the other
+				// h-1 stones covering exactly r squares.
+				if r == 0 {
+					n++
+				} else {
+					n += slideCounts[h-1][r] - slideCounts[h-1][r-1]
+				}
+			}
+		}
+	}
+	return n
+}
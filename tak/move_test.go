@@ -1,6 +1,8 @@
 package tak
 
 import (
+	"errors"
+	"math/rand"
 	"reflect"
 	"sort"
 	"testing"
@@ -43,7 +45,7 @@ func TestMove(t *testing.T) {
 	orig := Move{3, 3, SlideUp, []byte{1}}
 	move := orig
 	_, e = n.Move(&move)
-	if e != ErrIllegalSlide {
+	if !errors.Is(e, ErrCannotFlatten) {
 		t.Fatalf("slide onto wall allowed: %v", e)
 	}
 	if !reflect.DeepEqual(orig, move) {
@@ -95,7 +97,7 @@ func TestMove(t *testing.T) {
 	}
 	t.Log("Slide onto a capstone")
 	_, e = n.Move(&Move{3, 4, SlideDown, []byte{1}})
-	if e != ErrIllegalSlide {
+	if !errors.Is(e, ErrCannotFlatten) {
 		t.Fatalf("slide onto a capstone")
 	}
 	t.Log("Slide a capstone to flatten a wall")
@@ -265,3 +267,473 @@ func TestEqual(t *testing.T) {
 		t.Errorf("%#v = %#v!", a, b)
 	}
 }
+
+func TestCountMoves(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for _, size := range []int{3, 4, 5, 6, 7, 8} {
+		for game := 0; game < 20; game++ {
+			p := New(Config{Size: size})
+			for ply := 0; ply < 200; ply++ {
+				if over, _ := p.GameOver(); over {
+					break
+				}
+				moves := p.AllMoves(nil)
+				if n := p.CountMoves(); n != len(moves) {
+					t.Fatalf("size=%d game=%d ply=%d: CountMoves()=%d, len(AllMoves)=%d",
+						size, game, ply, n, len(moves))
+				}
+				var next *Position
+				var e error
+				for {
+					m := moves[r.Intn(len(moves))]
+					if next, e = p.Move(&m); e == nil {
+						break
+					}
+				}
+				p = next
+			}
+		}
+	}
+}
+
+func TestAllMovesLegal(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for i := 0; i < 300; i++ {
+		cfg := DefaultConfig(3 + i%6)
+		if i%5 == 0 {
+			cfg.CarryLimit = 2
+		}
+		p := RandomPosition(cfg, r.Intn(100), r)
+		if over, _ := p.GameOver(); over {
+			continue
+		}
+		// Try every move that could conceivably be legal,
+		// and check that AllMoves generates exactly the ones
+		// that are.
+		want := 0
+		for x := 0; x < p.Size(); x++ {
+			for y := 0; y < p.Size(); y++ {
+				for _, typ := range []MoveType{PlaceFlat, PlaceStanding, PlaceCapstone} {
+					if _, e := p.Move(&Move{x, y, typ, nil}); e == nil {
+						want++
+					}
+				}
+				for _, typ := range []MoveType{SlideLeft, SlideRight, SlideDown, SlideUp} {
+					for _, sl := range slides[p.Size()] {
+						if _, e := p.Move(&Move{x, y, typ, sl}); e == nil {
+							want++
+						}
+					}
+				}
+			}
+		}
+		moves := p.AllMoves(nil)
+		for _, m := range moves {
+			if _, e := p.Move(&m); e != nil {
+				t.Fatalf("%d: AllMoves generated %#v: %v", i, m, e)
+			}
+		}
+		if len(moves) != want {
+			t.Fatalf("%d: len(AllMoves)=%d, want %d legal moves", i, len(moves), want)
+		}
+		if n := p.CountMoves(); n != want {
+			t.Fatalf("%d: CountMoves()=%d, want %d", i, n, want)
+		}
+	}
+}
+
+// carries returns the number of stones `m` picks up.
+func carries(m *Move) int {
+	n := 0
+	for _, s := range m.Slides {
+		n += int(s)
+	}
+	return n
+}
+
+// perftCarry counts the legal move sequences of `depth` plies from
+// `p` in which no slide carries more than `limit` stones.
+func perftCarry(p *Position, depth, limit int) int {
+	if depth == 0 {
+		return 1
+	}
+	if over, _ := p.GameOver(); over {
+		return 0
+	}
+	n := 0
+	for _, m := range p.AllMoves(nil) {
+		if carries(&m) > limit {
+			continue
+		}
+		if next, e := p.Move(&m); e == nil {
+			n += perftCarry(next, depth-1, limit)
+		}
+	}
+	return n
+}
+
+func TestCarryLimit(t *testing.T) {
+	tall := Square{
+		MakePiece(White, Flat), MakePiece(Black, Flat), MakePiece(White, Flat),
+		MakePiece(Black, Flat), MakePiece(White, Flat), MakePiece(Black, Flat),
+		MakePiece(White, Flat),
+	}
+	build := func(limit int) *Position {
+		p := New(Config{Size: 6, CarryLimit: limit})
+		p.move = 20
+		set(p, 2, 2, tall)
+		set(p, 3, 3, Square{MakePiece(Black, Capstone), MakePiece(White, Flat),
+			MakePiece(Black, Flat), MakePiece(White, Flat), MakePiece(Black, Flat),
+			MakePiece(White, Flat)})
+		set(p, 0, 5, Square{MakePiece(White, Standing)})
+		p.analyze()
+		return p
+	}
+
+	full := build(0)
+	for _, limit := range []int{6, 5, 3, 1} {
+		p := build(limit)
+		if n := p.CountMoves(); n != len(p.AllMoves(nil)) {
+			t.Errorf("limit=%d: CountMoves()=%d, len(AllMoves)=%d",
+				limit, n, len(p.AllMoves(nil)))
+		}
+		for _, m := range p.AllMoves(nil) {
+			if carries(&m) > limit {
+				t.Fatalf("limit=%d: generated %#v", limit, m)
+			}
+		}
+		for depth := 1; depth <= 2; depth++ {
+			got := perftCarry(p, depth, 6)
+			want := perftCarry(full, depth, limit)
+			if got != want {
+				t.Errorf("limit=%d depth=%d: perft=%d, want %d", limit, depth, got, want)
+			}
+		}
+	}
+	if a, b := perftCarry(build(6), 1, 6), perftCarry(build(5), 1, 6); a <= b {
+		t.Errorf("lowering the limit did not remove moves: %d, %d", a, b)
+	}
+
+	m := Move{X: 2, Y: 2, Type: SlideRight, Slides: []byte{3, 3}}
+	if _, e := full.Move(&m); e != nil {
+		t.Errorf("limit=6: %v", e)
+	}
+	if _, e := build(5).Move(&m); e != ErrCarryLimit {
+		t.Errorf("limit=5: err=%v, want ErrCarryLimit", e)
+	}
+}
+
+func TestMoveVerbose(t *testing.T) {
+	p := New(Config{Size: 5})
+	p.move = 2
+	for _, m := range []Move{
+		{2, 2, PlaceCapstone, nil},
+		{3, 2, PlaceStanding, nil},
+	} {
+		var e error
+		if p, e = p.Move(&m); e != nil {
+			t.Fatalf("setup: %#v: %v", m, e)
+		}
+	}
+	// A white capstone on c3, next to a black wall on d3.
+	bit := func(x, y int) uint64 { return 1 << uint(x+y*5) }
+	next, eff, e := p.MoveVerbose(&Move{2, 2, SlideRight, []byte{1}})
+	if e != nil {
+		t.Fatalf("flatten: %v", e)
+	}
+	if top := next.Top(3, 2); top != MakePiece(White, Capstone) {
+		t.Errorf("d3 top=%v", top)
+	}
+	want := MoveEffect{
+		Touched:       bit(2, 2) | bit(3, 2),
+		Flattened:     bit(3, 2),
+		Changed:       bit(2, 2) | bit(3, 2),
+		CapturedBlack: 1,
+		Drops: []Drop{
+			{3, 2, Square{MakePiece(White, Capstone)}},
+		},
+	}
+	if !reflect.DeepEqual(eff, want) {
+		t.Errorf("flatten: effect=%+v, want %+v", eff, want)
+	}
+
+	_, eff, e = p.MoveVerbose(&Move{4, 4, PlaceFlat, nil})
+	if e != nil {
+		t.Fatalf("place: %v", e)
+	}
+	want = MoveEffect{Touched: bit(4, 4), Changed: bit(4, 4)}
+	if !reflect.DeepEqual(eff, want) {
+		t.Errorf("place: effect=%+v, want %+v", eff, want)
+	}
+
+	p = New(Config{Size: 5})
+	p.move = 5
+	set(p, 1, 1, Square{
+		MakePiece(Black, Flat),
+		MakePiece(White, Flat),
+		MakePiece(Black, Flat),
+		MakePiece(White, Flat),
+	})
+	set(p, 2, 1, Square{MakePiece(White, Flat)})
+	set(p, 3, 1, Square{MakePiece(White, Flat), MakePiece(Black, Flat)})
+	_, eff, e = p.MoveVerbose(&Move{1, 1, SlideRight, []byte{2, 2}})
+	if e != nil {
+		t.Fatalf("slide: %v", e)
+	}
+	// The black stone on d2 is freed, and doesn't offset the
+	// white ones captured.
+	dropped := Square{MakePiece(Black, Flat), MakePiece(White, Flat)}
+	want = MoveEffect{
+		Touched:       bit(1, 1) | bit(2, 1) | bit(3, 1),
+		Changed:       bit(1, 1) | bit(2, 1) | bit(3, 1),
+		CapturedWhite: 4,
+		Drops:         []Drop{{2, 1, dropped}, {3, 1, dropped}},
+	}
+	if !reflect.DeepEqual(eff, want) {
+		t.Errorf("slide: effect=%+v, want %+v", eff, want)
+	}
+}
+
+func TestOpeningSwap(t *testing.T) {
+	p := New(Config{Size: 5})
+	for _, m := range []Move{
+		{X: 0, Y: 0, Type: PlaceStanding},
+		{X: 0, Y: 0, Type: PlaceCapstone},
+		{X: 0, Y: 0, Type: SlideRight, Slides: []byte{1}},
+	} {
+		if _, e := p.Move(&m); e == nil {
+			t.Errorf("ply 0: %v was allowed", m)
+		}
+	}
+	if !p.IsOpeningSwap() {
+		t.Fatal("ply 0 is not a swap")
+	}
+
+	p, e := p.Move(&Move{X: 0, Y: 0, Type: PlaceFlat})
+	if e != nil {
+		t.Fatal("ply 0:", e)
+	}
+	if p.Top(0, 0) != MakePiece(Black, Flat) {
+		t.Errorf("ply 0 placed %s, want a black flat", p.Top(0, 0))
+	}
+	if p.WhiteStones() != 21 || p.BlackStones() != 20 {
+		t.Errorf("after ply 0: stones=%d/%d, want 21/20", p.WhiteStones(), p.BlackStones())
+	}
+	if !p.IsOpeningSwap() {
+		t.Fatal("ply 1 is not a swap")
+	}
+	for _, m := range []Move{
+		{X: 4, Y: 4, Type: PlaceStanding},
+		{X: 4, Y: 4, Type: PlaceCapstone},
+		{X: 0, Y: 0, Type: SlideRight, Slides: []byte{1}},
+	} {
+		if _, e := p.Move(&m); e == nil {
+			t.Errorf("ply 1: %v was allowed", m)
+		}
+	}
+
+	p, e = p.Move(&Move{X: 4, Y: 4, Type: PlaceFlat})
+	if e != nil {
+		t.Fatal("ply 1:", e)
+	}
+	if p.Top(4, 4) != MakePiece(White, Flat) {
+		t.Errorf("ply 1 placed %s, want a white flat", p.Top(4, 4))
+	}
+	if p.WhiteStones() != 20 || p.BlackStones() != 20 {
+		t.Errorf("after ply 1: stones=%d/%d, want 20/20", p.WhiteStones(), p.BlackStones())
+	}
+	if p.IsOpeningSwap() {
+		t.Fatal("ply 2 is a swap")
+	}
+
+	p, e = p.Move(&Move{X: 2, Y: 2, Type: PlaceStanding})
+	if e != nil {
+		t.Fatal("ply 2:", e)
+	}
+	if p.Top(2, 2) != MakePiece(White, Standing) {
+		t.Errorf("ply 2 placed %s, want a white wall", p.Top(2, 2))
+	}
+
+	for _, m := range New(Config{Size: 5}).AllMoves(nil) {
+		if m.Type != PlaceFlat {
+			t.Errorf("AllMoves at ply 0 includes %v", m)
+		}
+	}
+}
+
+func TestMalformedSlides(t *testing.T) {
+	// a1 holds a white-topped stack of 3; b1 is black's.
+	p := New(Config{Size: 5})
+	p.move = 4
+	set(p, 0, 0, Square{MakePiece(White, Flat), MakePiece(Black, Flat), MakePiece(White, Flat)})
+	set(p, 1, 0, Square{MakePiece(Black, Flat)})
+	set(p, 0, 1, Square{MakePiece(White, Flat), MakePiece(White, Flat),
+		MakePiece(White, Flat), MakePiece(White, Flat), MakePiece(White, Flat), MakePiece(White, Flat)})
+	p.analyze()
+
+	cases := []struct {
+		name string
+		m    Move
+		err  error
+	}{
+		{"ok", Move{0, 0, SlideUp, []byte{1, 2}}, nil},
+		{"zero drop", Move{0, 0, SlideUp, []byte{1, 0, 2}}, ErrZeroDrop},
+		{"zero first drop", Move{0, 0, SlideUp, []byte{0, 3}}, ErrZeroDrop},
+		{"over carry", Move{0, 1, SlideUp, []byte{3, 3}}, ErrCarryLimit},
+		{"over height", Move{0, 0, SlideUp, []byte{2, 2}}, ErrStackHeight},
+		{"off board", Move{0, 0, SlideLeft, []byte{1}}, ErrOffBoard},
+		{"runs off board", Move{0, 1, SlideUp, []byte{1, 1, 1, 1}}, ErrOffBoard},
+		{"source off board", Move{5, 0, SlideLeft, []byte{1}}, ErrOffBoard},
+		{"not owner", Move{1, 0, SlideUp, []byte{1}}, ErrNotOwner},
+		{"empty square", Move{2, 2, SlideUp, []byte{1}}, ErrNotOwner},
+		{"no stones", Move{0, 0, SlideUp, nil}, ErrIllegalSlide},
+	}
+	for _, tc := range cases {
+		_, e := p.Move(&tc.m)
+		if e != tc.err {
+			t.Errorf("%s: err=%v, want %v", tc.name, e, tc.err)
+		}
+		if _, e := p.MovePreallocated(&tc.m, Alloc(5)); e != tc.err {
+			t.Errorf("%s: preallocated err=%v, want %v", tc.name, e, tc.err)
+		}
+	}
+}
+
+func TestMoveErrors(t *testing.T) {
+	p := New(Config{Size: 5})
+	p.move = 4
+	set(p, 0, 0, Square{MakePiece(White, Flat), MakePiece(Black, Flat)})
+	set(p, 1, 0, Square{MakePiece(Black, Standing)})
+	set(p, 2, 0, Square{MakePiece(White, Capstone)})
+	p.whiteCaps = 0
+	p.analyze()
+
+	// White has only a capstone left.
+	noFlats := p.Clone()
+	noFlats.whiteStones = 0
+	noFlats.whiteCaps = 1
+
+	over := New(Config{Size: 5})
+	over.move = 10
+	for x := 0; x < 5; x++ {
+		set(over, x, 0, Square{MakePiece(White, Flat)})
+	}
+	over.analyze()
+
+	cases := []struct {
+		name string
+		p    *Position
+		m    Move
+		errs []error
+	}{
+		{"occupied", p, Move{0, 0, PlaceFlat, nil}, []error{ErrOccupied}},
+		{"off board", p, Move{5, 0, PlaceFlat, nil}, []error{ErrOffBoard}},
+		{"slide off board", p, Move{0, 0, SlideLeft, []byte{1}}, []error{ErrOffBoard}},
+		{"no capstone", p, Move{4, 4, PlaceCapstone, nil}, []error{ErrNoCapstone, ErrNoPiece}},
+		{"no flats", noFlats, Move{4, 4, PlaceFlat, nil}, []error{ErrNoPiece}},
+		{"no walls", noFlats, Move{4, 4, PlaceStanding, nil}, []error{ErrNoPiece}},
+		{"zero drop", p, Move{0, 0, SlideUp, []byte{1, 0}}, []error{ErrZeroDrop, ErrBadCarry, ErrIllegalSlide}},
+		{"over carry", p, Move{0, 0, SlideUp, []byte{3, 3}}, []error{ErrCarryLimit, ErrBadCarry, ErrIllegalSlide}},
+		{"over height", p, Move{0, 0, SlideUp, []byte{3}}, []error{ErrStackHeight, ErrBadCarry, ErrIllegalSlide}},
+		{"wall", p, Move{0, 0, SlideRight, []byte{1}}, []error{ErrCannotFlatten, ErrIllegalSlide}},
+		{"capstone", p, Move{0, 0, SlideRight, []byte{2}}, []error{ErrCannotFlatten, ErrIllegalSlide}},
+		{"not owner", p, Move{1, 0, SlideUp, []byte{1}}, []error{ErrNotOwner, ErrIllegalSlide}},
+		{"opening", New(Config{Size: 5}), Move{0, 0, PlaceStanding, nil}, []error{ErrIllegalOpening}},
+		{"game over", over, Move{4, 4, PlaceFlat, nil}, []error{ErrGameOver}},
+	}
+	for _, tc := range cases {
+		_, e := tc.p.Move(&tc.m)
+		for _, want := range tc.errs {
+			if !errors.Is(e, want) {
+				t.Errorf("%s: err=%v, want %v", tc.name, e, want)
+			}
+		}
+		if _, pe := tc.p.MovePreallocated(&tc.m, New(Config{Size: 5})); pe != e {
+			t.Errorf("%s: MovePreallocated err=%v, Move err=%v", tc.name, pe, e)
+		}
+	}
+	for _, m := range []Move{{Type: Pass}, {0, 0, SlideUp, []byte{1}}} {
+		if _, e := over.MovePreallocated(&m, nil); e != ErrGameOver {
+			t.Errorf("game over: %#v: err=%v", m, e)
+		}
+	}
+	if errors.Is(ErrNoPiece, ErrNoCapstone) || errors.Is(ErrIllegalSlide, ErrBadCarry) {
+		t.Error("errors.Is matches a more specific error")
+	}
+}
+
+func TestEncodeMove(t *testing.T) {
+	var ms []Move
+	ms = append(ms, Move{}, Move{Type: Pass})
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			for _, typ := range []MoveType{PlaceFlat, PlaceStanding, PlaceCapstone} {
+				ms = append(ms, Move{X: x, Y: y, Type: typ})
+			}
+			// slides[8] holds every drop sequence of at
+			// most 8 stones.
+			for _, sl := range slides[8] {
+				for _, typ := range []MoveType{SlideLeft, SlideRight, SlideUp, SlideDown} {
+					ms = append(ms, Move{X: x, Y: y, Type: typ, Slides: sl})
+				}
+			}
+		}
+	}
+	seen := make(map[uint32]bool, len(ms))
+	for i := range ms {
+		m := &ms[i]
+		c := m.EncodeMove()
+		if c >= 1<<20 {
+			t.Errorf("%#v: encoding %x does not fit in 20 bits", m, c)
+		}
+		if seen[c] {
+			t.Errorf("%#v: duplicate encoding %x", m, c)
+		}
+		seen[c] = true
+		got := DecodeMove(c)
+		if !got.Equal(m) {
+			t.Errorf("%#v: round-tripped to %#v", m, got)
+		}
+	}
+	if c := (&Move{}).EncodeMove(); c != 0 {
+		t.Errorf("zero move encodes as %x", c)
+	}
+}
+
+func BenchmarkMoveTallStacks(b *testing.B) {
+	p := New(Config{Size: 5})
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			var sq Square
+			for h := 0; h < 7; h++ {
+				c := White
+				if (x+y+h)&1 != 0 {
+					c = Black
+				}
+				sq = append(sq, MakePiece(c, Flat))
+			}
+			set(p, x, y, sq)
+		}
+	}
+	p.move = 10
+	p.analyze()
+
+	var ms []Move
+	next := Alloc(5)
+	for _, m := range p.AllMoves(nil) {
+		if len(m.Slides) < 3 {
+			continue
+		}
+		if _, e := p.MovePreallocated(&m, next); e == nil {
+			ms = append(ms, m)
+		}
+	}
+	if len(ms) == 0 {
+		b.Fatal("no slides")
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.MovePreallocated(&ms[i%len(ms)], next)
+	}
+}
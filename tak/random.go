@@ -0,0 +1,28 @@
+package tak
+
+import "math/rand"
+
+// RandomPosition plays `plies` moves, each chosen uniformly from the
+// legal moves, from the start of a game with configuration `cfg`,
+// and returns the resulting position. If the game ends sooner, it
+// returns the final position. The same `rng` state always produces
+// the same position.
+func RandomPosition(cfg Config, plies int, rng *rand.Rand) *Position {
+	p := New(cfg)
+	var buf []Move
+	for ply := 0; ply < plies; ply++ {
+		if over, _ := p.GameOver(); over {
+			break
+		}
+		buf = p.AllMoves(buf[:0])
+		if len(buf) == 0 {
+			break
+		}
+		next, e := p.Move(&buf[rng.Intn(len(buf))])
+		if e != nil {
+			break
+		}
+		p = next
+	}
+	return p
+}
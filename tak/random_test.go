@@ -0,0 +1,108 @@
+package tak
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// checkPosition verifies the internal consistency of `p`: that its
+// bitboards, stacks and reserves describe a reachable set of pieces,
+// and that its incremental hash and analysis match ones computed
+// from scratch.
+func checkPosition(p *Position) error {
+	if p.White&p.Black != 0 {
+		return errors.New("square owned by both colors")
+	}
+	occ := p.White | p.Black
+	if occ&^p.cfg.c.Mask != 0 {
+		return errors.New("pieces off the board")
+	}
+	if (p.Standing|p.Caps)&^occ != 0 || p.Standing&p.Caps != 0 {
+		return errors.New("bad wall or capstone mask")
+	}
+
+	hash := uint64(fnvBasis)
+	var stones [2]int
+	for i := range p.Height {
+		bit := uint64(1) << uint(i)
+		if (occ&bit != 0) != (p.Height[i] > 0) {
+			return fmt.Errorf("square %d: height %d, occupied=%v",
+				i, p.Height[i], occ&bit != 0)
+		}
+		if p.Height[i] == 0 {
+			continue
+		}
+		if p.White&bit != 0 {
+			stones[0]++
+		} else {
+			stones[1]++
+		}
+		below := uint64(1)<<(p.Height[i]-1) - 1
+		black := bits.OnesCount64(p.Stacks[i] & below)
+		stones[1] += black
+		stones[0] += int(p.Height[i]) - 1 - black
+		hash ^= p.hashAt(uint(i))
+	}
+	if hash != p.hash {
+		return fmt.Errorf("hash=%x, recomputed=%x", p.hash, hash)
+	}
+
+	wcaps := bits.OnesCount64(p.Caps & p.White)
+	bcaps := bits.OnesCount64(p.Caps & p.Black)
+	if wcaps+p.WhiteCaps() != p.cfg.Capstones ||
+		bcaps+p.BlackCaps() != p.cfg.Capstones {
+		return fmt.Errorf("capstones: %d+%d white, %d+%d black",
+			wcaps, p.WhiteCaps(), bcaps, p.BlackCaps())
+	}
+	if stones[0]-wcaps+p.WhiteStones() != p.cfg.Pieces ||
+		stones[1]-bcaps+p.BlackStones() != p.cfg.Pieces {
+		return fmt.Errorf("stones: %d+%d white, %d+%d black",
+			stones[0]-wcaps, p.WhiteStones(), stones[1]-bcaps, p.BlackStones())
+	}
+
+	full := p.Clone()
+	full.analyze()
+	if !sameAnalysis(&p.analysis, &full.analysis) {
+		return fmt.Errorf("analysis=%+v, recomputed=%+v", p.analysis, full.analysis)
+	}
+	return nil
+}
+
+func sameAnalysis(a, b *Analysis) bool {
+	return a.WhiteFlat == b.WhiteFlat && a.BlackFlat == b.BlackFlat &&
+		a.WhiteWall == b.WhiteWall && a.BlackWall == b.BlackWall &&
+		a.WhiteCap == b.WhiteCap && a.BlackCap == b.BlackCap &&
+		sameGroups(a.WhiteGroups, b.WhiteGroups) &&
+		sameGroups(a.BlackGroups, b.BlackGroups)
+}
+
+func sameGroups(a, b []uint64) bool {
+	return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+}
+
+func TestRandomPosition(t *testing.T) {
+	r := rand.New(rand.NewSource(23))
+	for _, size := range []int{3, 4, 5, 6, 7, 8} {
+		for i := 0; i < 50; i++ {
+			plies := r.Intn(200)
+			p := RandomPosition(DefaultConfig(size), plies, r)
+			if e := checkPosition(p); e != nil {
+				t.Fatalf("size=%d plies=%d: %v", size, plies, e)
+			}
+			if over, _ := p.GameOver(); !over && p.MoveNumber() != plies {
+				t.Fatalf("size=%d: played %d plies of %d, but game is not over",
+					size, p.MoveNumber(), plies)
+			}
+		}
+	}
+
+	a := RandomPosition(DefaultConfig(5), 30, rand.New(rand.NewSource(1)))
+	b := RandomPosition(DefaultConfig(5), 30, rand.New(rand.NewSource(1)))
+	if !a.Equal(b) {
+		t.Errorf("same seed produced different positions")
+	}
+}
@@ -0,0 +1,222 @@
+package tak
+
+import "github.com/nelhage/taktician/bitboard"
+
+// RoadDistance returns the minimum number of empty squares color `c`
+// would need to fill to connect two opposite edges of the board with
+// its road pieces, ignoring anything the opponent might do. It
+// returns 0 if `c` already has a road, and -1 if `c` cannot complete
+// a road by placement alone, or if roads do not count.
+func (p *Position) RoadDistance(c Color) int {
+	if p.cfg.NoRoads {
+		return -1
+	}
+	var road uint64
+	if c == White {
+		road = p.White &^ p.Standing
+	} else {
+		road = p.Black &^ p.Standing
+	}
+	empty := p.cfg.c.Mask &^ (p.White | p.Black)
+
+	best := -1
+	for _, edges := range [2][2]uint64{
+		{p.cfg.c.L, p.cfg.c.R},
+		{p.cfg.c.B, p.cfg.c.T},
+	} {
+		d := roadDistance(&p.cfg.c, road, empty, edges[0], edges[1])
+		if d >= 0 && (best < 0 || d < best) {
+			best = d
+		}
+	}
+	return best
+}
+
+// roadDistance performs a breadth-first search outward from `start`,
+// where road squares are free to cross and each empty square costs
+// one move, and returns the cost to reach `end`.
+func roadDistance(c *bitboard.Constants, road, empty, start, end uint64) int {
+	reach := bitboard.Flood(c, road, start&road)
+	for d := 0; ; d++ {
+		if reach&end != 0 {
+			return d
+		}
+		seeds := reach | ((bitboard.Grow(c, c.Mask, reach) | start) & empty)
+		next := bitboard.Flood(c, road|seeds, seeds)
+		if next == reach {
+			return -1
+		}
+		reach = next
+	}
+}
+
+// HasImmediateRoadWin reports whether color `c` could complete a
+// road by placing a single stone, were it `c`'s turn to play. It does
+// not consider roads completed by slides.
+func (p *Position) HasImmediateRoadWin(c Color) bool {
+	if p.move < 2 || p.cfg.NoRoads {
+		return false
+	}
+	var road uint64
+	if c == White {
+		if p.whiteStones+p.whiteCaps == 0 {
+			return false
+		}
+		road = p.White &^ p.Standing
+	} else {
+		if p.blackStones+p.blackCaps == 0 {
+			return false
+		}
+		road = p.Black &^ p.Standing
+	}
+	empty := p.cfg.c.Mask &^ (p.White | p.Black)
+	cand := bitboard.Grow(&p.cfg.c, p.cfg.c.Mask, road) & empty
+	for cand != 0 {
+		next := cand & (cand - 1)
+		bit := cand &^ next
+		cand = next
+		if isRoad(&p.cfg.c, bitboard.Flood(&p.cfg.c, road|bit, bit)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRoad reports whether the group `g` connects two opposite edges.
+func isRoad(c *bitboard.Constants, g uint64) bool {
+	return ((g&c.T) != 0 && (g&c.B) != 0) ||
+		((g&c.L) != 0 && (g&c.R) != 0)
+}
+
+// MoveGivesOpponentRoad reports whether playing `m` would complete a
+// road for the opponent of the player to move, or leave the opponent
+// able to complete one by placing a single stone. It returns false if
+// `m` is illegal, or if it completes a road for the player making it.
+func (p *Position) MoveGivesOpponentRoad(m *Move) bool {
+	next, e := p.Move(m)
+	if e != nil {
+		return false
+	}
+	us := p.ToMove()
+	if c, ok := next.hasRoad(); ok {
+		return c != us
+	}
+	if over, _ := next.GameOver(); over {
+		return false
+	}
+	return next.HasImmediateRoadWin(us.Flip())
+}
+
+// WinningMoves appends to `buf` every legal move that completes a
+// road for the player to move, and returns the result. Placements are
+// found from the road bitboards directly; slides are only generated
+// along lines that could possibly complete a road, and every
+// candidate is played to confirm it.
+func (p *Position) WinningMoves(buf []Move) []Move {
+	if p.move < 2 || p.cfg.NoRoads {
+		return buf
+	}
+	if over, _ := p.GameOver(); over {
+		return buf
+	}
+	us := p.ToMove()
+	var mine uint64
+	var stones, caps int
+	if us == White {
+		mine, stones, caps = p.White, int(p.whiteStones), int(p.whiteCaps)
+	} else {
+		mine, stones, caps = p.Black, int(p.blackStones), int(p.blackCaps)
+	}
+	c := &p.cfg.c
+	road := mine &^ p.Standing
+	empty := c.Mask &^ (p.White | p.Black)
+
+	var next *Position
+	wins := func(m *Move) bool {
+		var e error
+		next, e = p.MovePreallocated(m, next)
+		if e != nil {
+			return false
+		}
+		winner, ok := next.hasRoad()
+		return ok && winner == us
+	}
+
+	cand := bitboard.Grow(c, c.Mask, road) & empty
+	for cand != 0 {
+		bit := cand & -cand
+		cand &^= bit
+		if !isRoad(c, bitboard.Flood(c, road|bit, bit)) {
+			continue
+		}
+		i := bitboard.Popcount(bit - 1)
+		x, y := i%p.cfg.Size, i/p.cfg.Size
+		for _, t := range [2]MoveType{PlaceFlat, PlaceCapstone} {
+			if (t == PlaceFlat && stones == 0) || (t == PlaceCapstone && caps == 0) {
+				continue
+			}
+			m := Move{X: x, Y: y, Type: t}
+			if wins(&m) {
+				buf = append(buf, m)
+			}
+		}
+	}
+
+	sz := p.cfg.Size
+	for src := mine; src != 0; {
+		bit := src & -src
+		src &^= bit
+		i := bitboard.Popcount(bit - 1)
+		x, y := i%sz, i/sz
+		h := int(p.Height[i])
+		if h > p.cfg.CarryLimit {
+			h = p.cfg.CarryLimit
+		}
+		for _, d := range [4]struct {
+			t      MoveType
+			dx, dy int
+		}{
+			{SlideLeft, -1, 0},
+			{SlideRight, 1, 0},
+			{SlideDown, 0, -1},
+			{SlideUp, 0, 1},
+		} {
+			// Only the squares a slide could cover can change
+			// hands, so if even claiming all of them leaves no
+			// road, no slide in this direction wins.
+			var line uint64
+			reach := 0
+			for j, cx, cy := 1, x+d.dx, y+d.dy; j <= h &&
+				cx >= 0 && cx < sz && cy >= 0 && cy < sz; j, cx, cy = j+1, cx+d.dx, cy+d.dy {
+				sq := uint64(1) << uint(cy*sz+cx)
+				if sq&p.Caps != 0 {
+					break
+				}
+				line |= sq
+				reach = j
+				if sq&p.Standing != 0 {
+					break
+				}
+			}
+			if reach == 0 || !canRoad(c, road|bit|line) {
+				continue
+			}
+			for _, s := range slides[h] {
+				if len(s) > reach {
+					continue
+				}
+				m := Move{X: x, Y: y, Type: d.t, Slides: s}
+				if wins(&m) {
+					buf = append(buf, m)
+				}
+			}
+		}
+	}
+	return buf
+}
+
+// canRoad reports whether any group within `bits` is a road.
+func canRoad(c *bitboard.Constants, bits uint64) bool {
+	return bitboard.Flood(c, bits, bits&c.L)&c.R != 0 ||
+		bitboard.Flood(c, bits, bits&c.B)&c.T != 0
+}
@@ -0,0 +1,285 @@
+package tak
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRoadDistance(t *testing.T) {
+	p := New(Config{Size: 5})
+	if d := p.RoadDistance(White); d != 5 {
+		t.Errorf("empty board: RoadDistance=%d", d)
+	}
+
+	for x := 0; x < 4; x++ {
+		set(p, x, 2, Square{MakePiece(White, Flat)})
+	}
+	p.analyze()
+	if d := p.RoadDistance(White); d != 1 {
+		t.Errorf("one move: RoadDistance=%d", d)
+	}
+
+	set(p, 4, 2, Square{MakePiece(Black, Flat)})
+	set(p, 2, 2, Square{MakePiece(White, Standing)})
+	p.analyze()
+	// c3 is blocked by our own wall and e3 by a black flat, so
+	// the shortest road detours through b2-e2.
+	if d := p.RoadDistance(White); d != 4 {
+		t.Errorf("detour: RoadDistance=%d", d)
+	}
+	if d := p.RoadDistance(Black); d != 4 {
+		t.Errorf("black: RoadDistance=%d", d)
+	}
+
+	p = New(Config{Size: 5})
+	for y := 0; y < 5; y++ {
+		if y == 1 || y == 3 {
+			continue
+		}
+		set(p, 1, y, Square{MakePiece(Black, Flat)})
+	}
+	p.analyze()
+	if d := p.RoadDistance(Black); d != 2 {
+		t.Errorf("two moves: RoadDistance=%d", d)
+	}
+	set(p, 1, 1, Square{MakePiece(Black, Capstone)})
+	set(p, 1, 3, Square{MakePiece(Black, Flat)})
+	p.analyze()
+	if d := p.RoadDistance(Black); d != 0 {
+		t.Errorf("road: RoadDistance=%d", d)
+	}
+
+	p = New(Config{Size: 3})
+	set(p, 0, 1, Square{MakePiece(Black, Standing)})
+	set(p, 1, 1, Square{MakePiece(Black, Standing)})
+	set(p, 2, 1, Square{MakePiece(Black, Standing)})
+	set(p, 1, 0, Square{MakePiece(Black, Standing)})
+	set(p, 1, 2, Square{MakePiece(Black, Standing)})
+	p.analyze()
+	if d := p.RoadDistance(White); d != -1 {
+		t.Errorf("blocked: RoadDistance=%d", d)
+	}
+}
+
+func TestHasImmediateRoadWin(t *testing.T) {
+	p := New(Config{Size: 5})
+	p.move = 10
+	for x := 0; x < 4; x++ {
+		set(p, x, 2, Square{MakePiece(White, Flat)})
+	}
+	set(p, 0, 0, Square{MakePiece(Black, Flat)})
+	set(p, 0, 1, Square{MakePiece(Black, Flat)})
+	set(p, 0, 3, Square{MakePiece(Black, Flat)})
+	p.analyze()
+	if !p.HasImmediateRoadWin(White) {
+		t.Error("white should win at e3")
+	}
+	if p.HasImmediateRoadWin(Black) {
+		t.Error("black has no road")
+	}
+
+	set(p, 4, 2, Square{MakePiece(Black, Standing)})
+	p.analyze()
+	if p.HasImmediateRoadWin(White) {
+		t.Error("e3 is blocked")
+	}
+
+	set(p, 0, 4, Square{MakePiece(Black, Flat)})
+	p.analyze()
+	if p.HasImmediateRoadWin(Black) {
+		t.Error("black is only blocked by a3")
+	}
+	set(p, 0, 2, nil)
+	p.analyze()
+	if !p.HasImmediateRoadWin(Black) {
+		t.Error("black should win at a3")
+	}
+	p.blackStones = 0
+	p.blackCaps = 0
+	if p.HasImmediateRoadWin(Black) {
+		t.Error("black has no stones left")
+	}
+}
+
+func naiveImmediateRoadWin(p *Position) bool {
+	for _, m := range p.AllMoves(nil) {
+		if m.IsSlide() {
+			continue
+		}
+		next, e := p.Move(&m)
+		if e != nil {
+			continue
+		}
+		if c, ok := next.hasRoad(); ok && c == p.ToMove() {
+			return true
+		}
+	}
+	return false
+}
+
+func roadWinBenchPosition() *Position {
+	p := New(Config{Size: 6})
+	p.move = 20
+	for i := 0; i < 5; i++ {
+		set(p, i, 1, Square{MakePiece(Black, Flat)})
+		set(p, i, 4, Square{MakePiece(White, Flat)})
+	}
+	set(p, 5, 4, Square{MakePiece(Black, Standing)})
+	p.analyze()
+	return p
+}
+
+func BenchmarkHasImmediateRoadWin(b *testing.B) {
+	p := roadWinBenchPosition()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.HasImmediateRoadWin(White)
+	}
+}
+
+func BenchmarkNaiveImmediateRoadWin(b *testing.B) {
+	p := roadWinBenchPosition()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		naiveImmediateRoadWin(p)
+	}
+}
+
+func TestMoveGivesOpponentRoad(t *testing.T) {
+	white, black := MakePiece(White, Flat), MakePiece(Black, Flat)
+	build := func(blacks []int) *Position {
+		p := New(Config{Size: 5})
+		p.move = 10
+		for _, x := range blacks {
+			set(p, x, 1, Square{black})
+		}
+		// White's flat covers one of black's on e2.
+		set(p, 4, 1, Square{white, black})
+		set(p, 2, 3, Square{white})
+		p.analyze()
+		return p
+	}
+	uncover := Move{X: 4, Y: 1, Type: SlideUp, Slides: []byte{1}}
+	place := Move{X: 0, Y: 4, Type: PlaceFlat}
+	cases := []struct {
+		name   string
+		blacks []int
+		m      Move
+		want   bool
+	}{
+		{"uncovers a road", []int{0, 1, 2, 3}, uncover, true},
+		{"uncovers a tak", []int{0, 1, 2}, uncover, true},
+		{"uncovers nothing", []int{0, 1}, uncover, false},
+		{"placement", []int{0, 1, 2, 3}, place, false},
+		{"illegal", []int{0, 1, 2, 3}, Move{X: 0, Y: 1, Type: PlaceFlat}, false},
+	}
+	for _, tc := range cases {
+		p := build(tc.blacks)
+		if got := p.MoveGivesOpponentRoad(&tc.m); got != tc.want {
+			t.Errorf("%s: MoveGivesOpponentRoad=%v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// A slide that completes both roads wins for the mover.
+	p := build([]int{0, 1, 2, 3})
+	for x := 0; x < 4; x++ {
+		set(p, x, 2, Square{white})
+	}
+	p.analyze()
+	if p.MoveGivesOpponentRoad(&uncover) {
+		t.Error("double road: the mover wins")
+	}
+}
+
+// bruteWinningMoves finds the winning moves by playing every legal
+// move, for comparison with WinningMoves.
+func bruteWinningMoves(p *Position) []Move {
+	var out []Move
+	for _, m := range p.AllMoves(nil) {
+		next, e := p.Move(&m)
+		if e != nil {
+			continue
+		}
+		if c, ok := next.hasRoad(); ok && c == p.ToMove() {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func sameMoves(a, b []Move) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[uint32]int)
+	for i := range a {
+		seen[a[i].EncodeMove()]++
+		seen[b[i].EncodeMove()]--
+	}
+	for _, n := range seen {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWinningMoves(t *testing.T) {
+	p := New(Config{Size: 5})
+	p.move = 10
+	if got := p.WinningMoves(nil); len(got) != 0 {
+		t.Errorf("empty board: WinningMoves=%#v", got)
+	}
+
+	for x := 0; x < 4; x++ {
+		set(p, x, 2, Square{MakePiece(White, Flat)})
+	}
+	set(p, 4, 3, Square{MakePiece(White, Flat), MakePiece(Black, Flat)})
+	set(p, 0, 0, Square{MakePiece(Black, Flat)})
+	p.analyze()
+	got := p.WinningMoves(nil)
+	want := bruteWinningMoves(p)
+	if !sameMoves(got, want) {
+		t.Errorf("e3: WinningMoves=%#v, want %#v", got, want)
+	}
+	// A flat or the capstone at e3 or d4, or e4 sliding down
+	// one or two stones.
+	if len(got) != 6 {
+		t.Errorf("e3: %d winning moves, want 6", len(got))
+	}
+
+	p.move = 11
+	if got := p.WinningMoves(nil); len(got) != 0 {
+		t.Errorf("black to move: WinningMoves=%#v", got)
+	}
+
+	p.move = 10
+	set(p, 4, 2, Square{MakePiece(Black, Standing)})
+	set(p, 3, 3, Square{MakePiece(Black, Standing)})
+	p.analyze()
+	if got := p.WinningMoves(nil); len(got) != 0 {
+		t.Errorf("e3 and d4 blocked: WinningMoves=%#v", got)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	wins := 0
+	for i := 0; i < 500; i++ {
+		size := 3 + i%4
+		p := RandomPosition(DefaultConfig(size), 10+r.Intn(40), r)
+		if over, _ := p.GameOver(); over {
+			continue
+		}
+		got, want := p.WinningMoves(nil), bruteWinningMoves(p)
+		if !sameMoves(got, want) {
+			t.Fatalf("random %d: WinningMoves=%#v, want %#v", i, got, want)
+		}
+		if len(got) > 0 {
+			wins++
+		}
+	}
+	if wins == 0 {
+		t.Error("no random position had a winning move")
+	}
+}
@@ -0,0 +1,146 @@
+package tak
+
+// A symmetry of the square board: an optional transposition,
+// followed by optional reflections in each axis. The eight
+// combinations form the symmetry group of the square.
+type symmetry struct {
+	transpose, flipX, flipY bool
+}
+
+var symmetries []symmetry
+
+func init() {
+	for i := 0; i < 8; i++ {
+		symmetries = append(symmetries, symmetry{
+			transpose: i&4 != 0,
+			flipX:     i&2 != 0,
+			flipY:     i&1 != 0,
+		})
+	}
+}
+
+func (s symmetry) apply(size, x, y int) (int, int) {
+	if s.transpose {
+		x, y = y, x
+	}
+	if s.flipX {
+		x = size - 1 - x
+	}
+	if s.flipY {
+		y = size - 1 - y
+	}
+	return x, y
+}
+
+func (s symmetry) applyMove(size int, m *Move) Move {
+	out := Move{Type: m.Type, Slides: m.Slides}
+	out.X, out.Y = s.apply(size, m.X, m.Y)
+	if !m.IsSlide() {
+		return out
+	}
+	dx, dy := 0, 0
+	switch m.Type {
+	case SlideLeft:
+		dx = -1
+	case SlideRight:
+		dx = 1
+	case SlideUp:
+		dy = 1
+	case SlideDown:
+		dy = -1
+	}
+	if s.transpose {
+		dx, dy = dy, dx
+	}
+	if s.flipX {
+		dx = -dx
+	}
+	if s.flipY {
+		dy = -dy
+	}
+	switch {
+	case dx < 0:
+		out.Type = SlideLeft
+	case dx > 0:
+		out.Type = SlideRight
+	case dy > 0:
+		out.Type = SlideUp
+	default:
+		out.Type = SlideDown
+	}
+	return out
+}
+
+// preserves reports whether the symmetry maps `p` onto itself.
+func (s symmetry) preserves(p *Position) bool {
+	sz := p.Size()
+	for y := 0; y < sz; y++ {
+		for x := 0; x < sz; x++ {
+			tx, ty := s.apply(sz, x, y)
+			i := uint(x + y*sz)
+			j := uint(tx + ty*sz)
+			for _, bb := range [4]uint64{p.White, p.Black, p.Standing, p.Caps} {
+				if (bb>>i)&1 != (bb>>j)&1 {
+					return false
+				}
+			}
+			if p.Height[i] != p.Height[j] || p.Stacks[i] != p.Stacks[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// lessMove is an arbitrary total order on moves, used to pick a
+// representative of a set of symmetric moves.
+func lessMove(l, r *Move) bool {
+	if l.Y != r.Y {
+		return l.Y < r.Y
+	}
+	if l.X != r.X {
+		return l.X < r.X
+	}
+	if l.Type != r.Type {
+		return l.Type < r.Type
+	}
+	for i := 0; i < len(l.Slides) && i < len(r.Slides); i++ {
+		if l.Slides[i] != r.Slides[i] {
+			return l.Slides[i] < r.Slides[i]
+		}
+	}
+	return len(l.Slides) < len(r.Slides)
+}
+
+// UniqueMoves appends to `buf` the moves in `p` that are distinct
+// under the symmetries of the position: of each set of moves that
+// lead to positions that are reflections or rotations of each other,
+// only one is returned.
+func (p *Position) UniqueMoves(buf []Move) []Move {
+	var syms []symmetry
+	for _, s := range symmetries[1:] {
+		if s.preserves(p) {
+			syms = append(syms, s)
+		}
+	}
+	start := len(buf)
+	buf = p.AllMoves(buf)
+	if len(syms) == 0 {
+		return buf
+	}
+	out := buf[:start]
+	for _, m := range buf[start:] {
+		m := m
+		canonical := true
+		for _, s := range syms {
+			if rm := s.applyMove(p.Size(), &m); lessMove(&rm, &m) {
+				canonical = false
+				break
+			}
+		}
+		if canonical {
+			out = append(out, m)
+		}
+	}
+	return out
+}
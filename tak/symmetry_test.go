@@ -0,0 +1,103 @@
+package tak
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestUniqueMovesEmpty(t *testing.T) {
+	p := New(Config{Size: 5})
+	var got []string
+	for _, m := range p.UniqueMoves(nil) {
+		if m.Type != PlaceFlat {
+			t.Errorf("bad opening move: %#v", m)
+		}
+		got = append(got, string([]byte{byte('a' + m.X), byte('1' + m.Y)}))
+	}
+	sort.Strings(got)
+	expect := []string{"a1", "b1", "b2", "c1", "c2", "c3"}
+	if len(got) != len(expect) {
+		t.Fatalf("UniqueMoves()=%v, want %v", got, expect)
+	}
+	for i := range got {
+		if got[i] != expect[i] {
+			t.Fatalf("UniqueMoves()=%v, want %v", got, expect)
+		}
+	}
+}
+
+func TestUniqueMovesSymmetries(t *testing.T) {
+	p := New(Config{Size: 5})
+	p.move = 4
+	set(p, 2, 2, Square{MakePiece(White, Flat), MakePiece(Black, Flat)})
+	p.analyze()
+
+	all := p.AllMoves(nil)
+	unique := p.UniqueMoves(nil)
+	// The 24 empty squares fall into 5 orbits, each with three
+	// kinds of placement, and the four directions of each of
+	// the three slides from the center are all equivalent.
+	placements := 3 * 5
+	slides := 3
+	if len(unique) != placements+slides {
+		t.Errorf("unique=%d all=%d", len(unique), len(all))
+	}
+
+	set(p, 0, 1, Square{MakePiece(Black, Flat)})
+	p.analyze()
+	if u, a := len(p.UniqueMoves(nil)), len(p.AllMoves(nil)); u != a {
+		t.Errorf("asymmetric position: unique=%d all=%d", u, a)
+	}
+}
+
+func TestCanonicalHash(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for _, size := range []int{3, 5, 6} {
+		p := New(Config{Size: size})
+		images := make([]*Position, len(symmetries))
+		for i := range images {
+			images[i] = p
+		}
+		for ply := 0; ply < 60; ply++ {
+			if over, _ := p.GameOver(); over {
+				break
+			}
+			want := p.CanonicalHash()
+			for i, img := range images {
+				if h := img.CanonicalHash(); h != want {
+					t.Fatalf("size=%d ply=%d: symmetry %+v: CanonicalHash=%x, want %x",
+						size, ply, symmetries[i], h, want)
+				}
+				if img.Hash() != symmetries[i].hash(p) {
+					t.Fatalf("size=%d ply=%d: symmetry %+v: hash of image=%x, want %x",
+						size, ply, symmetries[i], img.Hash(), symmetries[i].hash(p))
+				}
+			}
+
+			moves := p.AllMoves(nil)
+			var next *Position
+			var m Move
+			for {
+				m = moves[r.Intn(len(moves))]
+				var e error
+				if next, e = p.Move(&m); e == nil {
+					break
+				}
+			}
+			for i, img := range images {
+				rm := symmetries[i].applyMove(size, &m)
+				var e error
+				if images[i], e = img.Move(&rm); e != nil {
+					t.Fatalf("size=%d ply=%d: symmetric move: %v", size, ply, e)
+				}
+			}
+			p = next
+		}
+		q := New(Config{Size: size})
+		q.move = p.move
+		if q.CanonicalHash() == p.CanonicalHash() {
+			t.Errorf("size=%d: distinct positions share a CanonicalHash", size)
+		}
+	}
+}
@@ -68,3 +68,139 @@ func BenchmarkPuzzle1(b *testing.B) {
 		mm.GetMove(context.Background(), p)
 	}
 }
+
+// benchPositions is a fixed set of positions for the search
+// benchmarks, from the opening to a crowded late middlegame.
+var benchPositions = []string{
+	`x5/x5/x2,1,x2/x5/2,x4 1 3`,
+	`x5/x3,2,x/x2,1C,1,2/x2,2,1,1/2,x2,2C,1 1 6`,
+	`x3,2,x/x4,12/1,1,x,1,21C/x,1,x,12111112C,2/2,x,22121,x,2 2 20`,
+	`112S,12,1112S,x2/x2,121C,12S,x/1,21,2,2,2/x,2,1,1,1/2,x3,21 2 24`,
+	`x6/x6/x2,1,2,x2/x2,2,1,1,x/x,2,x4/x6 2 4`,
+}
+
+func parseBenchPositions(b *testing.B) []*tak.Position {
+	var ps []*tak.Position
+	for i, tps := range benchPositions {
+		p, e := ptn.ParseTPS(tps)
+		if e != nil {
+			b.Fatalf("%d: tps: %v", i, e)
+		}
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+// reportNPS reports `nodes` searched over the benchmark as both a
+// per-op count, which is deterministic, and a rate.
+func reportNPS(b *testing.B, nodes uint64) {
+	b.ReportMetric(float64(nodes)/float64(b.N), "nodes/op")
+	b.ReportMetric(float64(nodes)/b.Elapsed().Seconds(), "nodes/s")
+}
+
+func BenchmarkAnalyze(b *testing.B) {
+	ps := parseBenchPositions(b)
+	var nodes uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range ps {
+			// Allocating the table dominates a shallow
+			// search; leave it out of the rate.
+			b.StopTimer()
+			mm := ai.NewMinimax(ai.MinimaxConfig{
+				Depth: 4,
+				Seed:  *seed,
+				Size:  p.Size(),
+			})
+			b.StartTimer()
+			_, _, st := mm.Analyze(context.Background(), p)
+			nodes += st.Visited + st.Evaluated
+		}
+	}
+	reportNPS(b, nodes)
+}
+
+func BenchmarkMoveGen(b *testing.B) {
+	ps := parseBenchPositions(b)
+	var bufs [][]tak.Move
+	var next []*tak.Position
+	for _, p := range ps {
+		bufs = append(bufs, make([]tak.Move, 0, 500))
+		next = append(next, tak.Alloc(p.Size()))
+	}
+	var nodes uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, p := range ps {
+			bufs[j] = p.AllMoves(bufs[j][:0])
+			for k := range bufs[j] {
+				if _, e := p.MovePreallocated(&bufs[j][k], next[j]); e == nil {
+					nodes++
+				}
+			}
+		}
+	}
+	reportNPS(b, nodes)
+}
+
+func BenchmarkEvaluate(b *testing.B) {
+	ps := parseBenchPositions(b)
+	var ais []*ai.MinimaxAI
+	for _, p := range ps {
+		ais = append(ais, ai.NewMinimax(ai.MinimaxConfig{
+			Depth:   1,
+			Size:    p.Size(),
+			NoTable: true,
+		}))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, p := range ps {
+			ais[j].Evaluate(p)
+		}
+	}
+	reportNPS(b, uint64(b.N*len(ps)))
+}
+
+// perft counts the positions reachable from `p` in exactly `depth`
+// plies, stopping at positions where the game is over.
+func perft(p *tak.Position, depth int, bufs [][]tak.Move, next []*tak.Position) uint64 {
+	if depth == 0 {
+		return 1
+	}
+	if over, _ := p.GameOver(); over {
+		return 0
+	}
+	bufs[depth] = p.AllMoves(bufs[depth][:0])
+	var n uint64
+	for i := range bufs[depth] {
+		child, e := p.MovePreallocated(&bufs[depth][i], next[depth])
+		if e != nil {
+			continue
+		}
+		n += perft(child, depth-1, bufs, next)
+	}
+	return n
+}
+
+func BenchmarkPerft(b *testing.B) {
+	const depth = 3
+	ps := parseBenchPositions(b)
+	var nodes uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, p := range ps {
+			bufs := make([][]tak.Move, depth+1)
+			next := make([]*tak.Position, depth+1)
+			for d := range next {
+				next[d] = tak.Alloc(p.Size())
+			}
+			nodes += perft(p, depth, bufs, next)
+		}
+	}
+	reportNPS(b, nodes)
+}